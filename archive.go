@@ -0,0 +1,106 @@
+package memfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Keys of the storage settings and of the plaintext size of files recorded
+// in archives written by ExportToZip and ExportToTar. Tar archives hold them
+// as PAX records; ZIP archives as "key=value" in the archive and file comments.
+const (
+	archiveSettingsKey = "MEMFS.settings"
+	archiveSizeKey     = "MEMFS.size"
+)
+
+// archiveSettings are the settings that the content of files is stored with,
+// recorded in archives that hold the content as is, so that it can be read
+// again once imported
+type archiveSettings struct {
+	Cipher           string `json:",omitempty"`
+	PerFileKeys      bool   `json:",omitempty"`
+	BoundPaths       bool   `json:",omitempty"`
+	HMAC             bool   `json:",omitempty"`
+	Compressed       bool   `json:",omitempty"`
+	CompressionLevel int    `json:",omitempty"`
+	Chunked          bool   `json:",omitempty"`
+}
+
+// archiveSettings returns the storage settings of the filesystem, encoded for an archive
+func (rootFS *FS) archiveSettings() (string, error) {
+	rootFS.mu.Lock()
+	enc := rootFS.encryptor
+	settings := archiveSettings{
+		Cipher:           rootFS.cipher,
+		PerFileKeys:      rootFS.perFileKeys,
+		BoundPaths:       rootFS.boundPaths,
+		HMAC:             enc.macKey != nil || enc.macRequired,
+		Compressed:       rootFS.compress,
+		CompressionLevel: rootFS.compressLevel,
+		Chunked:          rootFS.chunked,
+	}
+	rootFS.mu.Unlock()
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// applyArchiveSettings configures a new filesystem to read content stored
+// with the settings recorded in an archive. Like with LoadFrom, the keys are
+// not part of the settings and have to be set before the content is read.
+func (rootFS *FS) applyArchiveSettings(value string) error {
+	var settings archiveSettings
+	if err := json.Unmarshal([]byte(value), &settings); err != nil {
+		return fmt.Errorf("invalid storage settings in archive: %w: %w", fs.ErrInvalid, err)
+	}
+	if err := checkCipher(settings.Cipher); err != nil {
+		return err
+	}
+
+	enc := &encryptor{enable: false}
+	enc.setCompression(settings.Compressed, settings.CompressionLevel)
+	if settings.HMAC {
+		enc.requireMAC()
+	}
+
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	rootFS.encryptor = enc
+	rootFS.cipher = settings.Cipher
+	rootFS.perFileKeys = settings.PerFileKeys
+	rootFS.boundPaths = settings.BoundPaths
+	rootFS.macRequired = settings.HMAC
+	rootFS.compress = settings.Compressed
+	rootFS.compressLevel = settings.CompressionLevel
+	rootFS.chunked = settings.Chunked
+	return nil
+}
+
+// archiveComment returns the ZIP comment that records value under key
+func archiveComment(key, value string) string {
+	return key + "=" + value
+}
+
+// parseArchiveComment returns the value recorded under key in a ZIP comment
+func parseArchiveComment(comment, key string) (string, bool) {
+	return strings.CutPrefix(comment, key+"=")
+}
+
+// parseArchiveSize returns the plaintext size of a file recorded in an
+// archive, or 0 if it is unknown
+func parseArchiveSize(value string, ok bool) (int64, error) {
+	if !ok {
+		return 0, nil
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid file size in archive: %s: %w", value, fs.ErrInvalid)
+	}
+	return size, nil
+}
@@ -0,0 +1,289 @@
+package memfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestChmodChown(t *testing.T) {
+	rootFS := New()
+
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rootFS.Chmod("dir/file.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Chmod("dir", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Chown("dir/file.txt", 1000, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Chown("dir", 1001, 101); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat(rootFS, "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0600 {
+		t.Errorf("Expected file mode 0600, got %v", info.Mode())
+	}
+	owner, ok := info.Sys().(*Owner)
+	if !ok {
+		t.Fatalf("Expected *Owner from Sys, got %T", info.Sys())
+	}
+	if owner.Uid != 1000 || owner.Gid != 100 {
+		t.Errorf("Expected owner 1000:100, got %d:%d", owner.Uid, owner.Gid)
+	}
+
+	info, err = fs.Stat(rootFS, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != fs.ModeDir|0700 {
+		t.Errorf("Expected dir mode drwx------, got %v", info.Mode())
+	}
+	owner = info.Sys().(*Owner)
+	if owner.Uid != 1001 || owner.Gid != 101 {
+		t.Errorf("Expected owner 1001:101, got %d:%d", owner.Uid, owner.Gid)
+	}
+
+	// Metadata survives save/load
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = fs.Stat(loadedFS, "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0600 || info.Sys().(*Owner).Uid != 1000 {
+		t.Errorf("Expected mode and owner after load, got %v %+v", info.Mode(), info.Sys())
+	}
+
+	// The root is a no-op and missing paths fail
+	if err := rootFS.Chmod(".", 0700); err != nil {
+		t.Errorf("Expected Chmod on root to be a no-op, got: %v", err)
+	}
+	if err := rootFS.Chown(".", 1, 1); err != nil {
+		t.Errorf("Expected Chown on root to be a no-op, got: %v", err)
+	}
+	if err := rootFS.Chmod("missing", 0700); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got: %v", err)
+	}
+	if err := rootFS.Chown("dir/missing", 1, 1); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got: %v", err)
+	}
+}
+
+func TestChtimes(t *testing.T) {
+	rootFS := New()
+
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	atime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	mtime := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+
+	if err := rootFS.Chtimes("dir/file.txt", atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Chtimes("dir", time.Time{}, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"dir/file.txt", "dir"} {
+		info, err := fs.Stat(rootFS, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("Expected mod time %v for %s, got %v", mtime, path, info.ModTime())
+		}
+	}
+
+	child, err := rootFS.get("dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !child.(*File).AccessTime.Equal(atime) {
+		t.Errorf("Expected access time %v, got %v", atime, child.(*File).AccessTime)
+	}
+
+	// Zero values leave the times unchanged
+	if err := rootFS.Chtimes("dir/file.txt", time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat(rootFS, "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("Expected mod time to stay %v, got %v", mtime, info.ModTime())
+	}
+
+	if err := rootFS.Chtimes("missing", atime, mtime); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got: %v", err)
+	}
+
+	if err := fstest.TestFS(rootFS, "dir/file.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirModTime(t *testing.T) {
+	rootFS := New()
+	for _, dir := range []string{"dir", "other"} {
+		if err := rootFS.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	modTime := func(path string) time.Time {
+		t.Helper()
+		info, err := rootFS.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.ModTime()
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		changed bool
+		op      func() error
+	}{
+		{"create file", "dir", true, func() error {
+			return rootFS.WriteFile("dir/file.txt", []byte("content"), 0644)
+		}},
+		{"overwrite file", "dir", false, func() error {
+			return rootFS.WriteFile("dir/file.txt", []byte("other content"), 0644)
+		}},
+		{"chmod file", "dir", false, func() error {
+			return rootFS.Chmod("dir/file.txt", 0600)
+		}},
+		{"create directory", "dir", true, func() error {
+			return rootFS.MkdirAll("dir/sub/deeper", 0755)
+		}},
+		{"existing directory", "dir", false, func() error {
+			return rootFS.MkdirAll("dir/sub", 0755)
+		}},
+		{"create symlink", "dir", true, func() error {
+			return rootFS.Symlink("file.txt", "dir/link")
+		}},
+		{"remove file", "dir", true, func() error {
+			return rootFS.Remove("dir/file.txt")
+		}},
+		{"remove tree", "dir", true, func() error {
+			return rootFS.RemoveAll("dir/sub")
+		}},
+		{"remove missing", "dir", false, func() error {
+			return rootFS.RemoveAll("dir/missing")
+		}},
+		{"move out", "dir", true, func() error {
+			return rootFS.MoveAll("dir/link", "link")
+		}},
+		{"move in", "other", true, func() error {
+			return rootFS.MoveAll("link", "other/link")
+		}},
+	}
+	for _, tt := range tests {
+		if err := rootFS.Chtimes(tt.dir, time.Time{}, old); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := tt.op(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := modTime(tt.dir); got.Equal(old) == tt.changed {
+			t.Errorf("%s: expected the mod time of %s to change: %v, got %v", tt.name, tt.dir, tt.changed, got)
+		}
+	}
+}
+
+// accessTimer is implemented by the fs.FileInfo of files and directories
+type accessTimer interface {
+	AccessTime() time.Time
+}
+
+func TestAccessTime(t *testing.T) {
+	rootFS := New()
+	if err := rootFS.WriteFile("file.txt", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	atime := func(fsys *FS) time.Time {
+		t.Helper()
+		info, err := fsys.Stat("file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.(accessTimer).AccessTime()
+	}
+
+	if got := atime(rootFS); !got.IsZero() {
+		t.Fatalf("Expected no access time before the file is read, got %v", got)
+	}
+
+	before := time.Now()
+	f, err := rootFS.Open("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	opened := atime(rootFS)
+	if opened.Before(before) {
+		t.Fatalf("Expected Open to set the access time, got %v", opened)
+	}
+
+	if _, err := rootFS.ReadFile("file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := atime(rootFS); got.Before(opened) {
+		t.Fatalf("Expected ReadFile to update the access time, got %v", got)
+	}
+
+	// Access times are saved with the filesystem
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := atime(loaded), atime(rootFS); !got.Equal(want) {
+		t.Fatalf("Expected access time %v after loading, got %v", want, got)
+	}
+
+	noAtime := New(WithNoAtime())
+	if err := noAtime.WriteFile("file.txt", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadFile(noAtime, "file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := atime(noAtime); !got.IsZero() {
+		t.Fatalf("Expected no access time with WithNoAtime, got %v", got)
+	}
+}
@@ -0,0 +1,201 @@
+package memfs
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// frameSize is the amount of plaintext sealed in one frame, see WithChunkedEncryption
+const frameSize = 64 * 1024
+
+// frameAD returns the data authenticated with frame i of the file at path.
+// The frame number keeps frames from being reordered, and the flag marking
+// the last frame keeps the content from being cut off at a frame boundary.
+func (e *encryptor) frameAD(path string, i int, last bool) []byte {
+	ad := binary.BigEndian.AppendUint64(e.additionalData(path), uint64(i))
+	if last {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+// frameOverhead returns the number of bytes added to every frame
+func (e *encryptor) frameOverhead() int {
+	return e.gcm.NonceSize() + e.gcm.Overhead()
+}
+
+// storedFrames returns the number of frames of n bytes of stored frames
+func (e *encryptor) storedFrames(n int) int {
+	stored := frameSize + e.frameOverhead()
+	return (n + stored - 1) / stored
+}
+
+// sealFrames encrypts plaintext in frames of frameSize bytes, each with its
+// own random nonce
+func (e *encryptor) sealFrames(aead cipher.AEAD, path string, plaintext []byte) ([]byte, error) {
+	count := (len(plaintext) + frameSize - 1) / frameSize
+	nonceSize := aead.NonceSize()
+	out := make([]byte, 0, len(plaintext)+count*(nonceSize+aead.Overhead()))
+
+	for i := range count {
+		chunk := plaintext[i*frameSize : min((i+1)*frameSize, len(plaintext))]
+
+		nonce := out[len(out) : len(out)+nonceSize]
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, err
+		}
+		out = aead.Seal(out[:len(out)+nonceSize], nonce, chunk, e.frameAD(path, i, i == count-1))
+	}
+	return out, nil
+}
+
+// openFrame decrypts frame i of the count frames in content
+func (e *encryptor) openFrame(aead cipher.AEAD, path string, content []byte, i, count int) ([]byte, error) {
+	stored := frameSize + aead.NonceSize() + aead.Overhead()
+	start := i * stored
+	end := min(start+stored, len(content))
+	return openSealed(aead, content[start:end], e.frameAD(path, i, i == count-1))
+}
+
+// openFrames decrypts all frames of content
+func (e *encryptor) openFrames(aead cipher.AEAD, path string, content []byte) ([]byte, error) {
+	count := e.storedFrames(len(content))
+	plaintext := make([]byte, 0, max(len(content)-count*e.frameOverhead(), 0))
+	for i := range count {
+		chunk, err := e.openFrame(aead, path, content, i, count)
+		if err != nil {
+			return nil, err
+		}
+		plaintext = append(plaintext, chunk...)
+	}
+	return plaintext, nil
+}
+
+// openStream returns a reader that decrypts the content of file one frame at
+// a time, or nil if the content is not stored in frames or is compressed.
+// An error is returned if the HMAC of the content does not match.
+func (rootFS *FS) openStream(file *File) (*frameReader, error) {
+	rootFS.mu.Lock()
+	content := file.Content
+	writing := file.writers > 0
+	enc := rootFS.encryptor
+	keyPath := file.keyPath
+	rootFS.mu.Unlock()
+
+	if writing || enc == nil || !enc.chunked || !enc.encrypts() || enc.compress || len(content) == 0 {
+		return nil, nil
+	}
+
+	content, err := enc.checkMAC(keyPath, content)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := enc.aead(keyPath)
+	if err != nil {
+		return nil, &decryptionError{path: keyPath, err: err}
+	}
+
+	count := enc.storedFrames(len(content))
+	return &frameReader{
+		enc:     enc,
+		aead:    aead,
+		path:    keyPath,
+		content: content,
+		count:   count,
+		size:    int64(max(len(content)-count*enc.frameOverhead(), 0)),
+		cached:  -1,
+	}, nil
+}
+
+// frameReader reads content stored in frames, decrypting a frame when it is
+// first read. Only the most recently read frame is kept in memory.
+type frameReader struct {
+	enc     *encryptor
+	aead    cipher.AEAD
+	path    string
+	content []byte // stored frames, without the HMAC
+	count   int    // number of frames
+	size    int64  // size of the plaintext
+	off     int64
+
+	cached int // index of the frame held in plain, -1 if none
+	plain  []byte
+}
+
+// frame returns the plaintext of frame i
+func (r *frameReader) frame(i int) ([]byte, error) {
+	if i != r.cached {
+		plain, err := r.enc.openFrame(r.aead, r.path, r.content, i, r.count)
+		if err != nil {
+			return nil, &decryptionError{path: r.path, err: err}
+		}
+		r.plain, r.cached = plain, i
+	}
+	return r.plain, nil
+}
+
+// ReadAt reads len(b) bytes of plaintext starting at off
+func (r *frameReader) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset: %d: %w", off, fs.ErrInvalid)
+	}
+
+	n := 0
+	for n < len(b) && off < r.size {
+		i := int(off / frameSize)
+		plain, err := r.frame(i)
+		if err != nil {
+			return n, err
+		}
+		m := copy(b[n:], plain[off-int64(i)*frameSize:])
+		n += m
+		off += int64(m)
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read reads plaintext from the current offset
+func (r *frameReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := r.ReadAt(b, r.off)
+	r.off += int64(n)
+	if n > 0 && errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read like bytes.Reader does
+func (r *frameReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.off + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence: %d: %w", whence, fs.ErrInvalid)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position: %d: %w", abs, fs.ErrInvalid)
+	}
+	r.off = abs
+	return abs, nil
+}
+
+// Size returns the size of the plaintext
+func (r *frameReader) Size() int64 {
+	return r.size
+}
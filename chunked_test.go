@@ -0,0 +1,146 @@
+package memfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// TestChunkedEncryption tests encrypting content in frames with WithChunkedEncryption
+func TestChunkedEncryption(t *testing.T) {
+	key := []byte("chunked key")
+	content := make([]byte, 3*frameSize+1000)
+	rand.Read(content)
+
+	rootFS := New(WithEncryption(key), WithChunkedEncryption())
+	if err := rootFS.WriteFile("big.bin", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fw, err := rootFS.Create("written.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := rootFS.dir.Children["big.bin"].(*File).Content
+	if want := rootFS.encryptor.ciphertextSize(len(content)); len(stored) != want {
+		t.Fatalf("Expected %d bytes stored, got %d", want, len(stored))
+	}
+	if used := rootFS.UsedStorage(); used != 2*int64(len(stored)) {
+		t.Fatalf("Expected %d bytes used, got %d", 2*len(stored), used)
+	}
+
+	for _, path := range []string{"big.bin", "written.bin"} {
+		got, err := rootFS.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("Content mismatch for %s", path)
+		}
+	}
+
+	// Handles decrypt frame by frame
+	f, err := rootFS.Open("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(content)) {
+		t.Fatalf("Expected size %d, got %d", len(content), info.Size())
+	}
+	off := int64(2*frameSize - 10)
+	if _, err := f.(io.Seeker).Seek(off, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	part := make([]byte, 20)
+	if _, err := io.ReadFull(f, part); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, content[off:off+20]) {
+		t.Fatal("Content mismatch across a frame boundary")
+	}
+	if _, err := f.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("Content mismatch when reading the handle")
+	}
+	f.Close()
+
+	// Saved filesystems keep the frames
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyArchive(bytes.NewReader(buf.Bytes()), key); err != nil {
+		t.Fatalf("Failed to verify archive: %v", err)
+	}
+	loadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadedFS.SetEncryptionKey(key); err != nil {
+		t.Fatal(err)
+	}
+	got, err = loadedFS.ReadFile("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("Content mismatch after loading")
+	}
+}
+
+// TestChunkedEncryptionTampered tests that reordered and cut off frames are detected
+func TestChunkedEncryptionTampered(t *testing.T) {
+	content := make([]byte, 2*frameSize+100)
+	rand.Read(content)
+
+	rootFS := New(WithEncryption([]byte("chunked key")), WithChunkedEncryption())
+	if err := rootFS.WriteFile("big.bin", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := rootFS.dir.Children["big.bin"].(*File)
+	stored := bytes.Clone(file.Content)
+	frame := frameSize + rootFS.encryptor.frameOverhead()
+
+	swapped := bytes.Clone(stored)
+	copy(swapped[:frame], stored[frame:2*frame])
+	copy(swapped[frame:2*frame], stored[:frame])
+
+	for name, tampered := range map[string][]byte{
+		"reordered": swapped,
+		"cut off":   stored[:2*frame],
+	} {
+		rootFS.mu.Lock()
+		file.Content = tampered
+		rootFS.mu.Unlock()
+
+		if _, err := rootFS.ReadFile("big.bin"); !errors.Is(err, ErrDecryption) {
+			t.Fatalf("Expected ErrDecryption reading %s frames, got: %v", name, err)
+		}
+		f, err := rootFS.Open("big.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(f); !errors.Is(err, ErrDecryption) {
+			t.Fatalf("Expected ErrDecryption streaming %s frames, got: %v", name, err)
+		}
+		f.Close()
+	}
+}
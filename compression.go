@@ -0,0 +1,73 @@
+package memfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// setCompression enables gzip compression of content at the given level
+func (e *encryptor) setCompression(enabled bool, level int) {
+	if !enabled {
+		return
+	}
+	e.compress = true
+	e.level = level
+	e.enable = true
+}
+
+// storedRaw marks content that is stored uncompressed, as compressing it did
+// not make it smaller. Gzip data starts with 0x1f, so the marker cannot be
+// mistaken for compressed content, including that of older snapshots.
+const storedRaw = 0x00
+
+// compressContent returns the gzip compressed plaintext of a file, or the
+// plaintext after the storedRaw marker if it does not compress, like tiny or
+// already compressed files
+func (e *encryptor) compressContent(plaintext []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&buf, e.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gw.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() > len(plaintext) {
+		return append([]byte{storedRaw}, plaintext...), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressContent returns the plaintext of the compressed content of the
+// file at path
+func (e *encryptor) decompressContent(path string, content []byte) ([]byte, error) {
+	if len(content) > 0 && content[0] == storedRaw {
+		return bytes.Clone(content[1:]), nil
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("decompression failed: %s: %w: %w", path, fs.ErrInvalid, err)
+	}
+	defer gr.Close()
+
+	plaintext, err := io.ReadAll(gr)
+	if err != nil {
+		return nil, fmt.Errorf("decompression failed: %s: %w: %w", path, fs.ErrInvalid, err)
+	}
+	return plaintext, nil
+}
+
+// checkCompressionLevel returns an error if level is not a valid gzip level
+func checkCompressionLevel(level int) error {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return fmt.Errorf("invalid compression level: %d: %w", level, fs.ErrInvalid)
+	}
+	return nil
+}
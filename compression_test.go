@@ -3,8 +3,13 @@ package memfs
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/rand"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"path/filepath"
 	"testing"
 )
 
@@ -154,3 +159,329 @@ func TestLargeData(t *testing.T) {
 		}
 	}
 }
+
+// TestCompressAndSaveToLevel tests saving with a chosen compression level
+func TestCompressAndSaveToLevel(t *testing.T) {
+	rootFS := New()
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 1000)
+	if err := rootFS.WriteFile("text.txt", text, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := make(map[int]int)
+	for _, level := range []int{gzip.NoCompression, gzip.BestSpeed, gzip.BestCompression} {
+		var buf bytes.Buffer
+		if err := rootFS.CompressAndSaveToLevel(&buf, level); err != nil {
+			t.Fatalf("Failed to save with level %d: %v", level, err)
+		}
+		sizes[level] = buf.Len()
+
+		loadedFS, err := DecompressAndLoadFrom(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := loadedFS.ReadFile("text.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content, text) {
+			t.Fatalf("Content mismatch after loading level %d", level)
+		}
+	}
+	if sizes[gzip.BestCompression] >= sizes[gzip.NoCompression] {
+		t.Fatalf("Expected BestCompression to be smaller than NoCompression, got %v", sizes)
+	}
+
+	var buf bytes.Buffer
+	if err := rootFS.CompressAndSaveToLevel(&buf, 42); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid for an invalid level, got: %v", err)
+	}
+
+	// Like with NewGzipWriter, the underlying writer is closed
+	for _, save := range []func(io.Writer) error{
+		rootFS.CompressAndSaveTo,
+		func(w io.Writer) error { return rootFS.CompressAndSaveToLevel(w, gzip.BestSpeed) },
+	} {
+		closed := false
+		w := &MockWriteCloser{
+			writeFunc: func(p []byte) (int, error) { return len(p), nil },
+			closeFunc: func() error {
+				closed = true
+				return nil
+			},
+		}
+		if err := save(w); err != nil {
+			t.Fatal(err)
+		}
+		if !closed {
+			t.Fatal("Expected the underlying writer to be closed")
+		}
+	}
+}
+
+// TestSaveZstdRoundTrip tests saving and loading a filesystem with zstd
+func TestSaveZstdRoundTrip(t *testing.T) {
+	rootFS := New()
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 1000)
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/text.txt", text, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := rootFS.SaveZstdTo(&buf); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	if buf.Len() >= len(text) {
+		t.Fatalf("Expected compressed size below %d, got %d", len(text), buf.Len())
+	}
+
+	loadedFS, err := LoadZstdFrom(&buf)
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	content, err := loadedFS.ReadFile("dir/text.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, text) {
+		t.Fatal("Content mismatch after loading")
+	}
+
+	// A gzip stream is not a zstd stream
+	buf.Reset()
+	if err := rootFS.CompressAndSaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadZstdFrom(&buf); err == nil {
+		t.Fatal("Expected an error loading gzip data as zstd")
+	}
+}
+
+// benchmarkTree returns a filesystem with about 10MB of compressible files
+func benchmarkTree(b *testing.B) *FS {
+	rootFS := New()
+	line := []byte("2024-01-01T00:00:00Z INFO request served path=/index.html status=200\n")
+	content := bytes.Repeat(line, 10*1024*1024/100/len(line))
+	for i := 0; i < 100; i++ {
+		dir := fmt.Sprintf("logs/%02d", i%10)
+		if err := rootFS.MkdirAll(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := rootFS.WriteFile(fmt.Sprintf("%s/%03d.log", dir, i), content, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return rootFS
+}
+
+func BenchmarkSaveGzip(b *testing.B) {
+	rootFS := benchmarkTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		if err := rootFS.CompressAndSaveTo(&buf); err != nil {
+			b.Fatal(err)
+		}
+		b.ReportMetric(float64(buf.Len()), "compressed-bytes")
+	}
+}
+
+func BenchmarkSaveZstd(b *testing.B) {
+	rootFS := benchmarkTree(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		if err := rootFS.SaveZstdTo(&buf); err != nil {
+			b.Fatal(err)
+		}
+		b.ReportMetric(float64(buf.Len()), "compressed-bytes")
+	}
+}
+
+// TestLoadAny tests loading saved filesystems without knowing their compression
+func TestLoadAny(t *testing.T) {
+	rootFS := New()
+	if err := rootFS.WriteFile("file.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	savers := map[string]func(string) error{
+		"plain": rootFS.SaveToFile,
+		"gzip":  rootFS.CompressAndSaveToFile,
+		"zstd":  rootFS.SaveZstdToFile,
+	}
+	for name, save := range savers {
+		filename := filepath.Join(dir, name)
+		if err := save(filename); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		loadedFS, err := LoadAnyFromFile(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		content, err := loadedFS.ReadFile("file.txt")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(content) != "hello" {
+			t.Fatalf("%s: expected %q, got %q", name, "hello", content)
+		}
+	}
+
+	if _, err := LoadAnyFrom(bytes.NewReader(nil)); err == nil {
+		t.Fatal("Expected an error loading empty input")
+	}
+}
+
+// TestPerFileCompression tests compressing the content of every file with WithCompression
+func TestPerFileCompression(t *testing.T) {
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 1000)
+	key := []byte("compression key")
+
+	for name, opts := range map[string][]Option{
+		"plain":     {WithCompression()},
+		"encrypted": {WithCompressionLevel(gzip.BestCompression), WithEncryption(key)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rootFS := New(opts...)
+			if err := rootFS.WriteFile("text.txt", text, 0644); err != nil {
+				t.Fatal(err)
+			}
+			fw, err := rootFS.Create("written.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fw.Write(text); err != nil {
+				t.Fatal(err)
+			}
+			if err := fw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, path := range []string{"text.txt", "written.txt"} {
+				stored := rootFS.dir.Children[path].(*File).Content
+				if len(stored) >= len(text)/10 {
+					t.Fatalf("Expected %s to be stored compressed, got %d bytes", path, len(stored))
+				}
+				info, err := rootFS.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() != int64(len(text)) {
+					t.Fatalf("Expected size %d for %s, got %d", len(text), path, info.Size())
+				}
+				content, err := rootFS.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(content, text) {
+					t.Fatalf("Content mismatch for %s", path)
+				}
+			}
+
+			// The setting survives saving and loading
+			var buf bytes.Buffer
+			if err := rootFS.SaveTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			var verifyKey []byte
+			if name == "encrypted" {
+				verifyKey = key
+			}
+			if err := VerifyArchive(bytes.NewReader(buf.Bytes()), verifyKey); err != nil {
+				t.Fatalf("Failed to verify archive: %v", err)
+			}
+			loadedFS, err := LoadFrom(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name == "encrypted" {
+				if err := loadedFS.SetEncryptionKey(key); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := loadedFS.WriteFile("new.txt", text, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if stored := loadedFS.dir.Children["new.txt"].(*File).Content; len(stored) >= len(text)/10 {
+				t.Fatalf("Expected new file to be stored compressed after loading, got %d bytes", len(stored))
+			}
+			for _, path := range []string{"text.txt", "new.txt"} {
+				content, err := loadedFS.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(content, text) {
+					t.Fatalf("Content mismatch for %s after loading", path)
+				}
+			}
+		})
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected WithCompressionLevel to panic for an invalid level")
+		}
+	}()
+	WithCompressionLevel(42)
+}
+
+// TestCompressionIncompressible tests that content which does not compress
+// is stored as is
+func TestCompressionIncompressible(t *testing.T) {
+	random := make([]byte, 4096)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(bytes.Repeat([]byte("log line\n"), 1000))
+	gw.Close()
+
+	files := map[string][]byte{
+		"random.bin": random,
+		"tiny.txt":   []byte("hi"),
+		"log.gz":     gzipped.Bytes(),
+	}
+
+	rootFS := New(WithCompression())
+	var want int64
+	for path, content := range files {
+		if err := rootFS.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		stored := rootFS.dir.Children[path].(*File).Content
+		if len(stored) != len(content)+1 {
+			t.Fatalf("Expected %s to be stored uncompressed in %d bytes, got %d", path, len(content)+1, len(stored))
+		}
+		want += int64(len(stored))
+
+		got, err := rootFS.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("Content mismatch for %s", path)
+		}
+	}
+	if got := rootFS.UsedStorage(); got != want {
+		t.Fatalf("Expected used storage %d, got %d", want, got)
+	}
+
+	encrypted := New(WithCompression(), WithEncryption([]byte("compression key")))
+	if err := encrypted.WriteFile("random.bin", random, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := encrypted.ReadFile("random.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, random) {
+		t.Fatal("Content mismatch for the encrypted file")
+	}
+}
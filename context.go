@@ -0,0 +1,25 @@
+package memfs
+
+import (
+	"context"
+	"io/fs"
+	"os"
+)
+
+// WriteFileCtx is like WriteFile, but gives up with ctx.Err() if ctx is done
+// before the data is encrypted or before the filesystem is locked.
+func (rootFS *FS) WriteFileCtx(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	return rootFS.writeFile(ctx, path, data, perm)
+}
+
+// OpenCtx is like Open, but gives up with ctx.Err() if ctx is done before the
+// file is looked up or before its content is decrypted.
+func (rootFS *FS) OpenCtx(ctx context.Context, name string) (fs.File, error) {
+	return rootFS.openCtx(ctx, name)
+}
+
+// CreateCtx is like Create, but gives up with ctx.Err() if ctx is done before
+// the file is created. The returned FileWriter does not watch ctx.
+func (rootFS *FS) CreateCtx(ctx context.Context, path string) (*FileWriter, error) {
+	return rootFS.createCtx(ctx, path)
+}
@@ -0,0 +1,62 @@
+package memfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+// TestContext tests that the context-bearing variants honour cancellation
+func TestContext(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("ctx-key")))
+	ctx := context.Background()
+
+	if err := rootFS.WriteFileCtx(ctx, "a.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := rootFS.OpenCtx(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello" {
+		t.Fatalf("Expected 'hello', got %q", content)
+	}
+	w, err := rootFS.CreateCtx(ctx, "b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if err := rootFS.WriteFileCtx(canceled, "c.txt", []byte("nope"), 0644); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled from WriteFileCtx, got: %v", err)
+	}
+	if _, err := rootFS.Stat("c.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected c.txt not to be created, got: %v", err)
+	}
+	if _, err := rootFS.OpenCtx(canceled, "a.txt"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled from OpenCtx, got: %v", err)
+	}
+	if _, err := rootFS.CreateCtx(canceled, "a.txt"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled from CreateCtx, got: %v", err)
+	}
+
+	// The canceled CreateCtx must not have truncated the file
+	content, err = fs.ReadFile(rootFS, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello" {
+		t.Fatalf("Expected 'hello', got %q", content)
+	}
+}
@@ -0,0 +1,399 @@
+package memfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"maps"
+	syspath "path"
+	"strings"
+	"time"
+)
+
+// CopyFile copies the content and permissions of the file src to dst,
+// replacing dst if it is a file. The parent directory of dst must exist.
+// The modification time of dst is set to the current time.
+//
+// Encrypted content is copied as is, without decrypting it.
+func (rootFS *FS) CopyFile(src, dst string) error {
+	if !fs.ValidPath(src) {
+		return fmt.Errorf("invalid path: %s: %w", src, fs.ErrInvalid)
+	}
+	if !fs.ValidPath(dst) || dst == "." {
+		return fmt.Errorf("invalid path: %s: %w", dst, fs.ErrInvalid)
+	}
+
+	if err := rootFS.checkWritable(dst); err != nil {
+		return err
+	}
+
+	file, err := rootFS.getFile(src)
+	if err != nil {
+		return err
+	}
+
+	content, err := rootFS.storedCopy(file, rootFS.keyPath(dst))
+	if err != nil {
+		return err
+	}
+
+	rootFS.mu.Lock()
+	perm := file.Perm
+	plainSize := rootFS.plainSize(file)
+	rootFS.mu.Unlock()
+
+	// Like with WriteFile, room is made before the storage limit is checked;
+	// the file limit is checked when the copy is inserted
+	rootFS.makeRoom(int64(len(content)))
+	rootFS.mu.Lock()
+	if rootFS.maxStorage > 0 {
+		newSize := rootFS.usedStorage + int64(len(content))
+		if newSize > rootFS.maxStorage {
+			rootFS.mu.Unlock()
+			return fmt.Errorf("storage limit exceeded: %w", fs.ErrInvalid)
+		}
+	}
+	rootFS.mu.Unlock()
+
+	newFile := &File{
+		Perm:          perm,
+		Content:       content,
+		PlaintextSize: plainSize,
+		ModTime:       time.Now(),
+	}
+
+	old, err := rootFS.insert(dst, newFile)
+	if err != nil {
+		return err
+	}
+
+	rootFS.mu.Lock()
+	rootFS.usedStorage += int64(len(content))
+	rootFS.intern(newFile)
+	rootFS.mu.Unlock()
+
+	if old != nil {
+		rootFS.releaseReplaced(old, newFile)
+	}
+	rootFS.notify(OpWrite, newFile.keyPath)
+	return nil
+}
+
+// CopyDir copies the directory src with everything below it to dst,
+// keeping permissions, ownership and modification times. The parent
+// directory of dst must exist and dst itself must not.
+//
+// Encrypted content is copied as is, without decrypting it.
+func (rootFS *FS) CopyDir(src, dst string) error {
+	if !fs.ValidPath(src) {
+		return fmt.Errorf("invalid path: %s: %w", src, fs.ErrInvalid)
+	}
+	if !fs.ValidPath(dst) || dst == "." {
+		return fmt.Errorf("invalid path: %s: %w", dst, fs.ErrInvalid)
+	}
+
+	if err := rootFS.checkWritable(dst); err != nil {
+		return err
+	}
+
+	srcDir, err := rootFS.getDir(src)
+	if err != nil {
+		return err
+	}
+
+	dirPart, filePart := syspath.Split(dst)
+	dirPart = strings.TrimSuffix(dirPart, "/")
+
+	dir, err := rootFS.getDir(dirPart)
+	if err != nil {
+		return err
+	}
+
+	// The copy is complete before it is inserted, so dst may be inside src
+	keyPath := rootFS.keyPath(dst)
+	clone, size, err := rootFS.cloneDir(srcDir, keyPath, false)
+	if err != nil {
+		return err
+	}
+	clone.Name = filePart
+	clone.sorted = dir.sorted
+
+	rootFS.mu.Lock()
+	if rootFS.maxStorage > 0 {
+		newSize := rootFS.usedStorage + size
+		if newSize > rootFS.maxStorage {
+			rootFS.mu.Unlock()
+			return fmt.Errorf("storage limit exceeded: %w", fs.ErrInvalid)
+		}
+	}
+	rootFS.mu.Unlock()
+
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+
+	if _, exists := dir.Children[filePart]; exists {
+		return fmt.Errorf("file exists: %s: %w", dst, fs.ErrExist)
+	}
+
+	rootFS.mu.Lock()
+	if err := rootFS.addFiles(dst, countFiles(clone)); err != nil {
+		rootFS.mu.Unlock()
+		return err
+	}
+	rootFS.usedStorage += size
+	rootFS.mu.Unlock()
+
+	dir.setChild(filePart, clone)
+	dir.ModTime = time.Now()
+	rootFS.notify(OpCreate, keyPath)
+	rootFS.internTree(clone)
+	return nil
+}
+
+// cloneDir returns a deep copy of d, to be placed at keyPath, and the number
+// of bytes stored in the copied files. If exact is set, previous file versions
+// and expiry times are copied as well; otherwise the copied files are new
+// files without history.
+func (rootFS *FS) cloneDir(d *Dir, keyPath string, exact bool) (*Dir, int64, error) {
+	d.mu.RLock()
+	clone := &Dir{
+		Name:       d.Name,
+		Perm:       d.Perm,
+		ModTime:    d.ModTime,
+		AccessTime: d.AccessTime,
+		Uid:        d.Uid,
+		Gid:        d.Gid,
+		Children:   make(map[string]childI, len(d.Children)),
+		sorted:     d.sorted,
+	}
+	names := d.sortedChildNames()
+	children := make(map[string]childI, len(d.Children))
+	for name, child := range d.Children {
+		children[name] = child
+	}
+	d.mu.RUnlock()
+
+	var size int64
+	for _, name := range names {
+		childKeyPath := syspath.Join(keyPath, name)
+		switch c := children[name].(type) {
+		case *Dir:
+			sub, n, err := rootFS.cloneDir(c, childKeyPath, exact)
+			if err != nil {
+				return nil, 0, err
+			}
+			clone.setChild(name, sub)
+			size += n
+		case *File:
+			f, err := rootFS.cloneFile(c, childKeyPath)
+			if err != nil {
+				return nil, 0, err
+			}
+			if exact {
+				rootFS.mu.Lock()
+				f.expireAt = c.expireAt
+				for _, v := range c.History {
+					f.History = append(f.History, &File{
+						Name:          v.Name,
+						Perm:          v.Perm,
+						Content:       bytes.Clone(v.Content),
+						PlaintextSize: v.PlaintextSize,
+						ModTime:       v.ModTime,
+						Uid:           v.Uid,
+						Gid:           v.Gid,
+						keyPath:       v.keyPath,
+					})
+				}
+				rootFS.mu.Unlock()
+			} else if rootFS.ttl > 0 {
+				f.expireAt = time.Now().Add(rootFS.ttl)
+			}
+			clone.setChild(name, f)
+			size += f.storedSize()
+		case *Symlink:
+			clone.setChild(name, &Symlink{
+				Name:    c.Name,
+				Target:  c.Target,
+				ModTime: c.ModTime,
+			})
+		}
+	}
+	return clone, size, nil
+}
+
+// cloneFile returns a copy of f with its stored content, to be placed at keyPath
+func (rootFS *FS) cloneFile(f *File, keyPath string) (*File, error) {
+	content, err := rootFS.storedCopy(f, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	return &File{
+		Name:          f.Name,
+		Perm:          f.Perm,
+		Content:       content,
+		PlaintextSize: rootFS.plainSize(f),
+		ModTime:       f.ModTime,
+		AccessTime:    f.AccessTime,
+		Uid:           f.Uid,
+		Gid:           f.Gid,
+		keyPath:       keyPath,
+	}, nil
+}
+
+// storedCopy returns a copy of the content of f as it is stored, encrypted
+// if encryption is enabled, for a file at keyPath. Content that is bound to
+// the path of f is encrypted again if keyPath is not that path.
+func (rootFS *FS) storedCopy(f *File, keyPath string) ([]byte, error) {
+	rootFS.mu.Lock()
+	content := bytes.Clone(f.Content)
+	plaintext := f.writers > 0
+	enc := rootFS.encryptor
+	srcKeyPath := f.keyPath
+	rootFS.mu.Unlock()
+
+	if !plaintext && enc != nil && enc.pathBound() && keyPath != srcKeyPath {
+		var err error
+		if content, err = enc.decrypt(srcKeyPath, content); err != nil {
+			return nil, err
+		}
+		plaintext = true
+	}
+
+	// A file that is being written holds plaintext, which has to be encrypted
+	if plaintext && enc != nil {
+		encrypted, err := enc.encrypt(keyPath, content)
+		if err != nil {
+			return nil, fmt.Errorf("encryption failed: %w", err)
+		}
+		return encrypted, nil
+	}
+	return content, nil
+}
+
+// Clone returns a deep copy of the filesystem that shares no data with it,
+// with the same options, encryption key and open hook. Files that are being
+// written are copied with the content written so far.
+func (rootFS *FS) Clone() *FS {
+	// Cloning can only fail when encrypting the content of a file that is
+	// being written fails to read a nonce from crypto/rand, which does not
+	// return errors
+	root, used, _ := rootFS.cloneDir(rootFS.dir, rootFS.keyPrefix, true)
+
+	rootFS.mu.Lock()
+	clone := &FS{
+		storage: &storage{
+			maxStorage:  rootFS.maxStorage,
+			maxFiles:    rootFS.maxFiles,
+			fileCount:   countFiles(root),
+			usedStorage: used,
+		},
+		dir:            root,
+		openHook:       rootFS.openHook,
+		writeHook:      rootFS.writeHook,
+		maxFileSize:    rootFS.maxFileSize,
+		maxSymlinkHops: rootFS.maxSymlinkHops,
+		encryptor:      rootFS.encryptor,
+		cipher:         rootFS.cipher,
+		kdf:            rootFS.kdf,
+
+		encryptedPaths: rootFS.encryptedPaths,
+		sealedPaths:    rootFS.sealedPaths,
+		perFileKeys:    rootFS.perFileKeys,
+		boundPaths:     rootFS.boundPaths,
+		macKey:         rootFS.macKey,
+		macRequired:    rootFS.macRequired,
+		compress:       rootFS.compress,
+		compressLevel:  rootFS.compressLevel,
+		chunked:        rootFS.chunked,
+		keyPrefix:      rootFS.keyPrefix,
+		readOnly:       rootFS.readOnly,
+		encLocked:      rootFS.encLocked,
+		versions:       rootFS.versions,
+		ttl:            rootFS.ttl,
+		onEvict:        rootFS.onEvict,
+		lru:            rootFS.lru,
+		noAtime:        rootFS.noAtime,
+		lower:          rootFS.lower,
+
+		autoMkdir:     rootFS.autoMkdir,
+		autoMkdirPerm: rootFS.autoMkdirPerm,
+	}
+	if rootFS.whiteouts != nil {
+		clone.whiteouts = maps.Clone(rootFS.whiteouts)
+	}
+	rootFS.mu.Unlock()
+
+	if rootFS.dedup != nil {
+		clone.dedup = newDedupStore()
+		clone.internTree(clone.dir)
+	}
+
+	if clone.ttl > 0 {
+		clone.stopSweep = make(chan struct{})
+		go clone.sweepExpired()
+	}
+	return clone
+}
+
+// Merge copies all directories, files and symbolic links of src into the
+// filesystem, creating parent directories as needed. Paths that already
+// exist are skipped, unless overwrite is set, in which case files and links
+// are replaced. Content is decrypted with the key of src and encrypted with
+// the key of the filesystem, and counts against its storage limit.
+// Merge stops at the first error; the entries merged until then are kept.
+func (rootFS *FS) Merge(src *FS, overwrite bool) error {
+	if err := rootFS.checkWritable("."); err != nil {
+		return err
+	}
+
+	return src.walk(func(path string, child childI) error {
+		existing, err := rootFS.lget(path)
+		exists := err == nil
+
+		switch c := child.(type) {
+		case *Dir:
+			if exists {
+				return nil
+			}
+			return rootFS.MkdirAll(path, c.Perm)
+		case *File:
+			if src.expired(c) || (exists && !overwrite) {
+				return nil
+			}
+			content, err := src.readContent(c)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", path, err)
+			}
+			if _, ok := existing.(*Symlink); ok {
+				if err := rootFS.Remove(path); err != nil {
+					return err
+				}
+			}
+			if err := rootFS.WriteFile(path, content, c.Perm); err != nil {
+				return err
+			}
+
+			src.mu.Lock()
+			atime, mtime := c.AccessTime, c.ModTime
+			src.mu.Unlock()
+			return rootFS.Chtimes(path, atime, mtime)
+		case *Symlink:
+			if exists {
+				if !overwrite {
+					return nil
+				}
+				if _, ok := existing.(*Dir); ok {
+					return fmt.Errorf("path is a directory: %s: %w", path, fs.ErrExist)
+				}
+				if err := rootFS.Remove(path); err != nil {
+					return err
+				}
+			}
+			return rootFS.Symlink(c.Target, path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,350 @@
+package memfs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+// TestCopyFile tests copying files within the filesystem
+func TestCopyFile(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("copy-key")), WithMaxStorage(1000))
+
+	if err := rootFS.MkdirAll("src", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.MkdirAll("dst", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("src/file.txt", []byte("copy me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	used := rootFS.usedStorage
+
+	if err := rootFS.CopyFile("src/file.txt", "dst/file.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := fs.ReadFile(rootFS, "dst/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "copy me" {
+		t.Fatalf("Expected 'copy me', got %q", content)
+	}
+
+	info, err := rootFS.Stat("dst/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0600 {
+		t.Fatalf("Expected mode 0600, got %v", info.Mode())
+	}
+	if rootFS.usedStorage != 2*used {
+		t.Fatalf("Expected used storage %d, got %d", 2*used, rootFS.usedStorage)
+	}
+
+	// Replacing an existing file releases its storage
+	if err := rootFS.CopyFile("src/file.txt", "dst/file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if rootFS.usedStorage != 2*used {
+		t.Fatalf("Expected used storage %d after replacing, got %d", 2*used, rootFS.usedStorage)
+	}
+
+	// Copies are independent
+	if err := rootFS.WriteFile("src/file.txt", []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	content, err = fs.ReadFile(rootFS, "dst/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "copy me" {
+		t.Fatalf("Expected copy to keep 'copy me', got %q", content)
+	}
+
+	if err := rootFS.CopyFile("missing.txt", "dst/missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for missing source, got: %v", err)
+	}
+	if err := rootFS.CopyFile("src", "dst/src"); err == nil {
+		t.Fatal("Expected error when copying a directory")
+	}
+	if err := rootFS.CopyFile("src/file.txt", "nodir/file.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for missing parent, got: %v", err)
+	}
+	if err := rootFS.CopyFile("src/file.txt", "dst"); err == nil {
+		t.Fatal("Expected error when copying onto a directory")
+	}
+}
+
+// TestCopyDir tests deep-copying a directory tree
+func TestCopyDir(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("copy-key")), WithMaxStorage(10000))
+
+	if err := rootFS.MkdirAll("work/sub/deeper", 0750); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"work/a.txt":                "a",
+		"work/sub/b.txt":            "bb",
+		"work/sub/deeper/c.txt":     "ccc",
+		"work/sub/deeper/empty.txt": "",
+	}
+	for path, content := range files {
+		if err := rootFS.WriteFile(path, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := rootFS.Chtimes("work/sub/b.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	used := rootFS.usedStorage
+
+	// A copy inside the source tree only contains the original entries
+	if err := rootFS.CopyDir("work", "work/snapshot"); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range files {
+		content, err := fs.ReadFile(rootFS, "work/snapshot/"+path[len("work/"):])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want {
+			t.Fatalf("Expected %q for copy of %s, got %q", want, path, content)
+		}
+	}
+	if _, err := rootFS.Stat("work/snapshot/snapshot"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected the copy not to contain itself, got: %v", err)
+	}
+
+	info, err := rootFS.Stat("work/snapshot/sub/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0640 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("Expected mode 0640 and mtime %v, got %v and %v", mtime, info.Mode(), info.ModTime())
+	}
+	info, err = rootFS.Stat("work/snapshot/sub/deeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != fs.ModeDir|0750 {
+		t.Fatalf("Expected directory mode 0750, got %v", info.Mode())
+	}
+
+	if rootFS.usedStorage != 2*used {
+		t.Fatalf("Expected used storage %d, got %d", 2*used, rootFS.usedStorage)
+	}
+
+	// Copies are independent
+	if err := rootFS.WriteFile("work/a.txt", []byte("changed"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	content, err := fs.ReadFile(rootFS, "work/snapshot/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a" {
+		t.Fatalf("Expected copy to keep 'a', got %q", content)
+	}
+
+	if err := rootFS.CopyDir("work/sub", "work/snapshot"); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("Expected fs.ErrExist for existing destination, got: %v", err)
+	}
+	if err := rootFS.CopyDir("missing", "copy"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for missing source, got: %v", err)
+	}
+	if err := rootFS.CopyDir("work/a.txt", "copy"); err == nil {
+		t.Fatal("Expected error when copying a file with CopyDir")
+	}
+	if err := rootFS.CopyDir("work", "nodir/copy"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for missing parent, got: %v", err)
+	}
+}
+
+// TestClone tests that a clone is independent of the original
+func TestClone(t *testing.T) {
+	hook := func(path string, content []byte, origErr error) ([]byte, error) {
+		return content, origErr
+	}
+	rootFS := New(WithEncryption([]byte("clone-key")), WithMaxStorage(10000), WithVersioning(2), WithOpenHook(hook))
+
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Symlink("dir/file.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	clone := rootFS.Clone()
+
+	if clone.maxStorage != rootFS.maxStorage || clone.usedStorage != rootFS.usedStorage {
+		t.Fatalf("Expected storage %d/%d, got %d/%d", rootFS.usedStorage, rootFS.maxStorage, clone.usedStorage, clone.maxStorage)
+	}
+	if clone.openHook == nil {
+		t.Fatal("Expected the open hook to be kept")
+	}
+
+	content, err := fs.ReadFile(clone, "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "v2" {
+		t.Fatalf("Expected 'v2', got %q", content)
+	}
+	versions, err := clone.Versions("dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("Expected 1 previous version, got %d", len(versions))
+	}
+
+	original, err := rootFS.getFile("dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, err := clone.getFile("dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &original.Content[0] == &copied.Content[0] {
+		t.Fatal("Expected content slices not to be shared")
+	}
+
+	// Mutations do not cross over in either direction
+	if err := clone.WriteFile("dir/file.txt", []byte("clone"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/other.txt", []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err = fs.ReadFile(rootFS, "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "v2" {
+		t.Fatalf("Expected original to keep 'v2', got %q", content)
+	}
+	if _, err := clone.Stat("dir/other.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected clone not to see new files of the original, got: %v", err)
+	}
+}
+
+// TestCloneStorage tests that a clone accounts for its storage on its own
+func TestCloneStorage(t *testing.T) {
+	rootFS := New(WithMaxStorage(1000))
+
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	used := rootFS.UsedStorage()
+
+	clone := rootFS.Clone()
+	if clone.UsedStorage() != used {
+		t.Fatalf("Expected clone to use %d bytes, got %d", used, clone.UsedStorage())
+	}
+
+	if err := clone.RemoveAll("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if clone.UsedStorage() != 0 {
+		t.Fatalf("Expected clone to use 0 bytes after RemoveAll, got %d", clone.UsedStorage())
+	}
+
+	if rootFS.UsedStorage() != used {
+		t.Fatalf("Expected source to still use %d bytes, got %d", used, rootFS.UsedStorage())
+	}
+	content, err := fs.ReadFile(rootFS, "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "content" {
+		t.Fatalf("Expected source to keep 'content', got %q", content)
+	}
+}
+
+// TestMerge tests merging one filesystem into another
+func TestMerge(t *testing.T) {
+	newSrc := func() *FS {
+		src := New(WithEncryption([]byte("src-key")))
+		if err := src.MkdirAll("dir/sub", 0700); err != nil {
+			t.Fatal(err)
+		}
+		for path, content := range map[string]string{
+			"shared.txt":      "from src",
+			"dir/new.txt":     "new",
+			"dir/sub/new.txt": "nested",
+		} {
+			if err := src.WriteFile(path, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := src.Symlink("dir/new.txt", "link"); err != nil {
+			t.Fatal(err)
+		}
+		return src
+	}
+
+	for _, overwrite := range []bool{false, true} {
+		rootFS := New(WithEncryption([]byte("dst-key")))
+		if err := rootFS.WriteFile("shared.txt", []byte("from dst"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := rootFS.WriteFile("keep.txt", []byte("keep"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := rootFS.Merge(newSrc(), overwrite); err != nil {
+			t.Fatal(err)
+		}
+
+		shared := "from dst"
+		if overwrite {
+			shared = "from src"
+		}
+		for path, want := range map[string]string{
+			"shared.txt":      shared,
+			"keep.txt":        "keep",
+			"dir/new.txt":     "new",
+			"dir/sub/new.txt": "nested",
+			"link":            "new",
+		} {
+			content, err := fs.ReadFile(rootFS, path)
+			if err != nil {
+				t.Fatalf("overwrite=%v: %v", overwrite, err)
+			}
+			if string(content) != want {
+				t.Fatalf("overwrite=%v: expected %q for %s, got %q", overwrite, want, path, content)
+			}
+		}
+
+		info, err := rootFS.Stat("dir/sub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != fs.ModeDir|0700 {
+			t.Fatalf("Expected merged directory mode 0700, got %v", info.Mode())
+		}
+	}
+
+	// The storage limit of the receiver applies
+	rootFS := New(WithMaxStorage(10))
+	if err := rootFS.Merge(newSrc(), false); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected storage limit error, got: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package memfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"slices"
+	"sync"
+)
+
+// dedupStore holds the content shared by files with identical stored
+// content, see WithDeduplication. It is shared with the filesystems returned
+// by Sub, so it has its own lock.
+type dedupStore struct {
+	mu    sync.Mutex
+	blobs map[[sha256.Size]byte]*blob
+}
+
+// blob is stored content shared by refs files
+type blob struct {
+	sum     [sha256.Size]byte
+	content []byte
+	refs    int
+}
+
+func newDedupStore() *dedupStore {
+	return &dedupStore{blobs: make(map[[sha256.Size]byte]*blob)}
+}
+
+// intern makes f share its content with the files that have the same stored
+// content. The bytes of f are only counted once in the storage usage, when
+// the first file with the content is stored. Shared content must not be
+// modified in place; call unshare first. The caller must hold rootFS.mu.
+func (rootFS *FS) intern(f *File) {
+	d := rootFS.dedup
+	if d == nil || f.blob != nil || f.writers > 0 || len(f.Content) == 0 {
+		return
+	}
+	sum := sha256.Sum256(f.Content)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if b, ok := d.blobs[sum]; ok {
+		b.refs++
+		f.Content = b.content
+		f.blob = b
+		rootFS.usedStorage -= int64(len(b.content))
+		return
+	}
+	b := &blob{sum: sum, content: f.Content, refs: 1}
+	d.blobs[sum] = b
+	f.blob = b
+}
+
+// unshare drops the reference of f to shared content, after the caller has
+// released the size of the content from the storage usage like for any
+// other file. Content still used by other files is counted again. The
+// content of f is left as is. The caller must hold rootFS.mu.
+func (rootFS *FS) unshare(f *File) {
+	b := f.blob
+	if b == nil {
+		return
+	}
+	f.blob = nil
+
+	d := rootFS.dedup
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b.refs--
+	if b.refs == 0 {
+		delete(d.blobs, b.sum)
+		return
+	}
+	rootFS.usedStorage += int64(len(b.content))
+}
+
+// ownContent gives f a private copy of shared content, so that it can be
+// modified in place. The caller must hold rootFS.mu.
+func (rootFS *FS) ownContent(f *File) {
+	if f.blob == nil {
+		return
+	}
+	rootFS.unshare(f)
+	f.Content = bytes.Clone(f.Content)
+}
+
+// releaseFile removes the content of the removed file f and its previous
+// versions from the storage usage, and f from the number of files. The
+// caller must hold rootFS.mu.
+func (rootFS *FS) releaseFile(f *File) {
+	rootFS.fileCount--
+	rootFS.usedStorage -= f.storedSize()
+	for _, v := range f.History {
+		rootFS.unshare(v)
+	}
+	rootFS.unshare(f)
+}
+
+// releaseVersions drops the references of old and its previous versions to
+// shared content, except for the versions kept by newFile
+func (rootFS *FS) releaseVersions(old, newFile *File) {
+	for _, v := range append(old.History[:len(old.History):len(old.History)], old) {
+		if !slices.Contains(newFile.History, v) {
+			rootFS.unshare(v)
+		}
+	}
+}
+
+// internTree shares the content of every file below dir and of its previous
+// versions, see intern
+func (rootFS *FS) internTree(dir *Dir) {
+	if rootFS.dedup == nil {
+		return
+	}
+	walkDir(dir, "", func(_ string, child childI) error {
+		if f, ok := child.(*File); ok {
+			rootFS.mu.Lock()
+			for _, v := range f.History {
+				rootFS.intern(v)
+			}
+			rootFS.intern(f)
+			rootFS.mu.Unlock()
+		}
+		return nil
+	})
+}
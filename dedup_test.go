@@ -0,0 +1,138 @@
+package memfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// TestDeduplication tests that identical content is stored once with WithDeduplication
+func TestDeduplication(t *testing.T) {
+	shared := bytes.Repeat([]byte("shared content "), 100)
+	other := []byte("other content")
+
+	rootFS := New(WithDeduplication())
+	for _, path := range []string{"a.txt", "b.txt"} {
+		if err := rootFS.WriteFile(path, shared, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rootFS.WriteFile("c.txt", other, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.CopyFile("a.txt", "d.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := int64(len(shared) + len(other))
+	if used := rootFS.UsedStorage(); used != want {
+		t.Fatalf("Expected shared content to be counted once, want %d bytes, got %d", want, used)
+	}
+	a := rootFS.dir.Children["a.txt"].(*File).Content
+	d := rootFS.dir.Children["d.txt"].(*File).Content
+	if &a[0] != &d[0] {
+		t.Fatal("Expected the copy to share the content of the original")
+	}
+
+	// Appending to one of the files leaves the others unchanged
+	f, err := rootFS.OpenFile("b.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw := f.(*FileWriter)
+	if _, err := fw.Write([]byte("!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string][]byte{
+		"a.txt": shared,
+		"b.txt": append(bytes.Clone(shared), '!'),
+		"d.txt": shared,
+	} {
+		got, err := rootFS.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("Content mismatch for %s", path)
+		}
+	}
+	want += int64(len(shared) + 1)
+	if used := rootFS.UsedStorage(); used != want {
+		t.Fatalf("Expected %d bytes after changing a shared file, got %d", want, used)
+	}
+
+	// Shared content is freed with the last file using it
+	if err := rootFS.Remove("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if used := rootFS.UsedStorage(); used != want {
+		t.Fatalf("Expected content still used by d.txt to be kept, want %d bytes, got %d", want, used)
+	}
+	if err := rootFS.Remove("d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	want -= int64(len(shared))
+	if used := rootFS.UsedStorage(); used != want {
+		t.Fatalf("Expected %d bytes after removing all copies, got %d", want, used)
+	}
+	if n := len(rootFS.dedup.blobs); n != 2 {
+		t.Fatalf("Expected 2 shared contents left, got %d", n)
+	}
+
+	// Loading a saved filesystem shares the content again
+	if err := rootFS.WriteFile("e.txt", other, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used, want := loadedFS.UsedStorage(), rootFS.UsedStorage(); used != want {
+		t.Fatalf("Expected %d bytes after loading, got %d", want, used)
+	}
+
+	if err := rootFS.RemoveAll("."); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(rootFS.dedup.blobs); n != 0 {
+		t.Fatalf("Expected no shared content after removing everything, got %d", n)
+	}
+}
+
+// TestDeduplicationVersions tests that previous versions share content with the current one
+func TestDeduplicationVersions(t *testing.T) {
+	content := bytes.Repeat([]byte("v"), 1000)
+
+	rootFS := New(WithDeduplication(), WithVersioning(1))
+	for range 3 {
+		if err := rootFS.WriteFile("file.txt", content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if used := rootFS.UsedStorage(); used != int64(len(content)) {
+		t.Fatalf("Expected versions to share the content, want %d bytes, got %d", len(content), used)
+	}
+
+	if err := rootFS.WriteFile("file.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if used := rootFS.UsedStorage(); used != int64(len(content)+3) {
+		t.Fatalf("Expected %d bytes, got %d", len(content)+3, used)
+	}
+	if err := rootFS.Remove("file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if used := rootFS.UsedStorage(); used != 0 {
+		t.Fatalf("Expected 0 bytes after removing the file, got %d", used)
+	}
+	if n := len(rootFS.dedup.blobs); n != 0 {
+		t.Fatalf("Expected no shared content left, got %d", n)
+	}
+}
@@ -0,0 +1,216 @@
+package memfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// DiffOp is the kind of difference reported by a DiffEntry
+type DiffOp int
+
+// Differences between two filesystems, see Diff
+const (
+	DiffAdded     DiffOp = iota + 1 // only in the other filesystem
+	DiffModified                    // in both, with different content
+	DiffDeleted                     // only in the filesystem Diff was called on
+	DiffUnchanged                   // in both, with the same content
+)
+
+// String returns the name of the difference
+func (op DiffOp) String() string {
+	switch op {
+	case DiffAdded:
+		return "ADDED"
+	case DiffModified:
+		return "MODIFIED"
+	case DiffDeleted:
+		return "DELETED"
+	case DiffUnchanged:
+		return "UNCHANGED"
+	}
+	return fmt.Sprintf("DiffOp(%d)", int(op))
+}
+
+// DiffEntry is a difference between two filesystems reported by Diff
+type DiffEntry struct {
+	Path  string
+	Op    DiffOp
+	IsDir bool
+
+	// OldContent and NewContent hold the decrypted content of an added,
+	// modified or deleted file in the filesystem Diff was called on and in
+	// the other filesystem; they are nil if the file is not in that
+	// filesystem, and for unchanged files, directories and symbolic links.
+	OldContent []byte
+	NewContent []byte
+}
+
+// Diff compares the filesystem with other and returns the changes that turn
+// it into other, sorted by path. Files are compared by the SHA-256 digest of
+// their decrypted content, so modification times, permissions and the
+// encryption keys of the filesystems do not matter; symbolic links are
+// compared by their targets. Directories are only
+// reported when they exist in one of the filesystems only; the files below
+// them are reported as well.
+//
+// Both filesystems are hashed at the same time, each by up to GOMAXPROCS
+// workers like Manifest. Files that are changed while Diff runs may be
+// reported with either content.
+func (rootFS *FS) Diff(other *FS) ([]DiffEntry, error) {
+	var (
+		oldTree, newTree *diffTree
+		oldErr, newErr   error
+		wg               sync.WaitGroup
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		oldTree, oldErr = rootFS.diffTree()
+	}()
+	go func() {
+		defer wg.Done()
+		newTree, newErr = other.diffTree()
+	}()
+	wg.Wait()
+	if oldErr != nil {
+		return nil, oldErr
+	}
+	if newErr != nil {
+		return nil, newErr
+	}
+
+	paths := make(map[string]struct{})
+	for _, tree := range []*diffTree{oldTree, newTree} {
+		for path := range tree.sums {
+			paths[path] = struct{}{}
+		}
+		for path := range tree.dirs {
+			paths[path] = struct{}{}
+		}
+		for path := range tree.links {
+			paths[path] = struct{}{}
+		}
+	}
+
+	var entries []DiffEntry
+	for _, path := range slices.Sorted(maps.Keys(paths)) {
+		var err error
+		entries, err = rootFS.diffPath(other, oldTree, newTree, path, entries)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// diffTree holds the entries of a filesystem compared by Diff
+type diffTree struct {
+	sums  map[string][sha256.Size]byte // digest of the content of every file
+	dirs  map[string]bool
+	links map[string]string // target of every symbolic link
+}
+
+// diffTree hashes the files of the filesystem and collects its directories
+// and symbolic links
+func (rootFS *FS) diffTree() (*diffTree, error) {
+	sums, err := rootFS.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &diffTree{
+		sums:  sums,
+		dirs:  make(map[string]bool),
+		links: make(map[string]string),
+	}
+	err = rootFS.walk(func(path string, child childI) error {
+		switch c := child.(type) {
+		case *Dir:
+			tree.dirs[path] = true
+		case *Symlink:
+			tree.links[path] = c.Target
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// diffPath appends the differences at path to entries. A path that is a
+// file in one filesystem and a directory in the other is reported as
+// deleted and added.
+func (rootFS *FS) diffPath(other *FS, oldTree, newTree *diffTree, path string, entries []DiffEntry) ([]DiffEntry, error) {
+	oldSum, oldFile := oldTree.sums[path]
+	newSum, newFile := newTree.sums[path]
+	oldTarget, oldLink := oldTree.links[path]
+	newTarget, newLink := newTree.links[path]
+
+	switch {
+	case oldTree.dirs[path] && !newTree.dirs[path]:
+		entries = append(entries, DiffEntry{Path: path, Op: DiffDeleted, IsDir: true})
+	case newTree.dirs[path] && !oldTree.dirs[path]:
+		entries = append(entries, DiffEntry{Path: path, Op: DiffAdded, IsDir: true})
+	}
+
+	switch {
+	case oldLink && newLink:
+		op := DiffUnchanged
+		if oldTarget != newTarget {
+			op = DiffModified
+		}
+		entries = append(entries, DiffEntry{Path: path, Op: op})
+	case oldLink:
+		entries = append(entries, DiffEntry{Path: path, Op: DiffDeleted})
+	case newLink:
+		entries = append(entries, DiffEntry{Path: path, Op: DiffAdded})
+	}
+
+	if oldFile && newFile && oldSum == newSum {
+		return append(entries, DiffEntry{Path: path, Op: DiffUnchanged}), nil
+	}
+
+	var (
+		entry = DiffEntry{Path: path}
+		err   error
+	)
+	if oldFile {
+		if entry.OldContent, err = rootFS.diffContent(path); err != nil {
+			return nil, err
+		}
+	}
+	if newFile {
+		if entry.NewContent, err = other.diffContent(path); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case oldFile && newFile:
+		entry.Op = DiffModified
+	case oldFile:
+		entry.Op = DiffDeleted
+	case newFile:
+		entry.Op = DiffAdded
+	default:
+		return entries, nil
+	}
+	return append(entries, entry), nil
+}
+
+// diffContent returns a copy of the decrypted content of the file at path
+func (rootFS *FS) diffContent(path string) ([]byte, error) {
+	file, err := rootFS.getFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	content, err := rootFS.readContent(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return bytes.Clone(content), nil
+}
@@ -0,0 +1,70 @@
+package memfs
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestDiff tests comparing two filesystems with Diff
+func TestDiff(t *testing.T) {
+	oldFS := New()
+	newFS := New(WithEncryption([]byte("diff key")))
+	for _, rootFS := range []*FS{oldFS, newFS} {
+		if err := rootFS.MkdirAll("same", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := rootFS.WriteFile("same/unchanged.txt", []byte("unchanged"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := oldFS.WriteFile("same/modified.txt", []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := newFS.WriteFile("same/modified.txt", []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := oldFS.MkdirAll("gone", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := oldFS.WriteFile("gone/deleted.txt", []byte("deleted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := newFS.MkdirAll("fresh", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := newFS.WriteFile("fresh/added.txt", []byte("added"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := newFS.Symlink("same/unchanged.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := oldFS.Diff(newFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DiffEntry{
+		{Path: "fresh", Op: DiffAdded, IsDir: true},
+		{Path: "fresh/added.txt", Op: DiffAdded, NewContent: []byte("added")},
+		{Path: "gone", Op: DiffDeleted, IsDir: true},
+		{Path: "gone/deleted.txt", Op: DiffDeleted, OldContent: []byte("deleted")},
+		{Path: "link", Op: DiffAdded},
+		{Path: "same/modified.txt", Op: DiffModified, OldContent: []byte("old"), NewContent: []byte("new")},
+		{Path: "same/unchanged.txt", Op: DiffUnchanged},
+	}
+	if diff := cmp.Diff(want, entries); diff != "" {
+		t.Fatalf("Diff mismatch (-want +got):\n%s", diff)
+	}
+
+	entries, err = newFS.Diff(newFS.Clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Op != DiffUnchanged {
+			t.Fatalf("Expected no changes against a clone, got %s %s", e.Op, e.Path)
+		}
+	}
+}
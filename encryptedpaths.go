@@ -0,0 +1,160 @@
+package memfs
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"strings"
+)
+
+// sealTree returns a copy of dir for saving, with the names of all entries
+// and the targets of symbolic links encrypted by enc. File content is shared
+// with the tree, not copied.
+func (rootFS *FS) sealTree(dir *Dir, enc *encryptor) *Dir {
+	dir.mu.RLock()
+	sealed := &Dir{
+		Name:       enc.sealName(dir.Name),
+		Perm:       dir.Perm,
+		ModTime:    dir.ModTime,
+		AccessTime: dir.AccessTime,
+		Uid:        dir.Uid,
+		Gid:        dir.Gid,
+		Children:   make(map[string]childI, len(dir.Children)),
+	}
+	children := maps.Clone(dir.Children)
+	dir.mu.RUnlock()
+
+	for name, child := range children {
+		var c childI
+		switch cc := child.(type) {
+		case *Dir:
+			c = rootFS.sealTree(cc, enc)
+		case *File:
+			c = rootFS.sealFile(cc, enc)
+		case *Symlink:
+			c = &Symlink{
+				Name:    enc.sealName(cc.Name),
+				Target:  enc.sealName(cc.Target),
+				ModTime: cc.ModTime,
+			}
+		}
+		sealed.Children[enc.sealName(name)] = c
+	}
+	return sealed
+}
+
+// sealFile returns a copy of f and its previous versions with their names
+// encrypted by enc
+func (rootFS *FS) sealFile(f *File, enc *encryptor) *File {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+
+	seal := func(v *File) *File {
+		return &File{
+			Name:          enc.sealName(v.Name),
+			Perm:          v.Perm,
+			Content:       v.Content,
+			PlaintextSize: v.PlaintextSize,
+			ModTime:       v.ModTime,
+			AccessTime:    v.AccessTime,
+			Uid:           v.Uid,
+			Gid:           v.Gid,
+		}
+	}
+	sealed := seal(f)
+	for _, v := range f.History {
+		sealed.History = append(sealed.History, seal(v))
+	}
+	return sealed
+}
+
+// openPaths decrypts the names of a tree loaded from a snapshot saved with
+// encrypted paths, in place. Nothing is changed if a name cannot be
+// decrypted with enc.
+func (rootFS *FS) openPaths(enc *encryptor) error {
+	if !enc.encrypts() {
+		return fmt.Errorf("file names are encrypted, a key is needed: %w", fs.ErrInvalid)
+	}
+
+	var dirs []lockedDir
+	lockTree(rootFS.dir, "", &dirs)
+	defer func() {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			dirs[i].dir.mu.Unlock()
+		}
+	}()
+
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+
+	// Decrypt all names first, so that a wrong key changes nothing
+	plain := make(map[string]string)
+	open := func(sealed string) error {
+		if _, ok := plain[sealed]; ok {
+			return nil
+		}
+		name, err := enc.openName(sealed)
+		if err != nil {
+			return fmt.Errorf("decrypting file names: %w", err)
+		}
+		plain[sealed] = name
+		return nil
+	}
+	for _, d := range dirs {
+		for name, child := range d.dir.Children {
+			sealed := []string{name}
+			switch c := child.(type) {
+			case *Dir:
+				sealed = append(sealed, c.Name)
+			case *File:
+				sealed = append(sealed, c.Name)
+				for _, v := range c.History {
+					sealed = append(sealed, v.Name)
+				}
+			case *Symlink:
+				sealed = append(sealed, c.Name, c.Target)
+			}
+			for _, s := range sealed {
+				if err := open(s); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, d := range dirs {
+		children := d.dir.Children
+		d.dir.clearChildren()
+		for name, child := range children {
+			switch c := child.(type) {
+			case *Dir:
+				c.Name = plain[c.Name]
+			case *File:
+				c.Name = plain[c.Name]
+				for _, v := range c.History {
+					v.Name = plain[v.Name]
+				}
+			case *Symlink:
+				c.Name = plain[c.Name]
+				c.Target = plain[c.Target]
+			}
+			d.dir.setChild(plain[name], child)
+		}
+	}
+	rootFS.sealedPaths = false
+	setKeyPaths(rootFS.dir, rootFS.keyPrefix)
+	return nil
+}
+
+// openPath decrypts every element of a path of encrypted names
+func (e *encryptor) openPath(sealed string) (string, error) {
+	parts := strings.Split(sealed, "/")
+	for i, part := range parts {
+		name, err := e.openName(part)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = name
+	}
+	return strings.Join(parts, "/"), nil
+}
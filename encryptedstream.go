@@ -0,0 +1,241 @@
+package memfs
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// streamMagic starts every stream written by SaveEncryptedTo
+const streamMagic = "MEMFSENC"
+
+// streamChunkSize is the amount of plaintext sealed at a time by SaveEncryptedTo
+const streamChunkSize = 64 * 1024
+
+// SaveEncryptedToFile saves the entire filesystem structure to a file
+// encrypted as a whole, see SaveEncryptedTo
+func (rootFS *FS) SaveEncryptedToFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return rootFS.SaveEncryptedTo(f)
+}
+
+// SaveEncryptedTo saves the filesystem structure to any io.Writer like
+// SaveTo, and encrypts the whole stream with the key of the filesystem, so
+// that names, the directory layout, permissions and times are hidden as
+// well as the content. Load it with LoadEncryptedFrom.
+//
+// The stream is sealed in chunks of 64KiB, so it is written without holding
+// a second copy of the tree in memory. If the filesystem is not encrypted,
+// an error wrapping fs.ErrInvalid is returned.
+func (rootFS *FS) SaveEncryptedTo(w io.Writer) error {
+	rootFS.mu.Lock()
+	enc := rootFS.encryptor
+	cipherName := rootFS.cipher
+	rootFS.mu.Unlock()
+
+	if !enc.encrypts() {
+		return fmt.Errorf("filesystem is not encrypted: %w", fs.ErrInvalid)
+	}
+
+	sw, err := newStreamWriter(w, enc.gcm, cipherName)
+	if err != nil {
+		return err
+	}
+	if err := rootFS.encode(sw); err != nil {
+		return err
+	}
+	return sw.Close()
+}
+
+// LoadEncryptedFromFile creates a new FS from a file written by
+// SaveEncryptedToFile, see LoadEncryptedFrom
+func LoadEncryptedFromFile(filename string, key []byte) (*FS, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadEncryptedFrom(f, key)
+}
+
+// LoadEncryptedFrom creates a new FS from a stream written by
+// SaveEncryptedTo. The filesystem is unlocked with key, so SetEncryptionKey
+// does not have to be called. If the stream has been modified or was
+// encrypted with another key, an error wrapping ErrCorruptArchive is returned.
+// The HMAC key is not part of the stream: if the filesystem was saved with
+// WithHMAC, files cannot be read before SetHMACKey is called, and fail with
+// an error wrapping ErrHMACKeyRequired.
+func LoadEncryptedFrom(r io.Reader, key []byte) (*FS, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("no encryption key: %w", fs.ErrInvalid)
+	}
+
+	data, err := readStream(r, key)
+	if err != nil {
+		return nil, err
+	}
+
+	rootFS, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorruptArchive, err)
+	}
+	if err := rootFS.SetEncryptionKey(key); err != nil {
+		return nil, err
+	}
+	return rootFS, nil
+}
+
+// streamWriter seals everything written to it in chunks. Each chunk is
+// written as its length and the sealed data; the nonce of a chunk is the
+// random nonce prefix of the stream followed by the chunk number, and the
+// header and a flag marking the last chunk are authenticated with it, so
+// chunks cannot be reordered, dropped or cut off.
+type streamWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	count  uint64
+	buf    []byte
+}
+
+// newStreamWriter writes the stream header to w
+func newStreamWriter(w io.Writer, aead cipher.AEAD, cipherName string) (*streamWriter, error) {
+	prefix := make([]byte, aead.NonceSize()-8)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(streamMagic), byte(len(cipherName)))
+	header = append(header, cipherName...)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &streamWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, streamChunkSize),
+	}, nil
+}
+
+// Write buffers p and seals every full chunk
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		m := min(len(p), streamChunkSize-len(sw.buf))
+		sw.buf = append(sw.buf, p[:m]...)
+		p = p[m:]
+
+		if len(sw.buf) == streamChunkSize {
+			if err := sw.seal(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close seals the last chunk, which may be empty
+func (sw *streamWriter) Close() error {
+	return sw.seal(true)
+}
+
+// seal writes the buffered data as the next chunk
+func (sw *streamWriter) seal(last bool) error {
+	sealed := sw.aead.Seal(nil, streamNonce(sw.prefix, sw.count), sw.buf, streamAD(sw.header, last))
+	sw.count++
+	sw.buf = sw.buf[:0]
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	if _, err := sw.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := sw.w.Write(sealed)
+	return err
+}
+
+// readStream decrypts a stream written by streamWriter with key
+func readStream(r io.Reader, key []byte) ([]byte, error) {
+	corrupt := func(err error) error {
+		return fmt.Errorf("%w: %w", ErrCorruptArchive, err)
+	}
+
+	magic := make([]byte, len(streamMagic)+1)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, corrupt(err)
+	}
+	if string(magic[:len(streamMagic)]) != streamMagic {
+		return nil, corrupt(fmt.Errorf("not an encrypted filesystem"))
+	}
+	cipherName := make([]byte, magic[len(streamMagic)])
+	if _, err := io.ReadFull(r, cipherName); err != nil {
+		return nil, corrupt(err)
+	}
+
+	enc, err := newEncryptor(key, string(cipherName), false)
+	if err != nil {
+		return nil, corrupt(err)
+	}
+	prefix := make([]byte, enc.gcm.NonceSize()-8)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, corrupt(err)
+	}
+	header := append(append(magic, cipherName...), prefix...)
+
+	var data []byte
+	for count := uint64(0); ; count++ {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil, corrupt(err)
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n > streamChunkSize+uint32(enc.gcm.Overhead()) {
+			return nil, corrupt(fmt.Errorf("chunk too large"))
+		}
+		sealed := make([]byte, n)
+		if _, err := io.ReadFull(r, sealed); err != nil {
+			return nil, corrupt(err)
+		}
+
+		nonce := streamNonce(prefix, count)
+		if chunk, err := enc.gcm.Open(nil, nonce, sealed, streamAD(header, false)); err == nil {
+			data = append(data, chunk...)
+			continue
+		}
+		chunk, err := enc.gcm.Open(nil, nonce, sealed, streamAD(header, true))
+		if err != nil {
+			return nil, corrupt(err)
+		}
+		return append(data, chunk...), nil
+	}
+}
+
+// streamNonce returns the nonce of chunk count of a stream
+func streamNonce(prefix []byte, count uint64) []byte {
+	return binary.BigEndian.AppendUint64(bytes.Clone(prefix), count)
+}
+
+// streamAD returns the additional data authenticated with a chunk
+func streamAD(header []byte, last bool) []byte {
+	ad := bytes.Clone(header)
+	if last {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
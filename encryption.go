@@ -1,76 +1,235 @@
 package memfs
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Ciphers for encryption at rest, see WithEncryptionCipher
+const (
+	CipherAESGCM           = "aes-gcm"
+	CipherChaCha20Poly1305 = "chacha20-poly1305"
 )
 
-// encryptor handles encryption and decryption of file data at rest
+// ErrDecryption is returned when stored content cannot be decrypted, because
+// the key is wrong or the content has been modified. Errors that wrap it also
+// match fs.ErrPermission, for callers that only know about io/fs.
+var ErrDecryption = errors.New("memfs: decryption failed")
+
+// decryptionError reports that the content of the file at path cannot be decrypted
+type decryptionError struct {
+	path string
+	err  error
+}
+
+func (e *decryptionError) Error() string {
+	return fmt.Sprintf("%v: %s: %v", ErrDecryption, e.path, e.err)
+}
+
+func (e *decryptionError) Unwrap() []error {
+	return []error{ErrDecryption, fs.ErrPermission, e.err}
+}
+
+// encryptor handles encryption and decryption of file data at rest. It is
+// enabled if it compresses (see WithCompression), encrypts, authenticates
+// with an HMAC (see WithHMAC), or any combination of them.
 type encryptor struct {
 	key    []byte
-	gcm    cipher.AEAD
+	gcm    cipher.AEAD // nil if content is not encrypted
 	enable bool
+	macKey []byte // HMAC-SHA256 key, nil if content is not authenticated
+
+	// macRequired is set if content ends with an HMAC whose key has not
+	// been set yet, see SetHMACKey
+	macRequired bool
+
+	// With per-file keys, the key of each file is derived from master and
+	// the path of the file; gcm is only used for file names
+	perFile bool
+	master  []byte
+	cipher  string
+
+	// With bound paths, the path of each file is authenticated together with
+	// its content, so content moved to another path fails to decrypt
+	bindPath bool
+
+	// With compression, content is gzip compressed before it is encrypted
+	compress bool
+	level    int
+
+	// With chunked encryption, content is encrypted in frames that can be
+	// decrypted one at a time, see WithChunkedEncryption
+	chunked bool
 }
 
-// newEncryptor creates a new encryptor with the given key
-// The key can be of any length and will be hashed to 32 bytes for AES-256
-func newEncryptor(key []byte) (*encryptor, error) {
+// newEncryptor creates a new encryptor with the given key for the named
+// cipher; an empty name selects AES-GCM. The key can be of any length and
+// will be hashed to the 32 bytes used by both AES-256 and ChaCha20-Poly1305.
+// If perFile is set, every file is encrypted with its own key, derived from
+// key and the path of the file (see WithPerFileKeys).
+func newEncryptor(key []byte, cipherName string, perFile bool) (*encryptor, error) {
+	if err := checkCipher(cipherName); err != nil {
+		return nil, err
+	}
 	if len(key) == 0 {
 		return &encryptor{enable: false}, nil
 	}
 
-	// Hash the key to ensure it's the correct length for AES-256 (32 bytes)
+	// Hash the key to ensure it's the correct length (32 bytes)
 	hash := sha256.Sum256(key)
 
-	block, err := aes.NewCipher(hash[:])
+	gcm, err := newAEAD(cipherName, hash[:])
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	e := &encryptor{
+		key:     hash[:],
+		gcm:     gcm,
+		enable:  true,
+		perFile: perFile,
+		cipher:  cipherName,
+	}
+	if perFile {
+		e.master = bytes.Clone(key)
+	}
+	return e, nil
+}
+
+// newAEAD returns the named cipher for a 32 byte key
+func newAEAD(cipherName string, key []byte) (cipher.AEAD, error) {
+	if cipherName == CipherChaCha20Poly1305 {
+		return chacha20poly1305.New(key)
+	}
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
+
+// aead returns the cipher for the content of the file at path
+func (e *encryptor) aead(path string) (cipher.AEAD, error) {
+	if !e.perFile {
+		return e.gcm, nil
+	}
 
-	return &encryptor{
-		key:    hash[:],
-		gcm:    gcm,
-		enable: true,
-	}, nil
+	// HKDF-SHA256 with the path as info, so every path gets its own key
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, e.master, nil, []byte(path)), key); err != nil {
+		return nil, err
+	}
+	return newAEAD(e.cipher, key)
 }
 
-// encrypt encrypts the plaintext data using AES-GCM
+// encrypt encrypts the plaintext data of the file at path
 // Returns the encrypted data with the nonce prepended
-func (e *encryptor) encrypt(plaintext []byte) ([]byte, error) {
+func (e *encryptor) encrypt(path string, plaintext []byte) ([]byte, error) {
 	if !e.enable || len(plaintext) == 0 {
 		return plaintext, nil
 	}
+	if err := e.checkMACKey(path); err != nil {
+		return nil, err
+	}
+	if e.compress {
+		// Compress first, encrypted content does not compress
+		var err error
+		if plaintext, err = e.compressContent(plaintext); err != nil {
+			return nil, err
+		}
+	}
+	if e.gcm == nil {
+		return e.appendMAC(path, bytes.Clone(plaintext)), nil
+	}
+
+	aead, err := e.aead(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.chunked {
+		ciphertext, err := e.sealFrames(aead, path, plaintext)
+		if err != nil {
+			return nil, err
+		}
+		return e.appendMAC(path, ciphertext), nil
+	}
 
 	// Generate a random nonce
-	nonce := make([]byte, e.gcm.NonceSize())
+	nonce := make([]byte, aead.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
 
 	// Encrypt the data
 	// The nonce is prepended to the ciphertext
-	ciphertext := e.gcm.Seal(nonce, nonce, plaintext, nil)
+	ciphertext := aead.Seal(nonce, nonce, plaintext, e.additionalData(path))
 
-	return ciphertext, nil
+	// The MAC, if any, covers the ciphertext (encrypt-then-MAC)
+	return e.appendMAC(path, ciphertext), nil
 }
 
-// decrypt decrypts the ciphertext data using AES-GCM
+// decrypt decrypts the ciphertext data of the file at path
 // Expects the nonce to be prepended to the ciphertext
-func (e *encryptor) decrypt(ciphertext []byte) ([]byte, error) {
+// Failures are returned as errors wrapping ErrDecryption
+func (e *encryptor) decrypt(path string, ciphertext []byte) ([]byte, error) {
 	if !e.enable || len(ciphertext) == 0 {
 		return ciphertext, nil
 	}
+	ciphertext, err := e.checkMAC(path, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := ciphertext
+	if e.gcm != nil {
+		aead, err := e.aead(path)
+		if err != nil {
+			return nil, &decryptionError{path: path, err: err}
+		}
+		if e.chunked {
+			plaintext, err = e.openFrames(aead, path, ciphertext)
+		} else {
+			plaintext, err = openSealed(aead, ciphertext, e.additionalData(path))
+		}
+		if err != nil {
+			return nil, &decryptionError{path: path, err: err}
+		}
+	}
+	if e.compress {
+		return e.decompressContent(path, plaintext)
+	}
+	return plaintext, nil
+}
+
+// additionalData returns the data authenticated with the content of the file at path
+func (e *encryptor) additionalData(path string) []byte {
+	if !e.bindPath {
+		return nil
+	}
+	return []byte(path)
+}
 
-	nonceSize := e.gcm.NonceSize()
+// pathBound reports whether stored content is tied to the path of its file,
+// so it has to be encrypted again when it is copied to another path
+func (e *encryptor) pathBound() bool {
+	return e.enable && (e.perFile || e.bindPath)
+}
+
+// openSealed decrypts ciphertext with the nonce prepended
+func openSealed(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
 	if len(ciphertext) < nonceSize {
 		return nil, errors.New("ciphertext too short")
 	}
@@ -79,10 +238,99 @@ func (e *encryptor) decrypt(ciphertext []byte) ([]byte, error) {
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
 
 	// Decrypt the data
-	plaintext, err := e.gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		return nil, err
 	}
 
 	return plaintext, nil
 }
+
+// plaintextSize returns the length of the plaintext for a ciphertext of n
+// bytes. For compressed content it is only an estimate, the size of files
+// is recorded in PlaintextSize.
+func (e *encryptor) plaintextSize(n int) int {
+	if !e.enable || n == 0 {
+		return n
+	}
+	if e.chunked && e.gcm != nil {
+		n -= e.overhead()
+		return max(n-e.storedFrames(n)*e.frameOverhead(), 0)
+	}
+	return max(n-e.overhead(), 0)
+}
+
+// ciphertextSize returns the length of the ciphertext for n bytes of plaintext
+func (e *encryptor) ciphertextSize(n int) int {
+	if !e.enable || n == 0 {
+		return n
+	}
+	if e.compress {
+		// Content that does not compress is stored with a marker
+		n++
+	}
+	if e.chunked && e.gcm != nil {
+		return n + (n+frameSize-1)/frameSize*e.frameOverhead() + e.overhead()
+	}
+	return n + e.overhead()
+}
+
+// overhead returns the number of bytes added to the content of a file; with
+// chunked encryption, the overhead of the frames is not included
+func (e *encryptor) overhead() int {
+	n := 0
+	if e.gcm != nil && !e.chunked {
+		n += e.gcm.NonceSize() + e.gcm.Overhead()
+	}
+	if e.macKey != nil || e.macRequired {
+		n += sha256.Size
+	}
+	return n
+}
+
+// encrypts reports whether content and names are encrypted
+func (e *encryptor) encrypts() bool {
+	return e.gcm != nil
+}
+
+// checkCipher returns an error if name is not a supported cipher
+func checkCipher(name string) error {
+	switch name {
+	case "", CipherAESGCM, CipherChaCha20Poly1305:
+		return nil
+	}
+	return fmt.Errorf("unknown cipher: %s: %w", name, fs.ErrInvalid)
+}
+
+// sealName encrypts a file name for storage. The nonce is derived from the
+// key and the name, so the same name always yields the same sealed name.
+func (e *encryptor) sealName(name string) string {
+	if !e.encrypts() || name == "" {
+		return name
+	}
+
+	nonceKey := sha256.Sum256(append([]byte("memfs name nonce:"), e.key...))
+	mac := hmac.New(sha256.New, nonceKey[:])
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:e.gcm.NonceSize()]
+
+	sealed := e.gcm.Seal(nonce, nonce, []byte(name), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// openName decrypts a file name encrypted by sealName
+func (e *encryptor) openName(sealed string) (string, error) {
+	if !e.encrypts() || sealed == "" {
+		return sealed, nil
+	}
+
+	ciphertext, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	name, err := openSealed(e.gcm, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
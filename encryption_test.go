@@ -2,6 +2,7 @@ package memfs
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"io/fs"
 	"os"
@@ -98,6 +99,56 @@ func TestEncryptionWithFileWriter(t *testing.T) {
 	}
 }
 
+func TestEncryptionReadWhileWriting(t *testing.T) {
+	key := []byte("my-secret-key")
+	rootFS := New(WithEncryption(key))
+
+	if err := rootFS.WriteFile("data.txt", []byte("initial"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// Reopen the existing file for writing and append without closing
+	w, err := rootFS.OpenFile("data.txt", os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open file for writing: %v", err)
+	}
+	fw := w.(*FileWriter)
+
+	if _, err := fw.Write([]byte(" more")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+
+	// Reading before Close returns the unfinalized content written so far
+	readData, err := fs.ReadFile(rootFS, "data.txt")
+	if err != nil {
+		t.Fatalf("Failed to read file mid-write: %v", err)
+	}
+	if string(readData) != "initial more" {
+		t.Errorf("Expected 'initial more' mid-write, got %q", readData)
+	}
+
+	if err := fw.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// After Close the content is encrypted at rest again
+	child, err := rootFS.get("data.txt")
+	if err != nil {
+		t.Fatalf("Failed to get file: %v", err)
+	}
+	if bytes.Equal(child.(*File).Content, []byte("initial more")) {
+		t.Error("Data is not encrypted at rest after Close")
+	}
+
+	readData, err = fs.ReadFile(rootFS, "data.txt")
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if string(readData) != "initial more" {
+		t.Errorf("Expected 'initial more' after Close, got %q", readData)
+	}
+}
+
 func TestEncryptionWithOpenFile(t *testing.T) {
 	key := []byte("openfile-test-key")
 	rootFS := New(WithEncryption(key))
@@ -172,8 +223,18 @@ func TestEncryptionWithWrongKey(t *testing.T) {
 
 	// Attempt to read should fail with wrong key
 	_, err = rootFS2.Open("secret.txt")
-	if err == nil {
-		t.Error("Expected decryption to fail with wrong key, but it succeeded")
+	if !errors.Is(err, ErrDecryption) {
+		t.Errorf("Expected ErrDecryption with wrong key, got: %v", err)
+	}
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Expected the error to match fs.ErrPermission, got: %v", err)
+	}
+
+	// Opening for appending or read-write decrypts the content as well
+	for _, flag := range []int{os.O_WRONLY | os.O_APPEND, os.O_RDWR} {
+		if _, err := rootFS2.OpenFile("secret.txt", flag, 0644); !errors.Is(err, ErrDecryption) {
+			t.Errorf("Expected ErrDecryption for OpenFile with flag %#x, got: %v", flag, err)
+		}
 	}
 }
 
@@ -586,3 +647,1005 @@ func TestEncryptionWithCompressedSave(t *testing.T) {
 		t.Errorf("Content mismatch after compressed save/load")
 	}
 }
+
+func TestLockEncryption(t *testing.T) {
+	key := []byte("locked-key")
+	rootFS := New(WithEncryption(key))
+
+	testData := []byte("locked data")
+	if err := rootFS.WriteFile("data.txt", testData, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	rootFS.LockEncryption()
+
+	err := rootFS.SetEncryptionKey([]byte("other-key"))
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Expected fs.ErrPermission while locked, got: %v", err)
+	}
+
+	// The original key is still in use
+	content, err := fs.ReadFile(rootFS, "data.txt")
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if !bytes.Equal(content, testData) {
+		t.Errorf("Content mismatch after rejected key change")
+	}
+
+	rootFS.UnlockEncryption()
+
+	if err := rootFS.SetEncryptionKey(key); err != nil {
+		t.Fatalf("Expected key change to succeed after unlock, got: %v", err)
+	}
+}
+
+func TestLogicalSize(t *testing.T) {
+	key := []byte("logical-size-key")
+	rootFS := New(WithEncryption(key), WithMaxStorage(1000))
+
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	files := map[string][]byte{
+		"a.txt":     []byte("first file"),
+		"dir/b.txt": []byte("second, somewhat longer file"),
+		"empty.txt": {},
+	}
+
+	var expected int64
+	for path, data := range files {
+		if err := rootFS.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		expected += int64(len(data))
+	}
+
+	fw, err := rootFS.Create("dir/stream.txt")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if _, err := fw.Write([]byte("streamed")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	expected += int64(len("streamed"))
+
+	// Unclosed writers hold plaintext
+	if got := rootFS.LogicalSize(); got != expected {
+		t.Errorf("Expected logical size %d while writing, got %d", expected, got)
+	}
+
+	if err := fw.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	if got := rootFS.LogicalSize(); got != expected {
+		t.Errorf("Expected logical size %d, got %d", expected, got)
+	}
+	if used := rootFS.UsedStorage(); used <= expected {
+		t.Errorf("Expected used storage %d to include encryption overhead beyond %d", used, expected)
+	}
+}
+
+func TestDirSize(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("dir-size-key")))
+
+	if err := rootFS.MkdirAll("dir/sub", 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	files := map[string]string{
+		"top.txt":         "outside of dir",
+		"dir/a.txt":       "first",
+		"dir/sub/b.txt":   "second file",
+		"dir/sub/c.empty": "",
+	}
+	for path, data := range files {
+		if err := rootFS.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	tests := map[string]int64{
+		"dir":     int64(len("first") + len("second file")),
+		"dir/sub": int64(len("second file")),
+		".":       rootFS.LogicalSize(),
+	}
+	for path, want := range tests {
+		got, err := rootFS.DirSize(path)
+		if err != nil {
+			t.Fatalf("DirSize(%q): %v", path, err)
+		}
+		if got != want {
+			t.Errorf("Expected DirSize(%q) = %d, got %d", path, want, got)
+		}
+	}
+
+	if _, err := rootFS.DirSize("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist for a missing path, got: %v", err)
+	}
+	if _, err := rootFS.DirSize("top.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expected fs.ErrInvalid for a file, got: %v", err)
+	}
+}
+
+func TestNewSectionReader(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("section-key")))
+
+	if err := rootFS.WriteFile("data.bin", []byte("0123456789abcdef"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	sr, err := rootFS.NewSectionReader("data.bin", 4, 6)
+	if err != nil {
+		t.Fatalf("Failed to create section reader: %v", err)
+	}
+	if sr.Size() != 6 {
+		t.Errorf("Expected section size 6, got %d", sr.Size())
+	}
+
+	got, err := io.ReadAll(sr)
+	if err != nil {
+		t.Fatalf("Failed to read section: %v", err)
+	}
+	if string(got) != "456789" {
+		t.Errorf("Expected '456789', got %q", got)
+	}
+
+	buf := make([]byte, 2)
+	if _, err := sr.ReadAt(buf, 4); err != nil {
+		t.Fatalf("Failed to read at offset: %v", err)
+	}
+	if string(buf) != "89" {
+		t.Errorf("Expected '89', got %q", buf)
+	}
+
+	if _, err := rootFS.NewSectionReader("data.bin", -1, 2); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expected fs.ErrInvalid for negative offset, got: %v", err)
+	}
+	if _, err := rootFS.NewSectionReader("missing.bin", 0, 2); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist for missing file, got: %v", err)
+	}
+}
+
+func TestEncryptionInFlightBufferLimit(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("in-flight-key")), WithMaxStorage(100))
+
+	fw, err := rootFS.Create("stream.bin")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	// The first write also reserves the encryption overhead
+	overhead := int64(rootFS.encryptor.ciphertextSize(1) - 1)
+	chunk := make([]byte, 100-overhead-10)
+	if _, err := fw.Write(chunk); err != nil {
+		t.Fatalf("Write within limit failed: %v", err)
+	}
+	if used := rootFS.UsedStorage(); used != 90 {
+		t.Fatalf("Expected in-flight buffer to use 90 bytes, got %d", used)
+	}
+
+	// Streaming past the cap is rejected before Close
+	if _, err := fw.Write(make([]byte, 11)); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected storage limit error for in-flight buffer, got: %v", err)
+	}
+	if _, err := fw.Write(make([]byte, 10)); err != nil {
+		t.Fatalf("Write up to the limit failed: %v", err)
+	}
+
+	if err := fw.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	// Encrypting on Close does not push the usage past the cap
+	if used := rootFS.UsedStorage(); used != 100 {
+		t.Fatalf("Expected used storage 100 after Close, got %d", used)
+	}
+
+	// Reopening for append keeps the accounting unchanged
+	f, err := rootFS.OpenFile("stream.bin", os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open for append: %v", err)
+	}
+	if used := rootFS.UsedStorage(); used != 100 {
+		t.Fatalf("Expected used storage 100 while appending, got %d", used)
+	}
+	if _, err := f.(*FileWriter).Write([]byte("x")); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected storage limit error when appending, got: %v", err)
+	}
+	if err := f.(*FileWriter).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+}
+
+// TestEncryptionStatSize tests that Stat reports the plaintext size
+func TestEncryptionStatSize(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("stat-key")))
+
+	data := []byte("0123456789")
+	if err := rootFS.WriteFile("a.txt", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := rootFS.Create("b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Truncate("b.txt", 4); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]int64{"a.txt": 10, "b.txt": 4} {
+		info, err := rootFS.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != want {
+			t.Fatalf("Expected size %d for %s, got %d", want, path, info.Size())
+		}
+
+		f, err := rootFS.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err = f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != want {
+			t.Fatalf("Expected handle size %d for %s, got %d", want, path, info.Size())
+		}
+		f.Close()
+	}
+
+	// The size is recorded with the file, so it is right even without the key
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := loadedFS.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 10 {
+		t.Fatalf("Expected size 10 after loading without the key, got %d", info.Size())
+	}
+}
+
+// TestRotateEncryptionKey tests re-encrypting all files with a new key
+func TestRotateEncryptionKey(t *testing.T) {
+	oldKey := []byte("old-key")
+	newKey := []byte("new-key")
+	rootFS := New(WithEncryption(oldKey), WithVersioning(2))
+
+	if err := rootFS.MkdirAll("dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"a.txt":            "alpha",
+		"dir/b.txt":        "beta",
+		"dir/sub/c.txt":    "gamma",
+		"dir/sub/empty.go": "",
+	}
+	for path, content := range files {
+		if err := rootFS.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A previous version of a.txt
+	if err := rootFS.WriteFile("a.txt", []byte("alpha 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files["a.txt"] = "alpha 2"
+	used := rootFS.UsedStorage()
+
+	// A wrong old key leaves everything untouched
+	if err := rootFS.RotateEncryptionKey([]byte("wrong"), newKey); err == nil {
+		t.Fatal("Expected error when rotating with the wrong old key")
+	}
+	if err := rootFS.RotateEncryptionKey(oldKey, newKey); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range files {
+		content, err := fs.ReadFile(rootFS, path)
+		if err != nil {
+			t.Fatalf("Failed to read %s after rotation: %v", path, err)
+		}
+		if string(content) != want {
+			t.Fatalf("Expected %q for %s, got %q", want, path, content)
+		}
+	}
+	versions, err := rootFS.Versions("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := rootFS.OpenVersion("a.txt", versions[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "alpha" {
+		t.Fatalf("Expected previous version 'alpha', got %q", content)
+	}
+	if got := rootFS.UsedStorage(); got != used {
+		t.Fatalf("Expected used storage to stay %d, got %d", used, got)
+	}
+
+	// The content is only readable with the new key
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadedFS.SetEncryptionKey(oldKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadFile(loadedFS, "a.txt"); err == nil {
+		t.Fatal("Expected the old key to fail after rotation")
+	}
+
+	// Rotating to an empty key stores the files decrypted
+	if err := rootFS.RotateEncryptionKey(newKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	file, err := rootFS.getFile("dir/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(file.Content) != "beta" {
+		t.Fatalf("Expected plaintext content after rotating to no key, got %q", file.Content)
+	}
+
+	rootFS.LockEncryption()
+	if err := rootFS.RotateEncryptionKey(nil, newKey); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Expected fs.ErrPermission with locked encryption, got: %v", err)
+	}
+}
+
+// TestEncryptionCipher tests encryption with ChaCha20-Poly1305
+func TestEncryptionCipher(t *testing.T) {
+	key := []byte("cipher-key")
+	rootFS := New(WithEncryption(key), WithEncryptionCipher(CipherChaCha20Poly1305))
+
+	testData := []byte("This is secret data that should be encrypted at rest")
+	if err := rootFS.WriteFile("secret.txt", testData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	readData, err := fs.ReadFile(rootFS, "secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, testData) {
+		t.Fatalf("Expected %q, got %q", testData, readData)
+	}
+
+	// The content cannot be decrypted as AES-GCM with the same key
+	file, err := rootFS.getFile("secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aes, err := newEncryptor(key, CipherAESGCM, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := aes.decrypt(file.keyPath, file.Content); err == nil {
+		t.Fatal("Expected AES-GCM decryption of ChaCha20-Poly1305 content to fail")
+	}
+
+	// The cipher is saved with the filesystem
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyArchive(bytes.NewReader(buf.Bytes()), key); err != nil {
+		t.Fatal(err)
+	}
+	loadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadedFS.SetEncryptionKey(key); err != nil {
+		t.Fatal(err)
+	}
+	readData, err = fs.ReadFile(loadedFS, "secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readData, testData) {
+		t.Fatalf("Expected %q after loading, got %q", testData, readData)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected WithEncryptionCipher to panic for an unknown cipher")
+		}
+	}()
+	WithEncryptionCipher("rot13")
+}
+
+func benchmarkEncryptionCipher(b *testing.B, cipher string) {
+	rootFS := New(WithEncryption([]byte("bench-key")), WithEncryptionCipher(cipher))
+	testData := bytes.Repeat([]byte("This is secret data that should be encrypted at rest"), 1024)
+
+	b.SetBytes(int64(len(testData)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := rootFS.WriteFile("secret.txt", testData, 0644); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := rootFS.ReadFile("secret.txt"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEncryptionAESGCM measures writing and reading a file with AES-GCM
+func BenchmarkEncryptionAESGCM(b *testing.B) {
+	benchmarkEncryptionCipher(b, CipherAESGCM)
+}
+
+// BenchmarkEncryptionChaCha20Poly1305 measures the same with ChaCha20-Poly1305
+func BenchmarkEncryptionChaCha20Poly1305(b *testing.B) {
+	benchmarkEncryptionCipher(b, CipherChaCha20Poly1305)
+}
+
+// TestEncryptedPaths tests saving a filesystem with encrypted names
+func TestEncryptedPaths(t *testing.T) {
+	key := []byte("paths-key")
+	rootFS := New(WithEncryption(key), WithEncryptedPaths(true), WithVersioning(1))
+
+	if err := rootFS.MkdirAll("secret-project", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"v1", "v2"} {
+		if err := rootFS.WriteFile("secret-project/launch-plan.txt", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rootFS.Symlink("secret-project/launch-plan.txt", "shortcut"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"secret-project", "launch-plan", "shortcut"} {
+		if bytes.Contains(buf.Bytes(), []byte(name)) {
+			t.Fatalf("Saved filesystem contains the name %q", name)
+		}
+	}
+	saved := bytes.Clone(buf.Bytes())
+
+	loadedFS, err := LoadFrom(bytes.NewReader(saved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadedFS.Stat("secret-project"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected the names to be hidden before the key is set, got: %v", err)
+	}
+
+	if err := loadedFS.SetEncryptionKey([]byte("wrong")); err == nil {
+		t.Fatal("Expected error when setting the wrong key")
+	}
+	if err := loadedFS.SetEncryptionKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := fs.ReadFile(loadedFS, "shortcut")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "v2" {
+		t.Fatalf("Expected 'v2', got %q", content)
+	}
+	versions, err := loadedFS.Versions("secret-project/launch-plan.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("Expected 1 previous version, got %d", len(versions))
+	}
+
+	// Saving again keeps the names encrypted, and does not change them in memory
+	buf.Reset()
+	if err := loadedFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("secret-project")) {
+		t.Fatal("Saved filesystem contains the name after loading")
+	}
+	if _, err := loadedFS.Stat("secret-project/launch-plan.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A filesystem that was never unlocked is saved as it is
+	sealedFS, err := LoadFrom(bytes.NewReader(saved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := sealedFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	reloadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadedFS.SetEncryptionKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloadedFS.Stat("secret-project/launch-plan.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPerFileKeys(t *testing.T) {
+	masterKey := []byte("per-file-master-key")
+	rootFS := New(WithPerFileKeys(masterKey), WithVersioning(2))
+
+	if err := rootFS.MkdirAll("docs", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	testData := []byte("same content in every file")
+	for _, path := range []string{"docs/a.txt", "docs/b.txt"} {
+		if err := rootFS.WriteFile(path, testData, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each file is encrypted with the key derived from its own path
+	enc, err := newEncryptor(masterKey, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.bindPath = true
+	shared, err := newEncryptor(masterKey, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := rootFS.getFile("docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shared.decrypt("docs/a.txt", file.Content); err == nil {
+		t.Fatal("Expected decryption with the master key to fail")
+	}
+	if _, err := enc.decrypt("docs/b.txt", file.Content); err == nil {
+		t.Fatal("Expected decryption with the key of another path to fail")
+	}
+	if plaintext, err := enc.decrypt("docs/a.txt", file.Content); err != nil || !bytes.Equal(plaintext, testData) {
+		t.Fatalf("Expected %q, got %q (%v)", testData, plaintext, err)
+	}
+
+	// Copies are encrypted with the key of their new path
+	if err := rootFS.CopyFile("docs/a.txt", "c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.CopyDir("docs", "archive"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"c.txt", "archive/a.txt", "archive/b.txt"} {
+		file, err := rootFS.getFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plaintext, err := enc.decrypt(path, file.Content); err != nil || !bytes.Equal(plaintext, testData) {
+			t.Fatalf("%s: expected %q, got %q (%v)", path, testData, plaintext, err)
+		}
+	}
+
+	// Previous versions keep their key
+	if err := rootFS.WriteFile("docs/a.txt", []byte("new content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := rootFS.Versions("docs/a.txt")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("Expected 1 previous version, got %d (%v)", len(versions), err)
+	}
+	old, err := rootFS.OpenVersion("docs/a.txt", versions[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldData, err := io.ReadAll(old); err != nil || !bytes.Equal(oldData, testData) {
+		t.Fatalf("Expected %q, got %q (%v)", testData, oldData, err)
+	}
+
+	// A sub filesystem derives keys from the full path
+	sub, err := rootFS.Sub("docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.(*FS).WriteFile("d.txt", testData, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err = rootFS.getFile("docs/d.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.decrypt("docs/d.txt", file.Content); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saved filesystems are unlocked with the master key
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadedFS.SetEncryptionKey(masterKey); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"docs/b.txt", "c.txt", "archive/a.txt", "docs/d.txt"} {
+		data, err := loadedFS.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, testData) {
+			t.Fatalf("%s: expected %q, got %q", path, testData, data)
+		}
+	}
+}
+
+func TestEncryptionPassphrase(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+	testData := []byte("This is secret data that should be encrypted at rest")
+
+	for _, params := range []KDFParams{
+		{Algorithm: KDFScrypt, N: 1024},
+		{Algorithm: KDFArgon2id, Memory: 1024},
+	} {
+		t.Run(params.Algorithm, func(t *testing.T) {
+			rootFS := New(WithEncryptionPassphrase(passphrase, params))
+			if err := rootFS.WriteFile("secret.txt", testData, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := rootFS.SaveTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			saved := buf.Bytes()
+
+			// The passphrase alone unlocks the loaded filesystem
+			loadedFS, err := LoadFrom(bytes.NewReader(saved))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := loadedFS.SetEncryptionPassphrase(passphrase); err != nil {
+				t.Fatal(err)
+			}
+			data, err := loadedFS.ReadFile("secret.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, testData) {
+				t.Fatalf("Expected %q, got %q", testData, data)
+			}
+
+			// The passphrase is not the key
+			loadedFS, err = LoadFrom(bytes.NewReader(saved))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := loadedFS.SetEncryptionKey(passphrase); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadedFS.ReadFile("secret.txt"); err == nil {
+				t.Fatal("Expected reading with the passphrase as key to fail")
+			}
+
+			if err := loadedFS.SetEncryptionPassphrase([]byte("wrong")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadedFS.ReadFile("secret.txt"); err == nil {
+				t.Fatal("Expected reading with the wrong passphrase to fail")
+			}
+		})
+	}
+
+	// Every filesystem gets its own salt
+	a := New(WithEncryptionPassphrase(passphrase, KDFParams{Algorithm: KDFScrypt, N: 1024}))
+	b := New(WithEncryptionPassphrase(passphrase, KDFParams{Algorithm: KDFScrypt, N: 1024}))
+	if bytes.Equal(a.kdf.Salt, b.kdf.Salt) || len(a.kdf.Salt) != SaltSize {
+		t.Fatalf("Expected distinct salts of %d bytes, got %x and %x", SaltSize, a.kdf.Salt, b.kdf.Salt)
+	}
+
+	// A filesystem without a passphrase cannot be unlocked with one
+	if err := New().SetEncryptionPassphrase(passphrase); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid, got: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected WithEncryptionPassphrase to panic for an unknown algorithm")
+		}
+	}()
+	WithEncryptionPassphrase(passphrase, KDFParams{Algorithm: "md5"})
+}
+
+func TestSaveEncryptedTo(t *testing.T) {
+	key := []byte("stream-encryption-key")
+	rootFS := New(WithEncryption(key), WithEncryptionCipher(CipherChaCha20Poly1305))
+	if err := rootFS.MkdirAll("secret-project", 0755); err != nil {
+		t.Fatal(err)
+	}
+	testData := bytes.Repeat([]byte("launch at dawn\n"), 10000)
+	if err := rootFS.WriteFile("secret-project/launch-plan.txt", testData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := rootFS.SaveEncryptedTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+	if bytes.Contains(saved, []byte("secret-project")) || bytes.Contains(saved, []byte("launch-plan")) {
+		t.Fatal("Encrypted stream contains a name")
+	}
+
+	loadedFS, err := LoadEncryptedFrom(bytes.NewReader(saved), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := loadedFS.ReadFile("secret-project/launch-plan.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, testData) {
+		t.Fatal("Content mismatch after loading")
+	}
+
+	// A wrong key, modified, truncated or cut off streams are rejected
+	if _, err := LoadEncryptedFrom(bytes.NewReader(saved), []byte("wrong")); !errors.Is(err, ErrCorruptArchive) {
+		t.Fatalf("Expected ErrCorruptArchive for a wrong key, got: %v", err)
+	}
+	modified := bytes.Clone(saved)
+	modified[len(modified)/2] ^= 1
+	if _, err := LoadEncryptedFrom(bytes.NewReader(modified), key); !errors.Is(err, ErrCorruptArchive) {
+		t.Fatalf("Expected ErrCorruptArchive for a modified stream, got: %v", err)
+	}
+	if _, err := LoadEncryptedFrom(bytes.NewReader(saved[:len(saved)-1]), key); !errors.Is(err, ErrCorruptArchive) {
+		t.Fatalf("Expected ErrCorruptArchive for a truncated stream, got: %v", err)
+	}
+	firstChunk := len(streamMagic) + 1 + len(CipherChaCha20Poly1305) + 4 + 4 + streamChunkSize + 16
+	if _, err := LoadEncryptedFrom(bytes.NewReader(saved[:firstChunk]), key); !errors.Is(err, ErrCorruptArchive) {
+		t.Fatalf("Expected ErrCorruptArchive for a stream cut at a chunk, got: %v", err)
+	}
+
+	// Only encrypted filesystems can be saved encrypted
+	if err := New().SaveEncryptedTo(&buf); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid, got: %v", err)
+	}
+}
+
+func TestEncryptionBoundPaths(t *testing.T) {
+	key := []byte("bound-paths-key")
+	rootFS := New(WithEncryption(key))
+	if err := rootFS.WriteFile("a.txt", []byte("content of a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("b.txt", []byte("content of b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Swapping the stored content of two files makes both unreadable
+	a, err := rootFS.getFile("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := rootFS.getFile("b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Content, b.Content = b.Content, a.Content
+	for _, path := range []string{"a.txt", "b.txt"} {
+		if _, err := rootFS.ReadFile(path); err == nil {
+			t.Fatalf("Expected reading swapped content of %s to fail", path)
+		}
+	}
+	a.Content, b.Content = b.Content, a.Content
+
+	// Copies are encrypted for their new path
+	if err := rootFS.CopyFile("a.txt", "c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := rootFS.ReadFile("c.txt"); err != nil || string(data) != "content of a" {
+		t.Fatalf("Expected %q, got %q (%v)", "content of a", data, err)
+	}
+
+	// Filesystems saved before paths were bound can still be read
+	legacyFS := New(WithEncryption(key))
+	legacyFS.boundPaths = false
+	legacyFS.encryptor.bindPath = false
+	if err := legacyFS.WriteFile("a.txt", []byte("content of a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := legacyFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadedFS.SetEncryptionKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := loadedFS.ReadFile("a.txt"); err != nil || string(data) != "content of a" {
+		t.Fatalf("Expected %q, got %q (%v)", "content of a", data, err)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	hmacKey := []byte("integrity-key")
+	testData := []byte("configuration that must not be modified")
+
+	for _, opts := range [][]Option{
+		{WithHMAC(hmacKey)},
+		{WithHMAC(hmacKey), WithEncryption([]byte("encryption-key"))},
+	} {
+		rootFS := New(opts...)
+		encrypted := rootFS.encryptor.encrypts()
+		if err := rootFS.WriteFile("config.yaml", testData, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := rootFS.ReadFile("config.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, testData) {
+			t.Fatalf("Expected %q, got %q", testData, data)
+		}
+		info, err := rootFS.Stat("config.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(testData)) {
+			t.Fatalf("Expected size %d, got %d", len(testData), info.Size())
+		}
+
+		// Without encryption the content is stored as is, followed by the HMAC
+		file, err := rootFS.getFile("config.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !encrypted && !bytes.HasPrefix(file.Content, testData) {
+			t.Fatal("Expected the content to be stored in plaintext")
+		}
+
+		// The filesystem is unlocked with both keys after loading
+		var buf bytes.Buffer
+		if err := rootFS.SaveTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		loadedFS, err := LoadFrom(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := loadedFS.SetHMACKey(hmacKey); err != nil {
+			t.Fatal(err)
+		}
+		if encrypted {
+			if err := loadedFS.SetEncryptionKey([]byte("encryption-key")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if data, err := loadedFS.ReadFile("config.yaml"); err != nil || !bytes.Equal(data, testData) {
+			t.Fatalf("Expected %q after loading, got %q (%v)", testData, data, err)
+		}
+
+		// Modified content is detected
+		rootFS.mu.Lock()
+		file.Content[0] ^= 1
+		rootFS.mu.Unlock()
+		_, err = rootFS.ReadFile("config.yaml")
+		if !errors.Is(err, ErrTampered) || !errors.Is(err, fs.ErrPermission) {
+			t.Fatalf("Expected ErrTampered, got: %v", err)
+		}
+
+		// A wrong HMAC key is detected as well
+		if err := loadedFS.SetHMACKey([]byte("wrong")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadedFS.ReadFile("config.yaml"); !errors.Is(err, ErrTampered) {
+			t.Fatalf("Expected ErrTampered for a wrong key, got: %v", err)
+		}
+	}
+}
+
+func TestHMACKeyRequired(t *testing.T) {
+	hmacKey := []byte("integrity-key")
+	key := []byte("encryption-key")
+	testData := []byte("hello")
+
+	for _, opts := range [][]Option{
+		{WithHMAC(hmacKey)},
+		{WithHMAC(hmacKey), WithCompression()},
+		{WithHMAC(hmacKey), WithEncryption(key)},
+	} {
+		rootFS := New(opts...)
+		if err := rootFS.WriteFile("a.txt", testData, 0644); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := rootFS.SaveTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		loadedFS, err := LoadFrom(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rootFS.encryptor.encrypts() {
+			if err := loadedFS.SetEncryptionKey(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Without the HMAC key, content is neither read nor written
+		if data, err := loadedFS.ReadFile("a.txt"); !errors.Is(err, ErrHMACKeyRequired) || !errors.Is(err, fs.ErrPermission) {
+			t.Fatalf("Expected ErrHMACKeyRequired, got %q (%v)", data, err)
+		}
+		if err := loadedFS.WriteFile("b.txt", testData, 0644); !errors.Is(err, ErrHMACKeyRequired) {
+			t.Fatalf("Expected ErrHMACKeyRequired for a write, got: %v", err)
+		}
+
+		// Saving again keeps the HMAC requirement
+		buf.Reset()
+		if err := loadedFS.SaveTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		reloadedFS, err := LoadFrom(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reloadedFS.ReadFile("a.txt"); !errors.Is(err, ErrHMACKeyRequired) {
+			t.Fatalf("Expected ErrHMACKeyRequired after saving again, got: %v", err)
+		}
+
+		if err := loadedFS.SetHMACKey(hmacKey); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := loadedFS.ReadFile("a.txt"); err != nil || !bytes.Equal(data, testData) {
+			t.Fatalf("Expected %q with the HMAC key, got %q (%v)", testData, data, err)
+		}
+	}
+
+	// The HMAC key is not part of encrypted streams either
+	rootFS := New(WithHMAC(hmacKey), WithEncryption(key))
+	if err := rootFS.WriteFile("a.txt", testData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := rootFS.SaveEncryptedTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loadedFS, err := LoadEncryptedFrom(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadedFS.ReadFile("a.txt"); !errors.Is(err, ErrHMACKeyRequired) || errors.Is(err, ErrDecryption) {
+		t.Fatalf("Expected ErrHMACKeyRequired, got: %v", err)
+	}
+}
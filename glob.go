@@ -0,0 +1,169 @@
+package memfs
+
+import (
+	"io/fs"
+	syspath "path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Glob returns the names of all files matching pattern, in lexical order.
+// The pattern syntax is that of path.Match; each element of the pattern is
+// matched against a single directory level, so directories that cannot
+// match are never visited. Glob implements fs.GlobFS.
+//
+// The only possible returned error is path.ErrBadPattern.
+func (rootFS *FS) Glob(pattern string) ([]string, error) {
+	// Check the pattern is well-formed
+	if _, err := syspath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	if !hasMeta(pattern) {
+		if _, err := rootFS.Stat(pattern); err != nil {
+			return nil, nil
+		}
+		return []string{pattern}, nil
+	}
+
+	// The directories of the lower layer of an overlay have to be read through Open
+	if rootFS.lower != nil {
+		matches, err := fs.Glob(struct{ fs.FS }{rootFS}, pattern)
+		sort.Strings(matches)
+		return matches, err
+	}
+
+	var matches []string
+	rootFS.glob(rootFS.dir, "", strings.Split(pattern, "/"), &matches)
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Walk walks the file tree rooted at root like fs.WalkDir, calling fn only
+// for the entries for which filter returns true. A directory rejected by
+// filter is not descended into. Errors reading an entry are passed to fn
+// without consulting filter. A nil filter accepts every entry.
+func (rootFS *FS) Walk(root string, fn fs.WalkDirFunc, filter func(path string, d fs.DirEntry) bool) error {
+	return fs.WalkDir(rootFS, root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && filter != nil && !filter(path, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		return fn(path, d, err)
+	})
+}
+
+// FindOptions selects the files returned by Find. Zero fields match any file.
+type FindOptions struct {
+	Pattern   string      // path.Match pattern for the base name of the file
+	MinSize   int64       // minimum plaintext size in bytes
+	MaxSize   int64       // maximum plaintext size in bytes
+	ModAfter  time.Time   // modified after this time
+	ModBefore time.Time   // modified before this time
+	Perm      fs.FileMode // exact permission bits
+}
+
+// Find returns the paths of all regular files that match every predicate of
+// opts, in lexical order, like find(1) with -type f. Symbolic links are not
+// followed. The sizes are the sizes of the plaintext, so no content is
+// decrypted. The only possible returned error is path.ErrBadPattern.
+func (rootFS *FS) Find(opts FindOptions) ([]string, error) {
+	if _, err := syspath.Match(opts.Pattern, ""); err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	err := fs.WalkDir(rootFS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if opts.Pattern != "" {
+			if ok, _ := syspath.Match(opts.Pattern, d.Name()); !ok {
+				return nil
+			}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if opts.matches(info) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A walk visits "a/b" before "a.txt"
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// matches reports whether the file described by info passes the size,
+// time and permission predicates of opts
+func (opts *FindOptions) matches(info fs.FileInfo) bool {
+	switch {
+	case info.Size() < opts.MinSize:
+		return false
+	case opts.MaxSize > 0 && info.Size() > opts.MaxSize:
+		return false
+	case !opts.ModAfter.IsZero() && !info.ModTime().After(opts.ModAfter):
+		return false
+	case !opts.ModBefore.IsZero() && !info.ModTime().Before(opts.ModBefore):
+		return false
+	case opts.Perm != 0 && info.Mode().Perm() != opts.Perm.Perm():
+		return false
+	}
+	return true
+}
+
+// glob appends the paths below dir, which is found at prefix, that match the
+// pattern elements in parts
+func (rootFS *FS) glob(dir *Dir, prefix string, parts []string, matches *[]string) {
+	part := parts[0]
+
+	dir.mu.RLock()
+	var names []string
+	if hasMeta(part) {
+		names = dir.sortedChildNames()
+	} else if _, ok := dir.Children[part]; ok {
+		names = []string{part}
+	}
+	children := make(map[string]childI, len(names))
+	for _, name := range names {
+		children[name] = dir.Children[name]
+	}
+	dir.mu.RUnlock()
+
+	for _, name := range names {
+		if ok, _ := syspath.Match(part, name); !ok {
+			continue
+		}
+
+		path := syspath.Join(prefix, name)
+		if len(parts) == 1 {
+			*matches = append(*matches, path)
+			continue
+		}
+
+		switch c := children[name].(type) {
+		case *Dir:
+			rootFS.glob(c, path, parts[1:], matches)
+		case *Symlink:
+			if sub, err := rootFS.getDir(path); err == nil {
+				rootFS.glob(sub, path, parts[1:], matches)
+			}
+		}
+	}
+}
+
+// hasMeta reports whether pattern contains any of the magic characters
+// recognized by path.Match
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
@@ -0,0 +1,188 @@
+package memfs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"slices"
+	"testing"
+	"time"
+)
+
+var _ fs.GlobFS = (*FS)(nil)
+
+// TestGlob tests matching patterns across directory levels
+func TestGlob(t *testing.T) {
+	rootFS := New()
+
+	for _, p := range []string{
+		"a/b/c.txt",
+		"a/x/c.txt",
+		"a/x/d.go",
+		"a/c.txt",
+		"a-b/c.txt",
+		"main.go",
+		"util.go",
+		"readme.md",
+	} {
+		if err := rootFS.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := rootFS.WriteFile(p, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rootFS.Symlink("a/x", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		"*.go":        {"main.go", "util.go"},
+		"a/*/c.txt":   {"a/b/c.txt", "a/x/c.txt"},
+		"*/c.txt":     {"a-b/c.txt", "a/c.txt", "link/c.txt"},
+		"**/*.go":     {"link/d.go"},
+		"*/*/*.go":    {"a/x/d.go"},
+		"a/?/[cd].*":  {"a/b/c.txt", "a/x/c.txt", "a/x/d.go"},
+		"link/*.go":   {"link/d.go"},
+		"a/b/c.txt":   {"a/b/c.txt"},
+		"a/nope/*":    nil,
+		"missing.txt": nil,
+	}
+	for pattern, expected := range tests {
+		matches, err := rootFS.Glob(pattern)
+		if err != nil {
+			t.Fatalf("Glob(%q): %v", pattern, err)
+		}
+		if !slices.Equal(matches, expected) {
+			t.Errorf("Glob(%q): expected %v, got %v", pattern, expected, matches)
+		}
+
+		// fs.Glob takes the fast path and agrees with the generic implementation
+		viaFS, err := fs.Glob(rootFS, pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		generic, err := fs.Glob(struct{ fs.FS }{rootFS}, pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(generic)
+		if !slices.Equal(viaFS, generic) {
+			t.Errorf("fs.Glob(%q): expected %v, got %v", pattern, generic, viaFS)
+		}
+	}
+
+	if _, err := rootFS.Glob("a/[b"); !errors.Is(err, path.ErrBadPattern) {
+		t.Fatalf("Expected path.ErrBadPattern, got: %v", err)
+	}
+}
+
+// TestWalk tests skipping entries with the filter of Walk
+func TestWalk(t *testing.T) {
+	rootFS := New()
+	for _, p := range []string{"a.txt", "b.go", "src/c.go", "src/d.txt", "vendor/e.go"} {
+		if err := rootFS.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := rootFS.WriteFile(p, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var visited []string
+	err := rootFS.Walk(".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, p)
+		return nil
+	}, func(p string, d fs.DirEntry) bool {
+		if d.IsDir() {
+			return p != "vendor"
+		}
+		return path.Ext(p) == ".go"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", "b.go", "src", "src/c.go"}
+	if !slices.Equal(visited, want) {
+		t.Fatalf("Expected %v, got %v", want, visited)
+	}
+
+	// Without a filter, Walk visits everything like fs.WalkDir
+	visited = nil
+	err = rootFS.Walk("src", func(p string, d fs.DirEntry, err error) error {
+		visited = append(visited, p)
+		return err
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"src", "src/c.go", "src/d.txt"}; !slices.Equal(visited, want) {
+		t.Fatalf("Expected %v, got %v", want, visited)
+	}
+
+	// Errors reach fn even if the filter would reject the entry
+	err = rootFS.Walk("missing", func(p string, d fs.DirEntry, err error) error {
+		return err
+	}, func(string, fs.DirEntry) bool { return false })
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist, got: %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("key")))
+	files := map[string]string{
+		"app.log":       "0123456789",
+		"logs/old.log":  "0123456789012345678901234567890",
+		"logs/new.log":  "01",
+		"logs/notes.md": "0123456789",
+		"a.txt":         "0123456789",
+	}
+	for p, content := range files {
+		if err := rootFS.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := rootFS.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rootFS.Chmod("app.log", 0600); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := rootFS.Chtimes("logs/old.log", time.Time{}, past.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts FindOptions
+		want []string
+	}{
+		{"all", FindOptions{}, []string{"a.txt", "app.log", "logs/new.log", "logs/notes.md", "logs/old.log"}},
+		{"pattern", FindOptions{Pattern: "*.log"}, []string{"app.log", "logs/new.log", "logs/old.log"}},
+		{"min size", FindOptions{Pattern: "*.log", MinSize: 10}, []string{"app.log", "logs/old.log"}},
+		{"max size", FindOptions{MaxSize: 10}, []string{"a.txt", "app.log", "logs/new.log", "logs/notes.md"}},
+		{"modified after", FindOptions{Pattern: "*.log", ModAfter: past}, []string{"app.log", "logs/new.log"}},
+		{"modified before", FindOptions{ModBefore: past}, []string{"logs/old.log"}},
+		{"perm", FindOptions{Perm: 0600}, []string{"app.log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rootFS.Find(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := rootFS.Find(FindOptions{Pattern: "["}); !errors.Is(err, path.ErrBadPattern) {
+		t.Fatalf("Expected path.ErrBadPattern, got: %v", err)
+	}
+}
@@ -2,4 +2,10 @@ module github.com/boomhut/memfs
 
 go 1.23.5
 
-require github.com/google/go-cmp v0.5.4
+require (
+	github.com/google/go-cmp v0.5.4
+	github.com/klauspost/compress v1.17.11
+	golang.org/x/crypto v0.31.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
@@ -0,0 +1,131 @@
+package memfs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// ErrTampered is returned when the HMAC of the stored content of a file does
+// not match, because the content has been modified or the HMAC key is wrong
+// (see WithHMAC). Errors that wrap it also match fs.ErrPermission.
+var ErrTampered = errors.New("memfs: content has been tampered with")
+
+// ErrHMACKeyRequired is returned when the content of a file is read or
+// written in a filesystem that was saved with WithHMAC and loaded again,
+// before the HMAC key is set with SetHMACKey. Errors that wrap it also match
+// fs.ErrPermission.
+var ErrHMACKeyRequired = errors.New("memfs: HMAC key required")
+
+// setMAC enables authentication of content with an HMAC-SHA256 keyed by key
+func (e *encryptor) setMAC(key []byte) {
+	if len(key) == 0 {
+		return
+	}
+	e.macKey = bytes.Clone(key)
+	e.enable = true
+}
+
+// requireMAC marks content as ending with an HMAC whose key is not known
+// yet, so that it is not mistaken for unauthenticated content
+func (e *encryptor) requireMAC() {
+	e.macRequired = true
+	e.enable = true
+}
+
+// checkMACKey returns an error if content ends with an HMAC whose key has
+// not been set
+func (e *encryptor) checkMACKey(path string) error {
+	if e.macKey == nil && e.macRequired {
+		return fmt.Errorf("%w: %s: %w", ErrHMACKeyRequired, path, fs.ErrPermission)
+	}
+	return nil
+}
+
+// mac returns the HMAC of the stored content of the file at path
+func (e *encryptor) mac(path string, content []byte) []byte {
+	h := hmac.New(sha256.New, e.macKey)
+	if e.bindPath {
+		h.Write([]byte(path))
+		h.Write([]byte{0})
+	}
+	h.Write(content)
+	return h.Sum(nil)
+}
+
+// appendMAC appends the HMAC of content to it, if authentication is enabled
+func (e *encryptor) appendMAC(path string, content []byte) []byte {
+	if e.macKey == nil {
+		return content
+	}
+	return append(content, e.mac(path, content)...)
+}
+
+// checkMAC verifies and strips the HMAC at the end of content, if
+// authentication is enabled
+func (e *encryptor) checkMAC(path string, content []byte) ([]byte, error) {
+	if err := e.checkMACKey(path); err != nil {
+		return nil, err
+	}
+	if e.macKey == nil {
+		return content, nil
+	}
+
+	n := len(content) - sha256.Size
+	if n < 0 || !hmac.Equal(content[n:], e.mac(path, content[:n])) {
+		return nil, fmt.Errorf("%w: %s: %w", ErrTampered, path, fs.ErrPermission)
+	}
+	return content[:n], nil
+}
+
+// encryptorFor returns an encryptor for key with the cipher, HMAC key,
+// compression and path settings of the filesystem
+func (rootFS *FS) encryptorFor(key []byte) (*encryptor, error) {
+	enc, err := newEncryptor(key, rootFS.cipher, rootFS.perFileKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	enc.bindPath = rootFS.boundPaths
+	enc.chunked = rootFS.chunked
+	enc.setMAC(rootFS.macKey)
+	if rootFS.macRequired {
+		enc.requireMAC()
+	}
+	enc.setCompression(rootFS.compress, rootFS.compressLevel)
+	return enc, nil
+}
+
+// SetHMACKey sets the key that the content of files is authenticated with,
+// see WithHMAC. Like SetEncryptionKey it has to be called after loading a
+// filesystem that was saved with WithHMAC; until then, reading or writing
+// the content of files fails with an error wrapping ErrHMACKeyRequired. The
+// HMAC key cannot be removed again.
+//
+// If the encryption configuration has been locked with LockEncryption,
+// SetHMACKey returns an error wrapping fs.ErrPermission.
+func (rootFS *FS) SetHMACKey(key []byte) error {
+	if err := rootFS.checkEncryptionUnlocked(); err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("empty HMAC key: %w", fs.ErrInvalid)
+	}
+
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+
+	enc := *rootFS.encryptor
+	enc.bindPath = rootFS.boundPaths
+	enc.setMAC(key)
+	enc.macRequired = false
+	rootFS.macKey = enc.macKey
+	rootFS.macRequired = false
+	rootFS.encryptor = &enc
+	return nil
+}
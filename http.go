@@ -0,0 +1,24 @@
+package memfs
+
+import (
+	"net/http"
+)
+
+// HTTPFileSystem returns the filesystem as an http.FileSystem, e.g. for
+// http.FileServer. Files are served with their decrypted content and support
+// seeking, so range requests work.
+func (rootFS *FS) HTTPFileSystem() http.FileSystem {
+	return http.FS(rootFS)
+}
+
+// FileServer returns a handler that serves HTTP requests with the content of
+// the filesystem, including directory listings. The prefix, such as
+// "/static/", is removed from the request path before looking up the file;
+// an empty prefix serves the filesystem from the root of the URL space.
+func (rootFS *FS) FileServer(prefix string) http.Handler {
+	handler := http.FileServer(rootFS.HTTPFileSystem())
+	if prefix == "" {
+		return handler
+	}
+	return http.StripPrefix(prefix, handler)
+}
@@ -0,0 +1,72 @@
+package memfs
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestFileServer tests serving files and directory listings over HTTP
+func TestFileServer(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("http-key")))
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/hello.txt", []byte("hello, world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/other.txt", []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(rootFS.FileServer("/static/"))
+	defer server.Close()
+
+	get := func(path string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	resp, body := get("/static/dir/hello.txt", nil)
+	if resp.StatusCode != http.StatusOK || body != "hello, world" {
+		t.Fatalf("Expected 200 'hello, world', got %d %q", resp.StatusCode, body)
+	}
+
+	resp, body = get("/static/dir/hello.txt", http.Header{"Range": {"bytes=7-11"}})
+	if resp.StatusCode != http.StatusPartialContent || body != "world" {
+		t.Fatalf("Expected 206 'world', got %d %q", resp.StatusCode, body)
+	}
+
+	resp, body = get("/static/dir/", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for the directory listing, got %d", resp.StatusCode)
+	}
+	for _, name := range []string{"hello.txt", "other.txt"} {
+		if !strings.Contains(body, name) {
+			t.Fatalf("Expected the listing to contain %s, got %q", name, body)
+		}
+	}
+
+	resp, _ = get("/static/missing.txt", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a missing file, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,243 @@
+package memfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	syspath "path"
+	"strings"
+	"time"
+)
+
+// NewFromFS creates a new in-memory FileSystem holding a copy of every
+// directory and regular file in src, such as an os.DirFS or an embed.FS.
+// Other file types are skipped. The options are applied just like with New,
+// so the copy is encrypted and limited as configured. If WithReadOnly is
+// given, the filesystem becomes read-only once the copy is complete.
+func NewFromFS(src fs.FS, opts ...Option) (*FS, error) {
+	rootFS := New(opts...)
+
+	restore := rootFS.writable()
+
+	err := fs.WalkDir(src, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == "." {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return rootFS.MkdirAll(path, info.Mode().Perm())
+		case d.Type().IsRegular():
+			data, err := fs.ReadFile(src, path)
+			if err != nil {
+				return err
+			}
+			return rootFS.WriteFile(path, data, info.Mode().Perm())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	restore()
+	return rootFS, nil
+}
+
+// CopyOptions configures CopyBetween
+type CopyOptions struct {
+	// IncludeHidden copies the files and directories whose name starts with
+	// a dot, which are skipped otherwise
+	IncludeHidden bool
+}
+
+// CopyBetween copies the tree at srcRoot in src, such as an os.DirFS, an
+// embed.FS or another FS, to dstRoot in dst. srcRoot may also name a single
+// regular file, which is copied to dstRoot. Unlike NewFromFS, only part of
+// src is copied into an existing filesystem: files keep their permissions and
+// replace files of the same name in dst, and directories are only created
+// when a file is copied into them, with their permissions in src. Missing
+// parents of dstRoot are created with mode 0755. Other file types are
+// skipped, as are entries whose name starts with a dot unless IncludeHidden
+// is set in opts; only the first CopyOptions is used.
+//
+// If a file cannot be copied, the files and directories created until then
+// are removed, the files replaced are restored, and the error is returned.
+// If the rollback fails too, its errors are joined to the returned error.
+func CopyBetween(src fs.FS, dst *FS, srcRoot, dstRoot string, opts ...CopyOptions) error {
+	if !fs.ValidPath(srcRoot) {
+		return fmt.Errorf("invalid path: %s: %w", srcRoot, fs.ErrInvalid)
+	}
+	if !fs.ValidPath(dstRoot) {
+		return fmt.Errorf("invalid path: %s: %w", dstRoot, fs.ErrInvalid)
+	}
+
+	var opt CopyOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	c := &copier{src: src, dst: dst, dirPerms: make(map[string]fs.FileMode)}
+	err := fs.WalkDir(src, srcRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != srcRoot && !opt.IncludeHidden && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		target := dstRoot
+		if path != srcRoot {
+			rel := path
+			if srcRoot != "." {
+				rel = strings.TrimPrefix(path, srcRoot+"/")
+			}
+			target = syspath.Join(dstRoot, rel)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			c.dirPerms[target] = info.Mode().Perm()
+		case d.Type().IsRegular():
+			return c.copyFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+	if err != nil {
+		if rerr := c.rollback(); rerr != nil {
+			return errors.Join(err, fmt.Errorf("rollback incomplete: %w", rerr))
+		}
+		return err
+	}
+	return nil
+}
+
+// copier copies files into dst for CopyBetween and records the changes it
+// made, so that they can be undone
+type copier struct {
+	src      fs.FS
+	dst      *FS
+	dirPerms map[string]fs.FileMode // permissions of the source directories by target path
+	created  []string               // paths created in dst, in order
+	replaced []replacedFile
+}
+
+// replacedFile is a file of dst that was replaced by a copied file
+type replacedFile struct {
+	path    string
+	content []byte
+	perm    fs.FileMode
+	modTime time.Time
+}
+
+// copyFile copies the file at path in src to target in dst
+func (c *copier) copyFile(path, target string, perm fs.FileMode) error {
+	data, err := fs.ReadFile(c.src, path)
+	if err != nil {
+		return err
+	}
+	if err := c.mkdir(syspath.Dir(target)); err != nil {
+		return err
+	}
+
+	info, err := c.dst.Stat(target)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if exists && !info.IsDir() {
+		old, err := c.dst.ReadFile(target)
+		if err != nil {
+			return err
+		}
+		c.replaced = append(c.replaced, replacedFile{
+			path:    target,
+			content: old,
+			perm:    info.Mode().Perm(),
+			modTime: info.ModTime(),
+		})
+	}
+
+	if err := c.dst.WriteFile(target, data, perm); err != nil {
+		return err
+	}
+	if exists {
+		// WriteFile keeps the permissions of existing files
+		return c.dst.Chmod(target, perm)
+	}
+	c.created = append(c.created, target)
+	return nil
+}
+
+// mkdir creates the directory path in dst and its missing parents, with the
+// permissions of the corresponding source directories
+func (c *copier) mkdir(path string) error {
+	if path == "." {
+		return nil
+	}
+	info, err := c.dst.Stat(path)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("not a directory: %s: %w", path, fs.ErrExist)
+		}
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err := c.mkdir(syspath.Dir(path)); err != nil {
+		return err
+	}
+	perm, ok := c.dirPerms[path]
+	if !ok {
+		perm = 0755
+	}
+	if err := c.dst.Mkdir(path, perm); err != nil {
+		return err
+	}
+	c.created = append(c.created, path)
+	return nil
+}
+
+// rollback restores the files replaced by the copy and removes the ones it
+// created. It carries on after errors, so that as much as possible is
+// undone, and returns them joined.
+func (c *copier) rollback() error {
+	var errs []error
+	for i := len(c.replaced) - 1; i >= 0; i-- {
+		r := c.replaced[i]
+		if err := c.dst.WriteFile(r.path, r.content, r.perm); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := c.dst.Chmod(r.path, r.perm); err != nil {
+			errs = append(errs, err)
+		}
+		if err := c.dst.Chtimes(r.path, time.Time{}, r.modTime); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i := len(c.created) - 1; i >= 0; i-- {
+		if err := c.dst.RemoveAll(c.created[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,255 @@
+package memfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewFromFS(t *testing.T) {
+	src := fstest.MapFS{
+		"root.txt":          {Data: []byte("root content"), Mode: 0o644},
+		"foo/file1.txt":     {Data: []byte("content1"), Mode: 0o600},
+		"foo/bar/file2.txt": {Data: []byte("content2"), Mode: 0o644},
+		"empty":             {Mode: fs.ModeDir | 0o750},
+	}
+
+	rootFS, err := NewFromFS(src, WithEncryption([]byte("fixture-key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, file := range src {
+		if file.Mode.IsDir() {
+			continue
+		}
+		got, err := fs.ReadFile(rootFS, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(file.Data, got); diff != "" {
+			t.Fatalf("content mismatch for %s: %s", path, diff)
+		}
+	}
+
+	info, err := fs.Stat(rootFS, "foo/file1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0o600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode())
+	}
+
+	info, err = fs.Stat(rootFS, "empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Errorf("expected empty to be a directory")
+	}
+
+	// The copy is mutable
+	if err := rootFS.WriteFile("foo/new.txt", []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewFromDirFS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "file.txt"), []byte("on disk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rootFS, err := NewFromFS(os.DirFS(dir), WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.ReadFile(rootFS, "sub/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "on disk" {
+		t.Fatalf("expected 'on disk', got %q", got)
+	}
+
+	err = rootFS.WriteFile("sub/file.txt", []byte("changed"), 0o644)
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("expected fs.ErrPermission, got: %v", err)
+	}
+}
+
+func TestCopyBetween(t *testing.T) {
+	src := fstest.MapFS{
+		"assets/app.js":         {Data: []byte("app"), Mode: 0o644},
+		"assets/.env":           {Data: []byte("secret"), Mode: 0o600},
+		"assets/.cache/tmp":     {Data: []byte("tmp"), Mode: 0o644},
+		"assets/css":            {Mode: fs.ModeDir | 0o750},
+		"assets/css/site.css":   {Data: []byte("css"), Mode: 0o640},
+		"assets/empty":          {Mode: fs.ModeDir | 0o755},
+		"other/not-copied.txt":  {Data: []byte("other"), Mode: 0o644},
+		"assets/img/logo.svg":   {Data: []byte("<svg/>"), Mode: 0o644},
+		"assets/img/.thumbnail": {Data: []byte("thumb"), Mode: 0o644},
+	}
+
+	dst := New(WithEncryption([]byte("copy-key")))
+	if err := CopyBetween(src, dst, "assets", "static/assets"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	if err := fs.WalkDir(dst, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			got = append(got, path)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"static/assets/app.js", "static/assets/css/site.css", "static/assets/img/logo.svg"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("copied files mismatch: %s", diff)
+	}
+
+	if content, err := dst.ReadFile("static/assets/css/site.css"); err != nil || string(content) != "css" {
+		t.Fatalf("expected 'css', got %q, %v", content, err)
+	}
+	for path, mode := range map[string]fs.FileMode{
+		"static/assets/css/site.css": 0o640,
+		"static/assets/css":          fs.ModeDir | 0o750,
+		"static":                     fs.ModeDir | 0o755,
+	} {
+		info, err := dst.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != mode {
+			t.Errorf("expected mode %v for %s, got %v", mode, path, info.Mode())
+		}
+	}
+	if dst.Exists("static/assets/empty") {
+		t.Error("expected directories without files not to be created")
+	}
+
+	// Hidden files are copied on request, and a single file can be copied
+	if err := CopyBetween(src, dst, "assets", "hidden", CopyOptions{IncludeHidden: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"hidden/.env", "hidden/.cache/tmp", "hidden/img/.thumbnail"} {
+		if ok, err := dst.IsFile(path); !ok || err != nil {
+			t.Errorf("expected %s to be copied, got %v", path, err)
+		}
+	}
+	if err := CopyBetween(src, dst, "other/not-copied.txt", "single/copy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := dst.ReadFile("single/copy.txt"); err != nil || string(content) != "other" {
+		t.Fatalf("expected 'other', got %q, %v", content, err)
+	}
+
+	if err := CopyBetween(src, dst, "missing", "x"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got: %v", err)
+	}
+	if err := CopyBetween(src, dst, "/abs", "x"); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("expected fs.ErrInvalid, got: %v", err)
+	}
+}
+
+func TestCopyBetweenRollback(t *testing.T) {
+	src := fstest.MapFS{
+		"a.txt":     {Data: []byte("new content"), Mode: 0o644},
+		"b/big.txt": {Data: []byte("too large for the limit"), Mode: 0o644},
+	}
+
+	dst := New(WithMaxStorage(20))
+	if err := dst.MkdirAll("out", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.WriteFile("out/a.txt", []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := CopyBetween(src, dst, ".", "out")
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("expected the storage limit to be exceeded, got: %v", err)
+	}
+
+	content, err := dst.ReadFile("out/a.txt")
+	if err != nil || string(content) != "old" {
+		t.Fatalf("expected the replaced file to be restored, got %q, %v", content, err)
+	}
+	info, err := dst.Stat("out/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0o600 {
+		t.Errorf("expected mode 0600 after the rollback, got %v", info.Mode())
+	}
+	if dst.Exists("out/b") {
+		t.Error("expected the created directory to be removed")
+	}
+	if got := dst.UsedStorage(); got != 3 {
+		t.Errorf("expected used storage 3 after the rollback, got %d", got)
+	}
+}
+
+// openHookFS is an fs.FS that calls hook before opening or reading a file
+type openHookFS struct {
+	fstest.MapFS
+	hook func(name string) error
+}
+
+func (f openHookFS) Open(name string) (fs.File, error) {
+	if err := f.hook(name); err != nil {
+		return nil, err
+	}
+	return f.MapFS.Open(name)
+}
+
+func (f openHookFS) ReadFile(name string) ([]byte, error) {
+	if err := f.hook(name); err != nil {
+		return nil, err
+	}
+	return f.MapFS.ReadFile(name)
+}
+
+func TestCopyBetweenRollbackError(t *testing.T) {
+	dst := New()
+	errRead := errors.New("read failed")
+	src := openHookFS{
+		MapFS: fstest.MapFS{
+			"a.txt": {Data: []byte("a"), Mode: 0o644},
+			"b.txt": {Data: []byte("b"), Mode: 0o644},
+		},
+		hook: func(name string) error {
+			if name == "b.txt" {
+				// The copied a.txt can no longer be removed
+				dst.SetReadOnly(true)
+				return errRead
+			}
+			return nil
+		},
+	}
+
+	err := CopyBetween(src, dst, ".", "out")
+	if !errors.Is(err, errRead) {
+		t.Fatalf("expected the copy error, got: %v", err)
+	}
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("expected the rollback error, got: %v", err)
+	}
+	if !dst.Exists("out/a.txt") {
+		t.Error("expected out/a.txt to be left in place")
+	}
+}
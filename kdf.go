@@ -0,0 +1,116 @@
+package memfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io/fs"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// SaltSize is the length of the salts returned by GenerateSalt
+const SaltSize = 16
+
+// Key derivation functions for WithEncryptionPassphrase
+const (
+	KDFScrypt   = "scrypt"
+	KDFArgon2id = "argon2id"
+)
+
+// KDFParams selects the key derivation function and its cost parameters for
+// WithEncryptionPassphrase. Fields that are zero take the default shown for
+// them. The parameters are saved with the filesystem; they are not secret.
+type KDFParams struct {
+	Algorithm string // KDFScrypt or KDFArgon2id, default KDFArgon2id
+	Salt      []byte // default a new salt from GenerateSalt
+
+	// scrypt
+	N int // CPU/memory cost, a power of two, default 32768
+	R int // block size, default 8
+	P int // parallelization, default 1
+
+	// argon2id
+	Time    uint32 // number of passes, default 1
+	Memory  uint32 // memory in KiB, default 64 MiB
+	Threads uint8  // default 4
+}
+
+// GenerateSalt returns SaltSize random bytes to be used as KDFParams.Salt
+func GenerateSalt() []byte {
+	salt := make([]byte, SaltSize)
+	// crypto/rand does not return errors
+	rand.Read(salt)
+	return salt
+}
+
+// withDefaults returns a copy of p with the defaults filled in
+func (p KDFParams) withDefaults() KDFParams {
+	if p.Algorithm == "" {
+		p.Algorithm = KDFArgon2id
+	}
+	if len(p.Salt) == 0 {
+		p.Salt = GenerateSalt()
+	} else {
+		p.Salt = bytes.Clone(p.Salt)
+	}
+
+	switch p.Algorithm {
+	case KDFScrypt:
+		if p.N == 0 {
+			p.N = 32768
+		}
+		if p.R == 0 {
+			p.R = 8
+		}
+		if p.P == 0 {
+			p.P = 1
+		}
+	case KDFArgon2id:
+		if p.Time == 0 {
+			p.Time = 1
+		}
+		if p.Memory == 0 {
+			p.Memory = 64 * 1024
+		}
+		if p.Threads == 0 {
+			p.Threads = 4
+		}
+	}
+	return p
+}
+
+// deriveKey derives a 32 byte key from passphrase
+func (p *KDFParams) deriveKey(passphrase []byte) ([]byte, error) {
+	switch p.Algorithm {
+	case KDFScrypt:
+		key, err := scrypt.Key(passphrase, p.Salt, p.N, p.R, p.P, 32)
+		if err != nil {
+			return nil, fmt.Errorf("scrypt: %w: %w", err, fs.ErrInvalid)
+		}
+		return key, nil
+	case KDFArgon2id:
+		return argon2.IDKey(passphrase, p.Salt, p.Time, p.Memory, p.Threads, 32), nil
+	}
+	return nil, fmt.Errorf("unknown key derivation function: %s: %w", p.Algorithm, fs.ErrInvalid)
+}
+
+// SetEncryptionPassphrase is like SetEncryptionKey for a filesystem that was
+// saved with WithEncryptionPassphrase: it derives the key from passphrase
+// with the parameters and salt saved with the filesystem. If the filesystem
+// has no key derivation parameters, an error wrapping fs.ErrInvalid is returned.
+func (rootFS *FS) SetEncryptionPassphrase(passphrase []byte) error {
+	rootFS.mu.Lock()
+	kdf := rootFS.kdf
+	rootFS.mu.Unlock()
+	if kdf == nil {
+		return fmt.Errorf("no key derivation parameters: %w", fs.ErrInvalid)
+	}
+
+	key, err := kdf.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	return rootFS.SetEncryptionKey(key)
+}
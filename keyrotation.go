@@ -0,0 +1,123 @@
+package memfs
+
+import (
+	"fmt"
+	"io/fs"
+	syspath "path"
+)
+
+// RotateEncryptionKey re-encrypts every stored file, including previous
+// versions, from oldKey to newKey and makes newKey the key of the
+// filesystem, so SetEncryptionKey does not have to be called afterwards.
+// An empty oldKey encrypts a filesystem that was not encrypted before, an
+// empty newKey stores all files decrypted.
+//
+// The whole tree is locked while the files are re-encrypted, so the rotation
+// is atomic: if a file cannot be decrypted with oldKey, an error is returned
+// and nothing is changed. Files that are being written hold plaintext and
+// are encrypted with newKey when their last FileWriter is closed.
+// Filesystems returned by Sub before the rotation keep using the old key.
+// The key derivation parameters of WithEncryptionPassphrase are dropped, as
+// newKey is used as is.
+//
+// If the encryption configuration has been locked with LockEncryption,
+// RotateEncryptionKey returns an error wrapping fs.ErrPermission.
+func (rootFS *FS) RotateEncryptionKey(oldKey, newKey []byte) error {
+	if err := rootFS.checkEncryptionUnlocked(); err != nil {
+		return err
+	}
+
+	rootFS.mu.Lock()
+	sealed := rootFS.sealedPaths
+	rootFS.mu.Unlock()
+	if sealed {
+		return fmt.Errorf("file names are still encrypted, call SetEncryptionKey first: %w", fs.ErrInvalid)
+	}
+
+	oldEnc, err := rootFS.encryptorFor(oldKey)
+	if err != nil {
+		return err
+	}
+	newEnc, err := rootFS.encryptorFor(newKey)
+	if err != nil {
+		return err
+	}
+
+	// Directories are locked parents first, like everywhere else, and the
+	// storage lock is taken last
+	var dirs []lockedDir
+	lockTree(rootFS.dir, "", &dirs)
+	defer func() {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			dirs[i].dir.mu.Unlock()
+		}
+	}()
+
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+
+	type update struct {
+		file      *File
+		content   []byte
+		plainSize int64
+	}
+	var (
+		updates []update
+		delta   int64
+	)
+	for _, d := range dirs {
+		for name, child := range d.dir.Children {
+			file, ok := child.(*File)
+			if !ok {
+				continue
+			}
+			path := syspath.Join(d.path, name)
+
+			versions := file.History
+			if file.writers == 0 {
+				versions = append(versions[:len(versions):len(versions)], file)
+			}
+			for _, v := range versions {
+				plaintext, err := oldEnc.decrypt(v.keyPath, v.Content)
+				if err != nil {
+					return err
+				}
+				content, err := newEnc.encrypt(v.keyPath, plaintext)
+				if err != nil {
+					return fmt.Errorf("encryption failed: %s: %w", path, err)
+				}
+				updates = append(updates, update{file: v, content: content, plainSize: int64(len(plaintext))})
+				delta += int64(len(content)) - int64(len(v.Content))
+			}
+		}
+	}
+
+	rootFS.usedStorage += delta
+	for _, u := range updates {
+		rootFS.unshare(u.file)
+		u.file.Content = u.content
+		u.file.PlaintextSize = u.plainSize
+		rootFS.intern(u.file)
+	}
+	rootFS.encryptor = newEnc
+	rootFS.kdf = nil
+	return nil
+}
+
+// lockedDir is a directory locked by lockTree, found at path
+type lockedDir struct {
+	dir  *Dir
+	path string
+}
+
+// lockTree locks dir and every directory below it, parents before their
+// children, and appends them to dirs in the order they were locked
+func lockTree(dir *Dir, path string, dirs *[]lockedDir) {
+	dir.mu.Lock()
+	*dirs = append(*dirs, lockedDir{dir: dir, path: path})
+	for name, child := range dir.Children {
+		if sub, ok := child.(*Dir); ok {
+			lockTree(sub, syspath.Join(path, name), dirs)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package memfs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sync"
+)
+
+// Unlocker releases an advisory lock taken with Lock, TryLock or LockCtx
+type Unlocker interface {
+	Unlock() error
+}
+
+// fileLock is the advisory lock of a path; refs counts the holders and
+// waiters, so the entry can be dropped when nobody uses it
+type fileLock struct {
+	held chan struct{}
+	refs int
+}
+
+// Lock takes the advisory lock of the file at path, waiting until it is
+// released if another caller holds it. Advisory locks only exclude each
+// other: reads and writes of the file are not blocked. The lock belongs to
+// the path, with symbolic links resolved, and survives the file being
+// rewritten. The file must exist when Lock is called.
+func (rootFS *FS) Lock(path string) (Unlocker, error) {
+	return rootFS.LockCtx(context.Background(), path)
+}
+
+// LockCtx is like Lock, but gives up with ctx.Err() if ctx is done before
+// the lock is acquired.
+func (rootFS *FS) LockCtx(ctx context.Context, path string) (Unlocker, error) {
+	key, l, err := rootFS.acquireLock(path)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case l.held <- struct{}{}:
+		return &fileUnlocker{fs: rootFS, key: key, lock: l}, nil
+	case <-ctx.Done():
+		rootFS.releaseLock(key, l)
+		return nil, ctx.Err()
+	}
+}
+
+// TryLock is like Lock, but returns false instead of waiting if the lock of
+// the file at path is held.
+func (rootFS *FS) TryLock(path string) (Unlocker, bool, error) {
+	key, l, err := rootFS.acquireLock(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	select {
+	case l.held <- struct{}{}:
+		return &fileUnlocker{fs: rootFS, key: key, lock: l}, true, nil
+	default:
+		rootFS.releaseLock(key, l)
+		return nil, false, nil
+	}
+}
+
+// acquireLock returns the lock of the file at path and its key, counting the
+// caller as a user until releaseLock is called
+func (rootFS *FS) acquireLock(path string) (string, *fileLock, error) {
+	if _, err := rootFS.getFile(path); err != nil {
+		return "", nil, err
+	}
+	key := rootFS.keyPath(path)
+
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+
+	if rootFS.locks == nil {
+		rootFS.locks = make(map[string]*fileLock)
+	}
+	l := rootFS.locks[key]
+	if l == nil {
+		l = &fileLock{held: make(chan struct{}, 1)}
+		rootFS.locks[key] = l
+	}
+	l.refs++
+	return key, l, nil
+}
+
+// releaseLock stops counting the caller as a user of l
+func (rootFS *FS) releaseLock(key string, l *fileLock) {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+
+	l.refs--
+	if l.refs == 0 {
+		delete(rootFS.locks, key)
+	}
+}
+
+// fileUnlocker releases a held advisory lock once
+type fileUnlocker struct {
+	fs   *FS
+	key  string
+	lock *fileLock
+	once sync.Once
+}
+
+// Unlock releases the lock. Calling it again returns an error wrapping fs.ErrInvalid.
+func (u *fileUnlocker) Unlock() error {
+	released := false
+	u.once.Do(func() {
+		<-u.lock.held
+		u.fs.releaseLock(u.key, u.lock)
+		released = true
+	})
+	if !released {
+		return fmt.Errorf("lock already released: %s: %w", u.key, fs.ErrInvalid)
+	}
+	return nil
+}
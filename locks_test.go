@@ -0,0 +1,79 @@
+package memfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	rootFS := New()
+	if err := rootFS.WriteFile("file.txt", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Symlink("file.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	unlocker, err := rootFS.Lock("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The lock is held for the file, also through a link
+	if _, ok, err := rootFS.TryLock("link"); err != nil || ok {
+		t.Fatalf("Expected TryLock to fail while the lock is held, got %v, %v", ok, err)
+	}
+
+	// Reads and writes are not blocked
+	if _, err := rootFS.ReadFile("file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("file.txt", []byte("new data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := rootFS.LockCtx(ctx, "file.txt"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	// A waiting Lock gets the lock once it is released
+	acquired := make(chan Unlocker)
+	go func() {
+		u, err := rootFS.Lock("file.txt")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- u
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Expected Lock to wait while the lock is held")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if err := unlocker.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := unlocker.Unlock(); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid for a second Unlock, got: %v", err)
+	}
+
+	second := <-acquired
+	if err := second.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unused locks are dropped
+	if len(rootFS.locks) != 0 {
+		t.Fatalf("Expected no locks to be left, got %d", len(rootFS.locks))
+	}
+
+	if _, err := rootFS.Lock("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist, got: %v", err)
+	}
+}
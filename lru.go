@@ -0,0 +1,70 @@
+package memfs
+
+import (
+	"sort"
+	"time"
+)
+
+// lastUse returns when f was last read or written. The caller must hold rootFS.mu.
+func lastUse(f *File) time.Time {
+	if f.AccessTime.After(f.ModTime) {
+		return f.AccessTime
+	}
+	return f.ModTime
+}
+
+// makeRoom evicts the least recently used files until n more bytes fit
+// within the storage limit, or no file is left that can be evicted. Files
+// that are being written are kept. makeRoom does nothing unless
+// WithEvictionLRU is set; the caller still has to check the limit.
+func (rootFS *FS) makeRoom(n int64) {
+	if !rootFS.lru || rootFS.fits(n) {
+		return
+	}
+
+	// Content larger than the limit does not fit into an empty filesystem either
+	rootFS.mu.Lock()
+	tooLarge := n > rootFS.maxStorage
+	rootFS.mu.Unlock()
+	if tooLarge {
+		return
+	}
+
+	type candidate struct {
+		path    string
+		file    *File
+		lastUse time.Time
+	}
+	var candidates []candidate
+
+	rootFS.walk(func(path string, child childI) error {
+		f, ok := child.(*File)
+		if !ok {
+			return nil
+		}
+		rootFS.mu.Lock()
+		if f.writers == 0 {
+			candidates = append(candidates, candidate{path: path, file: f, lastUse: lastUse(f)})
+		}
+		rootFS.mu.Unlock()
+		return nil
+	})
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].lastUse.Before(candidates[j].lastUse)
+	})
+
+	for _, c := range candidates {
+		if rootFS.fits(n) {
+			return
+		}
+		rootFS.evict(c.path, c.file)
+	}
+}
+
+// fits reports whether n more bytes can be stored within the storage limit
+func (rootFS *FS) fits(n int64) bool {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	return rootFS.maxStorage <= 0 || rootFS.usedStorage+n <= rootFS.maxStorage
+}
@@ -0,0 +1,147 @@
+package memfs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestEvictionLRU(t *testing.T) {
+	var evicted []string
+	rootFS := New(WithMaxStorage(10), WithEvictionLRU(), WithOnEvict(func(path string) {
+		evicted = append(evicted, path)
+	}))
+
+	events, stop, err := rootFS.Watch("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	for _, name := range []string{"a", "b"} {
+		if err := rootFS.WriteFile(name, []byte("12345"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reading a makes b the least recently used file
+	if _, err := rootFS.ReadFile("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rootFS.WriteFile("c", []byte("12345"), 0644); err != nil {
+		t.Fatalf("Expected the write to evict a file, got: %v", err)
+	}
+
+	if rootFS.Exists("b") {
+		t.Fatal("Expected b to be evicted")
+	}
+	for _, name := range []string{"a", "c"} {
+		if !rootFS.Exists(name) {
+			t.Fatalf("Expected %s to be kept", name)
+		}
+	}
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Fatalf("Expected b to be reported as evicted, got %v", evicted)
+	}
+	if got := rootFS.UsedStorage(); got != 10 {
+		t.Fatalf("Expected used storage 10, got %d", got)
+	}
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Op == OpRemove && e.Path == "b" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Expected a remove event for the evicted file")
+		}
+	}
+}
+
+func TestEvictionLRUOpenUpdatesAccess(t *testing.T) {
+	rootFS := New(WithMaxStorage(10), WithEvictionLRU())
+
+	for _, name := range []string{"a", "b"} {
+		if err := rootFS.WriteFile(name, []byte("12345"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := rootFS.Open("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	fw, err := rootFS.Create("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("123")); err != nil {
+		t.Fatalf("Expected the write to evict a file, got: %v", err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if rootFS.Exists("b") || !rootFS.Exists("a") {
+		t.Fatal("Expected b to be evicted instead of a")
+	}
+}
+
+func TestEvictionLRUTooLarge(t *testing.T) {
+	rootFS := New(WithMaxStorage(10), WithEvictionLRU())
+
+	if err := rootFS.WriteFile("a", []byte("12345"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := rootFS.WriteFile("big", []byte("12345678901"), 0644)
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected the storage limit to be exceeded, got: %v", err)
+	}
+	if rootFS.Exists("big") {
+		t.Fatal("Expected big not to be written")
+	}
+	if !rootFS.Exists("a") {
+		t.Fatal("Expected a not to be evicted for content that can never fit")
+	}
+}
+
+func TestEvictionLRUCopyFile(t *testing.T) {
+	rootFS := New(WithMaxStorage(10), WithEvictionLRU())
+
+	for _, name := range []string{"a", "b"} {
+		if err := rootFS.WriteFile(name, []byte("12345"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := rootFS.ReadFile("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rootFS.CopyFile("a", "c"); err != nil {
+		t.Fatalf("Expected the copy to evict a file, got: %v", err)
+	}
+	if rootFS.Exists("b") || !rootFS.Exists("a") || !rootFS.Exists("c") {
+		t.Fatal("Expected b to be evicted for the copy")
+	}
+	if got := rootFS.UsedStorage(); got != 10 {
+		t.Fatalf("Expected used storage 10, got %d", got)
+	}
+
+	// The file limit still applies to copies
+	limited := New(WithMaxFiles(2))
+	for _, name := range []string{"a", "b"} {
+		if err := limited.WriteFile(name, []byte("12345"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := limited.CopyFile("a", "c"); !errors.Is(err, ErrTooManyFiles) {
+		t.Fatalf("Expected ErrTooManyFiles, got: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package memfs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"runtime"
+	"sync"
+)
+
+// Checksum streams the content of the file at path through h, which is reset
+// first, and returns the digest. Encrypted files are hashed by their
+// plaintext, and symbolic links are followed. Directories fail with an error
+// wrapping fs.ErrInvalid, and missing files with one wrapping fs.ErrNotExist.
+func (rootFS *FS) Checksum(path string, h hash.Hash) ([]byte, error) {
+	f, err := rootFS.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("path is a directory: %s: %w", path, fs.ErrInvalid)
+	}
+
+	h.Reset()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// SHA256 returns the SHA-256 digest of the content of the file at path, like
+// the digests of Manifest. See Checksum.
+func (rootFS *FS) SHA256(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	digest, err := rootFS.Checksum(path, sha256.New())
+	if err != nil {
+		return sum, err
+	}
+	copy(sum[:], digest)
+	return sum, nil
+}
+
+// Manifest returns the SHA-256 digest of the content of every file, keyed by
+// path. Encrypted files are hashed by their plaintext. Files are decrypted
+// and hashed in parallel by up to GOMAXPROCS workers.
+func (rootFS *FS) Manifest() (map[string][sha256.Size]byte, error) {
+	return rootFS.manifest(0)
+}
+
+// manifest builds the manifest with the given number of workers,
+// or GOMAXPROCS workers if workers is not positive
+func (rootFS *FS) manifest(workers int) (map[string][sha256.Size]byte, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	type job struct {
+		path string
+		file *File
+	}
+
+	var jobs []job
+	err := rootFS.walk(func(path string, child childI) error {
+		if f, ok := child.(*File); ok && !rootFS.expired(f) {
+			jobs = append(jobs, job{path: path, file: f})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	sums := make(map[string][sha256.Size]byte, len(jobs))
+	queue := make(chan job)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				content, err := rootFS.readContent(j.file)
+				var sum [sha256.Size]byte
+				if err == nil {
+					sum = sha256.Sum256(content)
+				}
+
+				mu.Lock()
+				switch {
+				case err == nil:
+					sums[j.path] = sum
+				case firstErr == nil:
+					firstErr = fmt.Errorf("reading %s: %w", j.path, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return sums, nil
+}
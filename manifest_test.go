@@ -0,0 +1,135 @@
+package memfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newManifestTestFS(tb testing.TB, files int) *FS {
+	tb.Helper()
+
+	rootFS := New(WithEncryption([]byte("manifest-key")))
+	for i := 0; i < files; i++ {
+		dir := fmt.Sprintf("dir%d", i%10)
+		if err := rootFS.MkdirAll(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		content := make([]byte, 4096)
+		for j := range content {
+			content[j] = byte(i + j)
+		}
+		if err := rootFS.WriteFile(fmt.Sprintf("%s/file%d.bin", dir, i), content, 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return rootFS
+}
+
+// TestManifest tests that the parallel manifest matches the serial one
+func TestManifest(t *testing.T) {
+	rootFS := newManifestTestFS(t, 100)
+
+	manifest, err := rootFS.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 100 {
+		t.Fatalf("Expected 100 entries, got %d", len(manifest))
+	}
+
+	content := make([]byte, 4096)
+	for j := range content {
+		content[j] = byte(7 + j)
+	}
+	if manifest["dir7/file7.bin"] != sha256.Sum256(content) {
+		t.Fatal("Expected the digest of the plaintext of dir7/file7.bin")
+	}
+
+	serial, err := rootFS.manifest(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{2, 8, 64} {
+		parallel, err := rootFS.manifest(workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(serial, parallel); diff != "" {
+			t.Fatalf("manifest with %d workers mismatch %s", workers, diff)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	rootFS := newManifestTestFS(t, 10)
+	if err := rootFS.Symlink("dir3/file3.bin", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := rootFS.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"dir3/file3.bin", "link"} {
+		sum, err := rootFS.SHA256(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != manifest["dir3/file3.bin"] {
+			t.Fatalf("Expected the digest of the plaintext for %s", path)
+		}
+	}
+
+	// The hash is reset, so it can be reused
+	h := crc32.NewIEEE()
+	content, err := rootFS.ReadFile("dir5/file5.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := crc32.NewIEEE()
+	want.Write(content)
+	for i := 0; i < 2; i++ {
+		got, err := rootFS.Checksum("dir5/file5.bin", h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want.Sum(nil)) {
+			t.Fatalf("Expected CRC-32 %x, got %x", want.Sum(nil), got)
+		}
+	}
+
+	if _, err := rootFS.Checksum("dir5", sha256.New()); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expected fs.ErrInvalid for a directory, got: %v", err)
+	}
+	if _, err := rootFS.SHA256("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got: %v", err)
+	}
+}
+
+func benchmarkManifest(b *testing.B, workers int) {
+	rootFS := newManifestTestFS(b, 1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := rootFS.manifest(workers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkManifestSerial measures building a manifest with a single worker
+func BenchmarkManifestSerial(b *testing.B) {
+	benchmarkManifest(b, 1)
+}
+
+// BenchmarkManifestParallel measures building a manifest with GOMAXPROCS workers
+func BenchmarkManifestParallel(b *testing.B) {
+	benchmarkManifest(b, 0)
+}
@@ -1,8 +1,10 @@
 package memfs
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/gob"
 	"errors"
 	"fmt"
@@ -12,18 +14,68 @@ import (
 	syspath "path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // FS is an in-memory filesystem that implements
 // io/fs.FS
 type FS struct {
-	dir         *Dir
-	openHook    func(path string, existingContent []byte, origErr error) ([]byte, error)
+	*storage // storage tracking, shared with Sub filesystems
+
+	dir            *Dir
+	openHook       func(path string, existingContent []byte, origErr error) ([]byte, error)
+	writeHook      func(path string, data []byte) ([]byte, error)
+	maxFileSize    int64      // maximum plaintext size of a single file, 0 means unlimited
+	lru            bool       // evict least recently used files when full, see WithEvictionLRU
+	noAtime        bool       // do not record access times, see WithNoAtime
+	maxSymlinkHops int        // symbolic links followed per path, 0 means the default
+	encryptor      *encryptor // encryptor for data at rest encryption
+	cipher         string     // name of the cipher of encryptor, see WithEncryptionCipher
+	kdf            *KDFParams // derivation of the key from a passphrase, if any
+
+	encryptedPaths bool // encrypt the names of entries when saving
+	sealedPaths    bool // the names of the loaded tree are still encrypted
+
+	perFileKeys   bool        // derive a key for every file, see WithPerFileKeys
+	boundPaths    bool        // authenticate the path of every file with its content
+	macKey        []byte      // key of the HMAC of every file, see WithHMAC
+	macRequired   bool        // the loaded content ends with an HMAC, but macKey is not set
+	compress      bool        // gzip compress the content of every file, see WithCompression
+	compressLevel int         // gzip level of compress
+	dedup         *dedupStore // content shared by files, see WithDeduplication
+	chunked       bool        // encrypt content in frames, see WithChunkedEncryption
+	keyPrefix     string      // path of the root of a Sub filesystem, for per-file keys
+	readOnly      bool        // reject all mutating operations
+	encLocked     bool        // reject changes to the encryption key
+
+	versions  int               // number of previous versions kept per file
+	ttl       time.Duration     // lifetime of written files, 0 means forever
+	onEvict   func(path string) // called after an expired or least recently used file is removed
+	stopSweep chan struct{}     // closed by Close to stop the expiry sweeper
+	closeOnce sync.Once         // guards closing stopSweep
+
+	lower     fs.FS               // read-only layer below an overlay, see WithOverlay
+	whiteouts map[string]struct{} // paths removed from the lower layer
+
+	autoMkdir     bool        // create missing parent directories of new files
+	autoMkdirPerm os.FileMode // permissions of directories created by autoMkdir
+
+	locks    map[string]*fileLock          // advisory locks by resolved path, see Lock
+	watchers atomic.Pointer[watchRegistry] // watchers shared with Sub filesystems, see Watch
+}
+
+// storage is the storage tracking of a filesystem. The filesystems returned
+// by Sub store their files in the tree of their parent, so they share its
+// storage: the limits apply to the whole tree, and the fields of its files
+// are guarded by the same lock.
+type storage struct {
+	mu          sync.Mutex // mutex for storage tracking
 	maxStorage  int64      // maximum storage limit in bytes
+	maxFiles    int        // maximum number of files, 0 means unlimited
+	fileCount   int        // current number of files, guarded by mu
 	usedStorage int64      // current storage usage in bytes
-	mu          sync.Mutex // mutex for storage tracking
-	encryptor   *encryptor // encryptor for data at rest encryption
+	moveMu      sync.Mutex // held by moves that lock two directories, see lockDirs
 }
 
 // New creates a new in-memory FileSystem. It accepts options to customize the filesystem. The options are: openHook, maxStorage, and encryption.
@@ -35,22 +87,63 @@ func New(opts ...Option) *FS {
 	}
 
 	// Initialize encryptor if encryption key is provided
-	enc, err := newEncryptor(fsOpt.encryptionKey)
+	enc, err := newEncryptor(fsOpt.encryptionKey, fsOpt.cipher, fsOpt.perFileKeys)
 	if err != nil {
 		// If encryptor initialization fails, create a disabled encryptor
 		enc = &encryptor{enable: false}
 	}
+	enc.bindPath = true
+	enc.chunked = fsOpt.chunked
+	enc.setMAC(fsOpt.hmacKey)
+	enc.setCompression(fsOpt.compress, fsOpt.compressLevel)
 
 	fs := FS{
+		storage: &storage{
+			maxStorage: -1, // -1 means unlimited
+		},
 		dir: &Dir{
 			Children: make(map[string]childI),
+			sorted:   fsOpt.sortedDirs,
 		},
-		maxStorage: -1, // -1 means unlimited
-		encryptor:  enc,
+		encryptor: enc,
+		cipher:    fsOpt.cipher,
+		kdf:       fsOpt.kdf,
+
+		encryptedPaths: fsOpt.encryptedPaths,
+		perFileKeys:    fsOpt.perFileKeys,
+		boundPaths:     true,
+		macKey:         enc.macKey,
+		compress:       fsOpt.compress,
+		compressLevel:  fsOpt.compressLevel,
+		chunked:        fsOpt.chunked,
+	}
+	if fsOpt.dedup {
+		fs.dedup = newDedupStore()
 	}
 
 	fs.openHook = fsOpt.openHook
+	fs.writeHook = fsOpt.writeHook
 	fs.maxStorage = fsOpt.maxStorage
+	fs.maxFileSize = fsOpt.maxFileSize
+	fs.maxFiles = fsOpt.maxFiles
+	fs.lru = fsOpt.lru
+	fs.noAtime = fsOpt.noAtime
+	fs.maxSymlinkHops = fsOpt.maxSymlinkHops
+	fs.readOnly = fsOpt.readOnly
+	fs.versions = fsOpt.versions
+	fs.ttl = fsOpt.ttl
+	fs.onEvict = fsOpt.onEvict
+	fs.lower = fsOpt.lower
+	fs.autoMkdir = fsOpt.autoMkdir
+	fs.autoMkdirPerm = fsOpt.autoMkdirPerm
+	if fs.autoMkdirPerm == 0 {
+		fs.autoMkdirPerm = 0755
+	}
+
+	if fs.ttl > 0 {
+		fs.stopSweep = make(chan struct{})
+		go fs.sweepExpired()
+	}
 
 	return &fs
 }
@@ -58,15 +151,122 @@ func New(opts ...Option) *FS {
 // SetEncryptionKey sets or updates the encryption key for the filesystem.
 // This is useful when loading an encrypted filesystem from disk - you need to
 // provide the same key that was used when the data was encrypted.
+//
+// If the encryption configuration has been locked with LockEncryption,
+// SetEncryptionKey returns an error wrapping fs.ErrPermission.
 func (rootFS *FS) SetEncryptionKey(key []byte) error {
-	enc, err := newEncryptor(key)
+	if err := rootFS.checkEncryptionUnlocked(); err != nil {
+		return err
+	}
+
+	enc, err := rootFS.encryptorFor(key)
 	if err != nil {
 		return err
 	}
+
+	rootFS.mu.Lock()
+	sealed := rootFS.sealedPaths
+	rootFS.mu.Unlock()
+	if sealed {
+		if err := rootFS.openPaths(enc); err != nil {
+			return err
+		}
+	}
+
+	rootFS.mu.Lock()
 	rootFS.encryptor = enc
+	rootFS.mu.Unlock()
 	return nil
 }
 
+// LockEncryption freezes the encryption configuration of the filesystem.
+// Until UnlockEncryption is called, any attempt to change the encryption key
+// fails, which guards against accidentally making all files unreadable.
+func (rootFS *FS) LockEncryption() {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	rootFS.encLocked = true
+}
+
+// UnlockEncryption allows the encryption key to be changed again after LockEncryption.
+func (rootFS *FS) UnlockEncryption() {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	rootFS.encLocked = false
+}
+
+// checkEncryptionUnlocked returns an error if the encryption configuration is locked
+func (rootFS *FS) checkEncryptionUnlocked() error {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	if rootFS.encLocked {
+		return fmt.Errorf("encryption configuration is locked: %w", fs.ErrPermission)
+	}
+	return nil
+}
+
+// Freeze makes the filesystem read-only. After Freeze returns, every mutating
+// operation on rootFS, including writes through already open FileWriters,
+// fails with an error wrapping fs.ErrPermission.
+func (rootFS *FS) Freeze() {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	rootFS.readOnly = true
+}
+
+// SetReadOnly makes the filesystem read-only like Freeze, or writable again.
+// Filesystems returned by Sub before the call keep their previous mode.
+func (rootFS *FS) SetReadOnly(readOnly bool) {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	rootFS.readOnly = readOnly
+}
+
+// writable makes the filesystem writable while an archive or another
+// filesystem is imported into it, and returns a function that restores the
+// previous mode once the import is complete
+func (rootFS *FS) writable() (restore func()) {
+	rootFS.mu.Lock()
+	readOnly := rootFS.readOnly
+	rootFS.readOnly = false
+	rootFS.mu.Unlock()
+	return func() { rootFS.SetReadOnly(readOnly) }
+}
+
+// checkWritable returns an error if the filesystem is read-only
+func (rootFS *FS) checkWritable(path string) error {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	if rootFS.readOnly {
+		return fmt.Errorf("read-only filesystem: %s: %w", path, fs.ErrPermission)
+	}
+	return nil
+}
+
+// Mkdir creates a single directory named path with the permission bits perm,
+// like os.Mkdir. Unlike MkdirAll, it fails with an error wrapping
+// fs.ErrNotExist if the parent directory does not exist, and with one
+// wrapping fs.ErrExist if anything already exists at path.
+func (rootFS *FS) Mkdir(path string, perm os.FileMode) error {
+	if !fs.ValidPath(path) {
+		return fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
+	}
+
+	if path == "." {
+		return fmt.Errorf("file exists: %s: %w", path, fs.ErrExist)
+	}
+
+	dirPart, filePart := syspath.Split(path)
+	if dirPart == "" {
+		dirPart = "."
+	}
+	return rootFS.placeNew(strings.TrimSuffix(dirPart, "/"), filePart, &Dir{
+		Perm:     perm,
+		ModTime:  time.Now(),
+		Children: make(map[string]childI),
+	})
+}
+
 // MkdirAll creates a directory named path,
 // along with any necessary parents, and returns nil,
 // or else returns an error.
@@ -79,7 +279,16 @@ func (rootFS *FS) MkdirAll(path string, perm os.FileMode) error {
 		return fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
 	}
 
-	if path == "." {
+	if err := rootFS.checkWritable(path); err != nil {
+		return err
+	}
+
+	path, err := rootFS.resolve(path, true)
+	if err != nil {
+		return err
+	}
+
+	if path == "." || path == "" {
 		// root dir always exists
 		return nil
 	}
@@ -87,109 +296,183 @@ func (rootFS *FS) MkdirAll(path string, perm os.FileMode) error {
 	parts := strings.Split(path, "/")
 
 	next := rootFS.dir
-	for _, part := range parts {
-		cur := next
-		cur.mu.Lock()
-		child := cur.Children[part]
-		if child == nil {
-			newDir := &Dir{
-				Name:     part,
-				Perm:     perm,
-				Children: make(map[string]childI),
+	for i, part := range parts {
+		next, err = func(cur *Dir) (*Dir, error) {
+			cur.mu.Lock()
+			defer cur.mu.Unlock()
+
+			child := cur.Children[part]
+			if child == nil {
+				newDir := &Dir{
+					Name:     part,
+					Perm:     perm,
+					ModTime:  time.Now(),
+					Children: make(map[string]childI),
+					sorted:   cur.sorted,
+				}
+				cur.setChild(part, newDir)
+				cur.ModTime = newDir.ModTime
+				rootFS.notify(OpCreate, syspath.Join(rootFS.keyPrefix, strings.Join(parts[:i+1], "/")))
+				return newDir, nil
 			}
-			cur.Children[part] = newDir
-			next = newDir
-		} else {
 			childDir, ok := child.(*Dir)
 			if !ok {
-				return fmt.Errorf("not a directory: %s: %w", part, fs.ErrInvalid)
+				return nil, fmt.Errorf("not a directory: %s: %w", part, fs.ErrInvalid)
 			}
-			next = childDir
+			return childDir, nil
+		}(next)
+		if err != nil {
+			return err
 		}
-		cur.mu.Unlock()
 	}
 
 	return nil
 }
 
 func (rootFS *FS) getDir(path string) (*Dir, error) {
+	path, err := rootFS.resolve(path, true)
+	if err != nil {
+		return nil, err
+	}
+
 	if path == "" {
 		return rootFS.dir, nil
 	}
+
+	child, err := rootFS.descend(path)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := child.(*Dir)
+	if !ok {
+		return nil, fmt.Errorf("no such file or directory: %s: %w", syspath.Base(path), fs.ErrNotExist)
+	}
+	return dir, nil
+}
+
+// descend returns the child at the resolved, non-empty path. The directories
+// along the path are read locked hand over hand, parents before children, so
+// a directory cannot be removed or replaced between looking up its name and
+// entering it; every step sees the tree as it was when its parent was entered.
+func (rootFS *FS) descend(path string) (childI, error) {
 	parts := strings.Split(path, "/")
 
 	cur := rootFS.dir
-	for _, part := range parts {
-		err := func() error {
-			cur.mu.Lock()
-			defer cur.mu.Unlock()
-			child := cur.Children[part]
-			if child == nil {
-				return fmt.Errorf("not a directory: %s: %w", part, fs.ErrNotExist)
-			} else {
-				childDir, ok := child.(*Dir)
-				if !ok {
-					return fmt.Errorf("no such file or directory: %s: %w", part, fs.ErrNotExist)
-				}
-				cur = childDir
+	cur.mu.RLock()
+	for i, part := range parts {
+		child := cur.Children[part]
+		if child == nil {
+			cur.mu.RUnlock()
+			return nil, fmt.Errorf("not a directory: %s: %w", part, fs.ErrNotExist)
+		}
+
+		next, isDir := child.(*Dir)
+		if !isDir {
+			cur.mu.RUnlock()
+			if i == len(parts)-1 {
+				return child, nil
 			}
-			return nil
-		}()
-		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("no such file or directory: %s: %w", part, fs.ErrNotExist)
 		}
-	}
 
+		next.mu.RLock()
+		cur.mu.RUnlock()
+		cur = next
+	}
+	cur.mu.RUnlock()
 	return cur, nil
 }
 
+// get returns the child at path, following symbolic links
 func (rootFS *FS) get(path string) (childI, error) {
+	return rootFS.lookup(path, true)
+}
+
+// lget returns the child at path; a symbolic link in the final element is not followed
+func (rootFS *FS) lget(path string) (childI, error) {
+	return rootFS.lookup(path, false)
+}
+
+func (rootFS *FS) lookup(path string, followLast bool) (childI, error) {
+	if path == "." {
+		path = ""
+	}
+
+	path, err := rootFS.resolve(path, followLast)
+	if err != nil {
+		return nil, err
+	}
+
 	if path == "" {
 		return rootFS.dir, nil
 	}
 
-	parts := strings.Split(path, "/")
+	chld, err := rootFS.descend(path)
+	if err != nil {
+		return nil, err
+	}
 
-	var (
-		cur = rootFS.dir
+	// Expired files are removed lazily when they are looked up
+	if f, ok := chld.(*File); ok && rootFS.expired(f) {
+		rootFS.evict(path, f)
+		return nil, fmt.Errorf("file expired: %s: %w", path, fs.ErrNotExist)
+	}
 
-		chld childI
-		err  error
-	)
-	for i, part := range parts {
-		chld, err = func() (childI, error) {
-			cur.mu.Lock()
-			defer cur.mu.Unlock()
-			child := cur.Children[part]
-			if child == nil {
-				return nil, fmt.Errorf("not a directory: %s: %w", part, fs.ErrNotExist)
-			} else {
-				_, isFile := child.(*File)
-				if isFile {
-					if i == len(parts)-1 {
-						return child, nil
-					} else {
-						return nil, fmt.Errorf("no such file or directory: %s: %w", part, fs.ErrNotExist)
-					}
-				}
+	return chld, nil
+}
 
-				childDir, ok := child.(*Dir)
-				if !ok {
-					return nil, errors.New("not a directory")
-				}
-				cur = childDir
+func (rootFS *FS) create(path string) (*File, error) {
+	if rootFS.autoMkdir {
+		if dir := syspath.Dir(path); dir != "." {
+			if err := rootFS.MkdirAll(dir, rootFS.autoMkdirPerm); err != nil {
+				return nil, err
 			}
-			return child, nil
-		}()
-		if err != nil {
+		}
+	}
+
+	newFile := &File{
+		Perm: 0666,
+	}
+	old, err := rootFS.insert(path, newFile)
+	if err != nil {
+		return nil, err
+	}
+	if old != nil {
+		rootFS.releaseReplaced(old, newFile)
+	}
+	return newFile, nil
+}
+
+// createExclusive creates an empty file at path for OpenFile with O_EXCL,
+// failing with an error wrapping fs.ErrExist if anything, even a symbolic
+// link, is at path. Unlike with create, the check and the creation happen
+// under the lock of the parent directory, like with TempFile.
+func (rootFS *FS) createExclusive(path string) (*File, error) {
+	if path == "." {
+		return nil, fmt.Errorf("file exists: %s: %w", path, fs.ErrExist)
+	}
+	dir := syspath.Dir(path)
+	if rootFS.autoMkdir && dir != "." {
+		if err := rootFS.MkdirAll(dir, rootFS.autoMkdirPerm); err != nil {
 			return nil, err
 		}
 	}
 
-	return chld, nil
+	file := &File{
+		Perm:    0666,
+		Content: []byte{},
+		ModTime: time.Now(),
+	}
+	if err := rootFS.placeNew(dir, syspath.Base(path), file); err != nil {
+		return nil, err
+	}
+	return file, nil
 }
 
-func (rootFS *FS) create(path string) (*File, error) {
+// insert places newFile at path, replacing any existing file, and returns the
+// replaced file or nil. The Name of newFile is set from path. With versioning
+// enabled, the replaced file is kept in the History of newFile.
+func (rootFS *FS) insert(path string, newFile *File) (*File, error) {
 	if !fs.ValidPath(path) {
 		return nil, fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
 	}
@@ -199,9 +482,18 @@ func (rootFS *FS) create(path string) (*File, error) {
 		path = ""
 	}
 
+	// Writing to a symbolic link writes to its target
+	path, err := rootFS.resolve(path, true)
+	if err != nil {
+		return nil, err
+	}
+
 	dirPart, filePart := syspath.Split(path)
 
 	dirPart = strings.TrimSuffix(dirPart, "/")
+	if err := rootFS.copyUpDir(dirPart); err != nil {
+		return nil, err
+	}
 	dir, err := rootFS.getDir(dirPart)
 	if err != nil {
 		return nil, err
@@ -210,40 +502,143 @@ func (rootFS *FS) create(path string) (*File, error) {
 	dir.mu.Lock()
 	defer dir.mu.Unlock()
 	existing := dir.Children[filePart]
+	var old *File
 	if existing != nil {
-		_, ok := existing.(*File)
+		var ok bool
+		old, ok = existing.(*File)
 		if !ok {
 			return nil, fmt.Errorf("path is a directory: %s: %w", path, fs.ErrExist)
 		}
 	}
 
-	newFile := &File{
-		Name: filePart,
-		Perm: 0666,
+	if old == nil {
+		rootFS.mu.Lock()
+		err := rootFS.addFiles(path, 1)
+		rootFS.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
 	}
-	dir.Children[filePart] = newFile
 
-	return newFile, nil
+	newFile.Name = filePart
+	newFile.keyPath = syspath.Join(rootFS.keyPrefix, path)
+	if old != nil && rootFS.versions > 0 {
+		rootFS.mu.Lock()
+		newFile.History = old.pushVersion(rootFS.versions)
+		rootFS.mu.Unlock()
+	}
+	if rootFS.ttl > 0 {
+		newFile.expireAt = time.Now().Add(rootFS.ttl)
+	}
+	dir.setChild(filePart, newFile)
+	if old == nil {
+		dir.ModTime = time.Now()
+		rootFS.notify(OpCreate, newFile.keyPath)
+	}
+
+	return old, nil
+}
+
+// putFile stores content as the raw (already encrypted, if applicable) content
+// of the file at path, creating any missing parent directories with mode 0755.
+// It is used when importing archives, so no encryption or limits are applied.
+// size is the size of the plaintext, or 0 if it is unknown.
+func (rootFS *FS) putFile(path string, content []byte, size int64, perm os.FileMode, modTime time.Time) error {
+	if dirPart := syspath.Dir(path); dirPart != "." {
+		if err := rootFS.MkdirAll(dirPart, 0755); err != nil {
+			return err
+		}
+	}
+
+	f, err := rootFS.create(path)
+	if err != nil {
+		return err
+	}
+
+	rootFS.mu.Lock()
+	rootFS.usedStorage += int64(len(content))
+	f.Content = content
+	f.PlaintextSize = size
+	rootFS.intern(f)
+	f.Perm = perm
+	f.ModTime = modTime
+	rootFS.mu.Unlock()
+
+	rootFS.notify(OpWrite, f.keyPath)
+	return nil
+}
+
+// importFile stores a file read from an archive at path, creating missing
+// parent directories with mode 0755. If raw is set, content is stored as is
+// like with putFile, with size as the size of its plaintext; otherwise it is
+// written like with WriteFile.
+func (rootFS *FS) importFile(path string, content []byte, size int64, perm os.FileMode, modTime time.Time, raw bool) error {
+	if raw {
+		return rootFS.putFile(path, content, size, perm, modTime)
+	}
+
+	if dirPart := syspath.Dir(path); dirPart != "." {
+		if err := rootFS.MkdirAll(dirPart, 0755); err != nil {
+			return err
+		}
+	}
+	if err := rootFS.WriteFile(path, content, perm); err != nil {
+		return err
+	}
+	return rootFS.Chtimes(path, time.Time{}, modTime)
 }
 
 // WriteFile writes data to a file named by filename.
 // If the file does not exist, WriteFile creates it with permissions perm
 // (before umask); otherwise WriteFile truncates it before writing, without changing permissions.
 func (rootFS *FS) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return rootFS.writeFile(context.Background(), path, data, perm)
+}
+
+// writeFile implements WriteFile, giving up as soon as ctx is done
+func (rootFS *FS) writeFile(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if !fs.ValidPath(path) {
 		return fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
 	}
 
+	if err := rootFS.checkWritable(path); err != nil {
+		return err
+	}
+
+	if rootFS.writeHook != nil {
+		var err error
+		if data, err = rootFS.writeHook(path, data); err != nil {
+			return fmt.Errorf("write hook: %s: %w", path, err)
+		}
+	}
+
+	if err := rootFS.checkFileSize(path, int64(len(data))); err != nil {
+		return err
+	}
+
 	// Encrypt data before storing if encryption is enabled
+	rootFS.mu.Lock()
+	enc := rootFS.encryptor
+	rootFS.mu.Unlock()
+	keyPath := rootFS.keyPath(path)
 	encryptedData := data
-	if rootFS.encryptor != nil {
+	if enc != nil {
 		var err error
-		encryptedData, err = rootFS.encryptor.encrypt(data)
+		encryptedData, err = enc.encrypt(keyPath, data)
 		if err != nil {
 			return fmt.Errorf("encryption failed: %w", err)
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rootFS.makeRoom(int64(len(encryptedData)))
 	rootFS.mu.Lock()
 	if rootFS.maxStorage > 0 {
 		newSize := rootFS.usedStorage + int64(len(encryptedData))
@@ -264,21 +659,153 @@ func (rootFS *FS) WriteFile(path string, data []byte, perm os.FileMode) error {
 		return err
 	}
 
+	// The size of the replaced file has been released by create. The content
+	// is set under the same lock, as the expiry sweeper may already see the file.
+	rootFS.mu.Lock()
+	if rootFS.encryptor != enc || f.keyPath != keyPath {
+		// The key has been rotated, or the path been redirected by a
+		// symbolic link, since the data was encrypted
+		var err error
+		if encryptedData, err = rootFS.encryptor.encrypt(f.keyPath, data); err != nil {
+			rootFS.mu.Unlock()
+			return fmt.Errorf("encryption failed: %w", err)
+		}
+	}
+	rootFS.usedStorage += int64(len(encryptedData))
+	f.Content = encryptedData
+	f.PlaintextSize = int64(len(data))
+	rootFS.intern(f)
+	f.Perm = perm
+	f.ModTime = time.Now()
+	keyPath = f.keyPath
+	rootFS.mu.Unlock()
+
+	rootFS.notify(OpWrite, keyPath)
+	return nil
+}
+
+// WriteReader writes everything read from r to the file at path like
+// WriteFile, streaming it into the file instead of requiring the content in
+// a single slice first. With encryption, the plaintext is still held in
+// memory until it is encrypted at the end. If reading from r or writing
+// fails, the partly written file is removed and the error is returned.
+func (rootFS *FS) WriteReader(path string, r io.Reader, perm os.FileMode) error {
+	fw, err := rootFS.Create(path)
+	if err != nil {
+		return err
+	}
+
+	rootFS.mu.Lock()
+	fw.file.Perm = perm
+	rootFS.mu.Unlock()
+
+	if _, err := io.Copy(fw, r); err != nil {
+		fw.Close()
+		rootFS.Remove(path)
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		rootFS.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// WriteFileAtomic writes data to the file at path like WriteFile, but
+// readers never observe a partly written file. WriteFile empties an existing
+// file before storing the new content; WriteFileAtomic prepares a complete
+// new file outside of the tree and swaps it in under the lock of the parent
+// directory, so a concurrent reader sees either the old or the new content.
+// The new file gets the permissions perm.
+func (rootFS *FS) WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if !fs.ValidPath(path) || path == "." {
+		return fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
+	}
+
+	if err := rootFS.checkWritable(path); err != nil {
+		return err
+	}
+
+	if rootFS.writeHook != nil {
+		var err error
+		if data, err = rootFS.writeHook(path, data); err != nil {
+			return fmt.Errorf("write hook: %s: %w", path, err)
+		}
+	}
+
+	if err := rootFS.checkFileSize(path, int64(len(data))); err != nil {
+		return err
+	}
+
+	// The content is encrypted for the final path, as the file is not
+	// moved once it is in the tree
+	resolved, err := rootFS.resolve(path, true)
+	if err != nil {
+		return err
+	}
+	rootFS.mu.Lock()
+	enc := rootFS.encryptor
+	rootFS.mu.Unlock()
+	content := data
+	if enc != nil {
+		if content, err = enc.encrypt(rootFS.keyPath(resolved), data); err != nil {
+			return fmt.Errorf("encryption failed: %w", err)
+		}
+	}
+
+	rootFS.makeRoom(int64(len(content)))
 	rootFS.mu.Lock()
 	if rootFS.maxStorage > 0 {
-		// Subtract old file size and add new file size (using encrypted size)
-		rootFS.usedStorage -= int64(len(f.Content))
-		rootFS.usedStorage += int64(len(encryptedData))
+		newSize := rootFS.usedStorage + int64(len(content))
+		if newSize > rootFS.maxStorage {
+			rootFS.mu.Unlock()
+			return fmt.Errorf("storage limit exceeded: %w", fs.ErrInvalid)
+		}
 	}
 	rootFS.mu.Unlock()
 
-	f.Content = encryptedData
-	f.Perm = perm
+	if rootFS.autoMkdir {
+		if dir := syspath.Dir(resolved); dir != "." {
+			if err := rootFS.MkdirAll(dir, rootFS.autoMkdirPerm); err != nil {
+				return err
+			}
+		}
+	}
+
+	newFile := &File{
+		Perm:          perm,
+		Content:       content,
+		PlaintextSize: int64(len(data)),
+		ModTime:       time.Now(),
+	}
+	old, err := rootFS.insert(resolved, newFile)
+	if err != nil {
+		return err
+	}
+
+	rootFS.mu.Lock()
+	rootFS.usedStorage += int64(len(content))
+	rootFS.intern(newFile)
+	rootFS.mu.Unlock()
+
+	if old != nil {
+		rootFS.releaseReplaced(old, newFile)
+	}
+	rootFS.notify(OpWrite, newFile.keyPath)
 	return nil
 }
 
 // Open opens the named file.
 func (rootFS *FS) Open(name string) (fs.File, error) {
+	return rootFS.openCtx(context.Background(), name)
+}
+
+// openCtx implements Open, giving up as soon as ctx is done
+func (rootFS *FS) openCtx(ctx context.Context, name string) (fs.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	if !fs.ValidPath(name) {
 		return nil, &fs.PathError{
 			Op:   "open",
@@ -287,7 +814,7 @@ func (rootFS *FS) Open(name string) (fs.File, error) {
 		}
 	}
 
-	child, err := rootFS.open(name)
+	child, err := rootFS.open(ctx, name)
 	if rootFS.openHook != nil {
 		var exitingContent []byte
 		if child != nil {
@@ -305,63 +832,373 @@ func (rootFS *FS) Open(name string) (fs.File, error) {
 			if err != nil {
 				return nil, err
 			}
-			f := child.(*File)
-			f.Content = newContent
-			f.reader = bytes.NewReader(newContent)
-			return f, nil
+			// Serve the hook's content from a fresh handle, the stored file keeps its content
+			return child.(*File).handle(newContent), nil
+		}
+	}
+	return child, err
+}
+
+func (rootFS *FS) open(ctx context.Context, name string) (fs.File, error) {
+	if name == "." {
+		// root dir
+		name = ""
+	}
+
+	child, err := rootFS.get(name)
+	if err != nil {
+		if rootFS.lower != nil && errors.Is(err, fs.ErrNotExist) {
+			if name == "" {
+				name = "."
+			}
+			return rootFS.openLower(name)
+		}
+		return nil, err
+	}
+
+	switch cc := child.(type) {
+	case *File:
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Content encrypted in frames is decrypted while it is read
+		stream, err := rootFS.openStream(cc)
+		if err != nil {
+			return nil, err
+		}
+		if stream != nil {
+			h := rootFS.openHandle(cc, nil)
+			h.reader = stream
+			return h, nil
+		}
+
+		// Decrypt content if encryption is enabled
+		content, err := rootFS.readContent(cc)
+		if err != nil {
+			return nil, err
+		}
+
+		return rootFS.openHandle(cc, content), nil
+	case *Dir:
+		if rootFS.lower != nil {
+			if name == "" {
+				name = "."
+			}
+			return &overlayDir{fs: rootFS, name: name, upper: cc}, nil
+		}
+		handle := &fhDir{
+			dir: cc,
+			fs:  rootFS,
+		}
+		return handle, nil
+	}
+
+	return nil, fmt.Errorf("unexpected file type in fs: %s: %w", name, fs.ErrInvalid)
+}
+
+// ReadFile reads the named file and returns its decrypted content.
+// It implements fs.ReadFileFS, so fs.ReadFile does not need to open a handle.
+// The returned slice is a copy that the caller may modify.
+func (rootFS *FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{
+			Op:   "readfile",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+
+	// The open hook and the lower layer of an overlay are applied by Open
+	if rootFS.openHook != nil || rootFS.lower != nil {
+		f, err := rootFS.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+
+	file, err := rootFS.getFile(name)
+	if err != nil {
+		return nil, &fs.PathError{
+			Op:   "readfile",
+			Path: name,
+			Err:  err,
 		}
 	}
-	return child, err
+	rootFS.touch(file)
+
+	content, err := rootFS.readContent(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unencrypted content is the stored slice itself
+	out := make([]byte, len(content))
+	copy(out, content)
+	return out, nil
+}
+
+// openHandle returns a new handle for reading content with the metadata of
+// the stored file f, recording the access like touch
+func (rootFS *FS) openHandle(f *File, content []byte) *File {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	if rootFS.lru || !rootFS.noAtime {
+		f.AccessTime = time.Now()
+	}
+	return f.handle(content)
+}
+
+// touch records that f has been read in its AccessTime, unless access times
+// are disabled with WithNoAtime
+func (rootFS *FS) touch(f *File) {
+	if rootFS.noAtime && !rootFS.lru {
+		return
+	}
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	f.AccessTime = time.Now()
+}
+
+// handle returns a new handle for reading content with the metadata of f
+func (f *File) handle(content []byte) *File {
+	return &File{
+		Name:       f.Name,
+		Perm:       f.Perm,
+		Content:    content,
+		reader:     bytes.NewReader(content),
+		ModTime:    f.ModTime,
+		AccessTime: f.AccessTime,
+		Uid:        f.Uid,
+		Gid:        f.Gid,
+	}
+}
+
+// Stat returns a FileInfo describing the named file, following symbolic links.
+// It implements fs.StatFS. Unlike opening the file, Stat does not decrypt the
+// content; the reported size is the size of the plaintext.
+func (rootFS *FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{
+			Op:   "stat",
+			Path: name,
+			Err:  fs.ErrInvalid,
+		}
+	}
+
+	// The open hook may change the content, so the file has to be opened
+	if rootFS.openHook != nil {
+		f, err := rootFS.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return f.Stat()
+	}
+
+	child, err := rootFS.get(name)
+	if err != nil {
+		if rootFS.lower != nil && errors.Is(err, fs.ErrNotExist) {
+			return rootFS.lowerStat(name)
+		}
+		return nil, &fs.PathError{
+			Op:   "stat",
+			Path: name,
+			Err:  err,
+		}
+	}
+
+	switch c := child.(type) {
+	case *File:
+		return rootFS.fileStat(c), nil
+	case *Dir:
+		return (&fhDir{dir: c}).Stat()
+	}
+
+	return nil, fmt.Errorf("unexpected file type in fs: %s: %w", name, fs.ErrInvalid)
+}
+
+// Exists reports whether a file or directory exists at path. Symbolic links
+// are followed, so a dangling link does not exist. The content of the file
+// is neither read nor decrypted.
+func (rootFS *FS) Exists(path string) bool {
+	if !fs.ValidPath(path) {
+		return false
+	}
+	if _, err := rootFS.get(path); err != nil {
+		if rootFS.lower != nil && errors.Is(err, fs.ErrNotExist) {
+			_, err = rootFS.lowerStat(path)
+			return err == nil
+		}
+		return false
+	}
+	return true
+}
+
+// IsDir reports whether path is a directory, following symbolic links. An
+// error wrapping fs.ErrNotExist is returned if nothing exists at path.
+func (rootFS *FS) IsDir(path string) (bool, error) {
+	if !fs.ValidPath(path) {
+		return false, fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
+	}
+
+	child, err := rootFS.get(path)
+	if err != nil {
+		if rootFS.lower != nil && errors.Is(err, fs.ErrNotExist) {
+			info, err := rootFS.lowerStat(path)
+			if err != nil {
+				return false, err
+			}
+			return info.IsDir(), nil
+		}
+		return false, err
+	}
+	_, ok := child.(*Dir)
+	return ok, nil
+}
+
+// IsFile reports whether path is a regular file, following symbolic links.
+// An error wrapping fs.ErrNotExist is returned if nothing exists at path.
+func (rootFS *FS) IsFile(path string) (bool, error) {
+	if !fs.ValidPath(path) {
+		return false, fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
+	}
+
+	child, err := rootFS.get(path)
+	if err != nil {
+		if rootFS.lower != nil && errors.Is(err, fs.ErrNotExist) {
+			info, err := rootFS.lowerStat(path)
+			if err != nil {
+				return false, err
+			}
+			return info.Mode().IsRegular(), nil
+		}
+		return false, err
+	}
+	_, ok := child.(*File)
+	return ok, nil
+}
+
+// fileStat returns the FileInfo of a stored file with its plaintext size
+func (rootFS *FS) fileStat(f *File) fs.FileInfo {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+
+	return &fileInfo{
+		name:    f.Name,
+		size:    rootFS.plainSize(f),
+		modTime: f.ModTime,
+		atime:   f.AccessTime,
+		mode:    f.Perm,
+		sys:     &Owner{Uid: f.Uid, Gid: f.Gid},
+	}
 }
 
-func (rootFS *FS) open(name string) (fs.File, error) {
-	if name == "." {
-		// root dir
-		name = ""
+// NewSectionReader returns an io.SectionReader that reads the n bytes of the
+// named file starting at offset off, e.g. for serving HTTP range requests.
+// The reader works on the decrypted content: for an encrypted file the whole
+// plaintext is decrypted into memory, regardless of the size of the section.
+func (rootFS *FS) NewSectionReader(path string, off, n int64) (*io.SectionReader, error) {
+	if off < 0 || n < 0 {
+		return nil, fmt.Errorf("invalid section: offset %d, length %d: %w", off, n, fs.ErrInvalid)
 	}
 
-	child, err := rootFS.get(name)
+	file, err := rootFS.getFile(path)
 	if err != nil {
 		return nil, err
 	}
+	rootFS.touch(file)
 
-	switch cc := child.(type) {
-	case *File:
-		// Decrypt content if encryption is enabled
-		content := cc.Content
-		if rootFS.encryptor != nil && rootFS.encryptor.enable {
-			decryptedContent, err := rootFS.encryptor.decrypt(cc.Content)
-			if err != nil {
-				return nil, fmt.Errorf("decryption failed: %w", err)
-			}
-			content = decryptedContent
-		}
+	content, err := rootFS.readContent(file)
+	if err != nil {
+		return nil, err
+	}
 
-		handle := &File{
-			Name:    cc.Name,
-			Perm:    cc.Perm,
-			Content: content,
-			reader:  bytes.NewReader(content),
-			ModTime: cc.ModTime,
-		}
-		return handle, nil
-	case *Dir:
-		handle := &fhDir{
-			dir: cc,
-		}
-		return handle, nil
+	return io.NewSectionReader(bytes.NewReader(content), off, n), nil
+}
+
+// OpenAt opens the named file for reading like Open, with the read offset
+// already at offset. The offset must lie within the file; an offset equal
+// to the file size yields a handle that is at EOF.
+func (rootFS *FS) OpenAt(path string, offset int64) (fs.File, error) {
+	f, err := rootFS.Open(path)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("unexpected file type in fs: %s: %w", name, fs.ErrInvalid)
+	file, ok := f.(*File)
+	if !ok {
+		f.Close()
+		return nil, fmt.Errorf("path is a directory: %s: %w", path, fs.ErrInvalid)
+	}
+
+	// Handles of chunked content read frames on demand and hold no Content
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if offset < 0 || offset > info.Size() {
+		file.Close()
+		return nil, fmt.Errorf("offset %d out of range for %s: %w", offset, path, fs.ErrInvalid)
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
 }
 
 // Sub returns an FS corresponding to the subtree rooted at path.
+// The returned FS shares the storage of rootFS: files written through it
+// count against the storage and file limits of rootFS, and UsedStorage
+// reports the usage of the whole filesystem. It does not evict files with
+// WithEvictionLRU, so writes fail once the storage limit is reached. The
+// open and write hooks, versioning, TTL and WithAutoMkdir of rootFS apply
+// to it as well; the hooks receive paths relative to the returned FS, and
+// expired files are removed by rootFS.
 func (rootFS *FS) Sub(path string) (fs.FS, error) {
+	// Paths of an overlay are resolved by the whole overlay
+	if rootFS.lower != nil {
+		return fs.Sub(struct{ fs.FS }{rootFS}, path)
+	}
+
 	dir, err := rootFS.getDir(path)
 	if err != nil {
 		return nil, err
 	}
-	return &FS{dir: dir}, nil
+	// The sub tree holds content encrypted with the key of rootFS
+	sub := &FS{
+		storage:        rootFS.storage,
+		dir:            dir,
+		readOnly:       rootFS.readOnly,
+		encryptor:      rootFS.encryptor,
+		maxFileSize:    rootFS.maxFileSize,
+		maxSymlinkHops: rootFS.maxSymlinkHops,
+		noAtime:        rootFS.noAtime,
+		cipher:         rootFS.cipher,
+		kdf:            rootFS.kdf,
+		perFileKeys:    rootFS.perFileKeys,
+		boundPaths:     rootFS.boundPaths,
+		macKey:         rootFS.macKey,
+		macRequired:    rootFS.macRequired,
+		compress:       rootFS.compress,
+		compressLevel:  rootFS.compressLevel,
+		dedup:          rootFS.dedup,
+		chunked:        rootFS.chunked,
+		keyPrefix:      rootFS.keyPath(path),
+		openHook:       rootFS.openHook,
+		writeHook:      rootFS.writeHook,
+		versions:       rootFS.versions,
+		ttl:            rootFS.ttl,
+		autoMkdir:      rootFS.autoMkdir,
+		autoMkdirPerm:  rootFS.autoMkdirPerm,
+	}
+	sub.watchers.Store(rootFS.watchRegistry())
+	return sub, nil
 }
 
 // SaveToFile saves the entire filesystem structure to a GOB encoded file
@@ -377,8 +1214,156 @@ func (rootFS *FS) SaveToFile(filename string) error {
 
 // SaveTo saves the filesystem structure to any io.Writer in GOB format
 func (rootFS *FS) SaveTo(w io.Writer) error {
+	return rootFS.encode(w)
+}
+
+// snapshotVersion is the current version of the serialized snapshot format
+const snapshotVersion = 1
+
+// snapshot is the envelope that is GOB encoded when saving a filesystem.
+// Streams written before the envelope was introduced contain a bare Dir.
+type snapshot struct {
+	Version    int
+	MaxStorage int64
+	Cipher     string // not set for AES-GCM and snapshots of older versions
+	Root       *Dir
+
+	// EncryptedPaths is set if the names in Root are encrypted, see WithEncryptedPaths
+	EncryptedPaths bool
+
+	// PerFileKeys is set if every file has its own key, see WithPerFileKeys
+	PerFileKeys bool
+
+	// BoundPaths is set if the path of every file is authenticated with its
+	// encrypted content; it is not set for snapshots of older versions
+	BoundPaths bool
+
+	// HMAC is set if the content of every file ends with an HMAC, see WithHMAC
+	HMAC bool
+
+	// Compressed is set if the content of every file is gzip compressed
+	// before it is encrypted, see WithCompression
+	Compressed       bool
+	CompressionLevel int
+
+	// Deduplicated is set if files with identical content share it once
+	// loaded, see WithDeduplication; every file holds its own copy in Root
+	Deduplicated bool
+
+	// Chunked is set if content is encrypted in frames, see WithChunkedEncryption
+	Chunked bool
+
+	// KDF holds the parameters the key was derived with, see WithEncryptionPassphrase
+	KDF *KDFParams
+}
+
+// encode writes the filesystem snapshot to w in GOB format
+func (rootFS *FS) encode(w io.Writer) error {
+	rootFS.mu.Lock()
+	maxStorage := rootFS.maxStorage
+	enc := rootFS.encryptor
+	seal := rootFS.encryptedPaths && enc.encrypts() && !rootFS.sealedPaths
+	sealed := rootFS.sealedPaths
+	kdf := rootFS.kdf
+	rootFS.mu.Unlock()
+
+	root := rootFS.dir
+	if seal {
+		root = rootFS.sealTree(root, enc)
+	}
+
 	encoder := gob.NewEncoder(w)
-	return encoder.Encode(rootFS.dir)
+	return encoder.Encode(&snapshot{
+		Version:        snapshotVersion,
+		MaxStorage:     maxStorage,
+		Cipher:         rootFS.cipher,
+		Root:           root,
+		EncryptedPaths: seal || sealed,
+		PerFileKeys:    rootFS.perFileKeys,
+		BoundPaths:     rootFS.boundPaths,
+		HMAC:           enc.macKey != nil || enc.macRequired,
+		KDF:            kdf,
+
+		Compressed:       rootFS.compress,
+		CompressionLevel: rootFS.compressLevel,
+		Deduplicated:     rootFS.dedup != nil,
+		Chunked:          rootFS.chunked,
+	})
+}
+
+// decode reads a filesystem snapshot in GOB format from r.
+// A bare Dir stream (the format used before snapshots) is loaded as unlimited.
+func decode(r io.Reader) (*FS, error) {
+	snap, err := decodeSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize mutexes after loading and account for the loaded content
+	used := snap.Root.initDir()
+
+	// Initialize a disabled encryptor (encryption key not persisted)
+	enc := &encryptor{enable: false}
+	enc.setCompression(snap.Compressed, snap.CompressionLevel)
+	if snap.HMAC {
+		// The HMAC key is not persisted either
+		enc.requireMAC()
+	}
+
+	// Create new FS with loaded directory structure
+	fs := &FS{
+		storage: &storage{
+			maxStorage:  snap.MaxStorage,
+			usedStorage: used,
+			fileCount:   countFiles(snap.Root),
+		},
+		dir:       snap.Root,
+		encryptor: enc,
+		cipher:    snap.Cipher,
+		kdf:       snap.KDF,
+
+		encryptedPaths: snap.EncryptedPaths,
+		sealedPaths:    snap.EncryptedPaths,
+		perFileKeys:    snap.PerFileKeys,
+		boundPaths:     snap.BoundPaths,
+		compress:       snap.Compressed,
+		compressLevel:  snap.CompressionLevel,
+		chunked:        snap.Chunked,
+		macRequired:    snap.HMAC,
+	}
+	if !fs.sealedPaths {
+		setKeyPaths(fs.dir, "")
+	}
+	if snap.Deduplicated {
+		fs.dedup = newDedupStore()
+		fs.internTree(fs.dir)
+	}
+
+	return fs, nil
+}
+
+// decodeSnapshot reads the snapshot envelope in GOB format from r,
+// falling back to the legacy bare Dir format.
+func decodeSnapshot(r io.Reader) (*snapshot, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var snap snapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snap); err != nil || snap.Root == nil {
+		// Fall back to the legacy format
+		var rootDir Dir
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&rootDir); err != nil {
+			return nil, err
+		}
+		snap = snapshot{
+			MaxStorage: -1, // Default to unlimited
+			Root:       &rootDir,
+		}
+	}
+
+	return &snap, nil
 }
 
 // CompressAndSaveToFile saves the entire filesystem structure to a GOB encoded file after compressing the data using gzip
@@ -394,13 +1379,25 @@ func (rootFS *FS) CompressAndSaveToFile(filename string) error {
 
 // CompressAndSaveTo saves the filesystem structure to any io.Writer in GOB format after compressing the data using gzip
 func (rootFS *FS) CompressAndSaveTo(w io.Writer) error {
+	return rootFS.CompressAndSaveToLevel(w, gzip.DefaultCompression)
+}
+
+// CompressAndSaveToLevel is like CompressAndSaveTo, but compresses with the
+// given gzip level, from gzip.HuffmanOnly and gzip.BestSpeed to
+// gzip.BestCompression. An invalid level returns an error wrapping fs.ErrInvalid.
+func (rootFS *FS) CompressAndSaveToLevel(w io.Writer, level int) error {
 	// Create a gzip writer
-	gw := NewGzipWriter(w)
-	defer gw.Close()
+	gw, err := NewGzipWriterLevel(w, level)
+	if err != nil {
+		return err
+	}
 
 	// Encode and save the filesystem
-	encoder := gob.NewEncoder(gw)
-	return encoder.Encode(rootFS.dir)
+	if err := rootFS.encode(gw); err != nil {
+		gw.Close()
+		return err
+	}
+	return gw.Close()
 }
 
 // DecompressAndLoadFromFile loads the entire filesystem structure from a GOB encoded file after decompressing the data using gzip
@@ -424,32 +1421,14 @@ func DecompressAndLoadFrom(r io.Reader) (*FS, error) {
 	defer gr.Close()
 
 	// Decode and load the filesystem
-	var rootDir Dir
-	decoder := gob.NewDecoder(gr)
-	if err := decoder.Decode(&rootDir); err != nil {
-		return nil, err
-	}
-
-	// Initialize mutexes after loading
-	rootDir.initDir()
-
-	// Initialize a disabled encryptor (encryption key not persisted)
-	enc := &encryptor{enable: false}
-
-	// Create new FS with loaded directory structure
-	fs := &FS{
-		dir:        &rootDir,
-		maxStorage: -1, // Default to unlimited
-		encryptor:  enc,
-	}
-
-	return fs, nil
+	return decode(gr)
 }
 
 // init registers types for GOB encoding/decoding
 func init() {
 	gob.Register(&Dir{})
 	gob.Register(&File{})
+	gob.Register(&Symlink{})
 }
 
 // LoadFromFile creates a new FS by loading from a GOB encoded file
@@ -465,60 +1444,99 @@ func LoadFromFile(filename string) (*FS, error) {
 
 // LoadFrom creates a new FS by loading from a GOB encoded reader
 func LoadFrom(r io.Reader) (*FS, error) {
-	var rootDir Dir
-	decoder := gob.NewDecoder(r)
-	if err := decoder.Decode(&rootDir); err != nil {
+	return decode(r)
+}
+
+// Magic numbers at the start of compressed streams
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// LoadAnyFromFile creates a new FS from a file written by SaveToFile,
+// CompressAndSaveToFile or SaveZstdToFile, detecting the compression from
+// the first bytes of the file
+func LoadAnyFromFile(filename string) (*FS, error) {
+	f, err := os.Open(filename)
+	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	// Initialize mutexes after loading
-	rootDir.initDir()
+	return LoadAnyFrom(f)
+}
 
-	// Initialize a disabled encryptor (encryption key not persisted)
-	enc := &encryptor{enable: false}
+// LoadAnyFrom creates a new FS from a GOB encoded reader that is either
+// uncompressed or compressed with gzip or zstd
+func LoadAnyFrom(r io.Reader) (*FS, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
 
-	// Create new FS with loaded directory structure
-	fs := &FS{
-		dir:        &rootDir,
-		maxStorage: -1, // Default to unlimited
-		encryptor:  enc,
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return DecompressAndLoadFrom(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return LoadZstdFrom(br)
 	}
-
-	return fs, nil
+	return LoadFrom(br)
 }
 
 // Dir represents a directory in the filesystem
 type Dir struct {
-	mu       sync.Mutex `json:"-"` // Unexported, won't be serialized
-	Name     string
-	Perm     os.FileMode
-	ModTime  time.Time
-	Children map[string]childI
+	mu         sync.RWMutex `json:"-"` // Unexported, won't be serialized; read locked for lookups
+	Name       string
+	Perm       os.FileMode
+	ModTime    time.Time
+	AccessTime time.Time
+	Uid        int
+	Gid        int
+	Children   map[string]childI
+
+	sorted bool     // keep names in sync with Children, see WithSortedDirs
+	names  []string // sorted names of Children, only kept if sorted
 }
 
-// initDir initializes a directory after loading
-func (d *Dir) initDir() {
+// initDir initializes a directory after loading and returns the number of
+// bytes stored in files beneath it
+func (d *Dir) initDir() int64 {
+	var used int64
 	// Initialize children directories recursively
 	for _, child := range d.Children {
-		if dir, ok := child.(*Dir); ok {
-			dir.initDir()
+		switch c := child.(type) {
+		case *Dir:
+			used += c.initDir()
+		case *File:
+			used += c.storedSize()
 		}
 	}
+	return used
 }
 
 type fhDir struct {
-	dir *Dir
-	idx int
+	dir     *Dir
+	fs      *FS           // filesystem of dir, guards the metadata of its files
+	entries []fs.DirEntry // snapshot of the entries, taken by the first ReadDir
+	idx     int
 }
 
 func (d *fhDir) Stat() (fs.FileInfo, error) {
-	fi := fileInfo{
-		name:    d.dir.Name,
+	return d.dir.stat(), nil
+}
+
+// stat returns the FileInfo of d. The metadata of a directory is guarded by
+// its own lock, as its modification time changes with its children.
+func (d *Dir) stat() fs.FileInfo {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return &fileInfo{
+		name:    d.Name,
 		size:    4096,
-		modTime: d.dir.ModTime,
-		mode:    d.dir.Perm | fs.ModeDir,
+		modTime: d.ModTime,
+		atime:   d.AccessTime,
+		mode:    d.Perm | fs.ModeDir,
+		sys:     &Owner{Uid: d.Uid, Gid: d.Gid},
 	}
-	return &fi, nil
 }
 
 func (d *fhDir) Read(b []byte) (int, error) {
@@ -529,82 +1547,127 @@ func (d *fhDir) Close() error {
 	return nil
 }
 
+// ReadDir reads the entries of the directory. The entries are taken from the
+// directory in one go on the first call, so reading them in batches neither
+// skips nor repeats entries while the directory is changed concurrently.
 func (d *fhDir) ReadDir(n int) ([]fs.DirEntry, error) {
-	d.dir.mu.Lock()
-	defer d.dir.mu.Unlock()
-
-	names := make([]string, 0, len(d.dir.Children))
-	for name := range d.dir.Children {
-		names = append(names, name)
-	}
-
-	// directory already exhausted
-	if n <= 0 && d.idx >= len(names) {
-		return nil, nil
+	if d.entries == nil {
+		d.entries = d.fs.entries(d.dir)
 	}
+	rest := d.entries[d.idx:]
 
 	// read till end
-	var err error
-	if n > 0 && d.idx+n > len(names) {
-		err = io.EOF
-		if d.idx > len(names) {
-			return nil, err
+	if n <= 0 {
+		if len(rest) == 0 {
+			// directory already exhausted
+			return nil, nil
 		}
+		d.idx = len(d.entries)
+		return rest, nil
 	}
 
-	if n <= 0 {
-		n = len(names)
+	var err error
+	if n > len(rest) {
+		n = len(rest)
+		err = io.EOF
 	}
+	d.idx += n
+	return rest[:n], err
+}
 
-	out := make([]fs.DirEntry, 0, n)
-
-	for i := d.idx; i < n && i < len(names); i++ {
-		name := names[i]
-		child := d.dir.Children[name]
-
-		f, isFile := child.(*File)
-		if isFile {
-			stat, _ := f.Stat()
+// entries returns the entries of all children of d. Files are described
+// like by Stat, with the size of their plaintext.
+func (rootFS *FS) entries(d *Dir) []fs.DirEntry {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	names := d.childNames()
+	out := make([]fs.DirEntry, 0, len(names))
+	for _, name := range names {
+		switch c := d.Children[name].(type) {
+		case *File:
 			out = append(out, &dirEntry{
-				info: stat,
+				info: rootFS.fileStat(c),
 			})
-		} else {
-			d := child.(*Dir)
-			fi := fileInfo{
-				name:    d.Name,
-				size:    4096,
-				modTime: d.ModTime,
-				mode:    d.Perm | fs.ModeDir,
-			}
+		case *Symlink:
 			out = append(out, &dirEntry{
-				info: &fi,
+				info: c.stat(),
+			})
+		case *Dir:
+			out = append(out, &dirEntry{
+				info: c.stat(),
 			})
 		}
-
-		d.idx = i + 1
 	}
-
-	return out, err
+	return out
 }
 
 type File struct {
-	Name    string
-	Perm    os.FileMode
-	Content []byte
-	reader  *bytes.Reader `json:"-"` // Unexported, won't be serialized
-	ModTime time.Time
-	closed  bool `json:"-"` // Unexported, won't be serialized
+	Name       string
+	Perm       os.FileMode
+	Content    []byte
+	reader     io.ReadSeeker `json:"-"` // Unexported, won't be serialized
+	ModTime    time.Time
+	AccessTime time.Time
+	Uid        int
+	Gid        int
+	closed     bool   `json:"-"` // Unexported, won't be serialized
+	writers    int    `json:"-"` // number of open FileWriters, guarded by FS.mu
+	keyPath    string // path the key of the content is derived from, see WithPerFileKeys
+	blob       *blob  // content shared with other files, see WithDeduplication; guarded by FS.mu
+
+	// PlaintextSize is the size of the decrypted Content, recorded when the
+	// content is stored. It is 0 if unknown, e.g. for files of older snapshots
+	// or raw imports; see FS.plainSize.
+	PlaintextSize int64
+
+	expireAt time.Time // zero if the file never expires, guarded by FS.mu
+
+	// History holds previous versions of the file, oldest first (see WithVersioning)
+	History []*File
+}
+
+// plainSize returns the size of the plaintext of f. Files that are being
+// written hold plaintext; for others the recorded PlaintextSize is used, or,
+// if it is unknown, the size derived from the length of the ciphertext.
+// The caller must hold rootFS.mu.
+func (rootFS *FS) plainSize(f *File) int64 {
+	n := len(f.Content)
+	switch {
+	case f.writers > 0:
+		return int64(n)
+	case f.PlaintextSize > 0 || n == 0:
+		return f.PlaintextSize
+	case rootFS.encryptor != nil:
+		return int64(rootFS.encryptor.plaintextSize(n))
+	}
+	return int64(n)
+}
+
+// storedSize returns the number of bytes stored for the file and its previous versions
+func (f *File) storedSize() int64 {
+	size := int64(len(f.Content))
+	for _, v := range f.History {
+		size += int64(len(v.Content))
+	}
+	return size
 }
 
 func (f *File) Stat() (fs.FileInfo, error) {
 	if f.closed {
 		return nil, fs.ErrClosed
 	}
+	size := int64(len(f.Content))
+	if r, ok := f.reader.(*frameReader); ok {
+		size = r.Size()
+	}
 	fi := fileInfo{
 		name:    f.Name,
-		size:    int64(len(f.Content)),
+		size:    size,
 		modTime: f.ModTime,
+		atime:   f.AccessTime,
 		mode:    f.Perm,
+		sys:     &Owner{Uid: f.Uid, Gid: f.Gid},
 	}
 	return &fi, nil
 }
@@ -636,20 +1699,123 @@ func (f *File) Close() error {
 // it is truncated. If the file does not exist, it is created with mode 0666.
 // The handle returned is open for writing.
 func (rootFS *FS) Create(path string) (*FileWriter, error) {
+	return rootFS.createCtx(context.Background(), path)
+}
+
+// createCtx implements Create, giving up if ctx is done before the file is touched
+func (rootFS *FS) createCtx(ctx context.Context, path string) (*FileWriter, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := rootFS.checkWritable(path); err != nil {
+		return nil, err
+	}
+
+	// The size of the replaced file is released by create
 	file, err := rootFS.create(path)
 	if err != nil {
 		return nil, err
 	}
 
-	// Reset content for new/truncated file
+	file.Content = []byte{}
+	file.ModTime = time.Now()
+
+	return rootFS.newFileWriter(file)
+}
+
+// CreateWithSize creates or truncates the named file like Create, but reserves
+// size bytes of storage up front. If the reservation would exceed the storage
+// limit, CreateWithSize fails before the file is touched. Writes within the
+// reservation do not check the limit again; the unused part of the
+// reservation is released when the FileWriter is closed.
+func (rootFS *FS) CreateWithSize(path string, size int64, perm os.FileMode) (*FileWriter, error) {
+	if err := rootFS.checkWritable(path); err != nil {
+		return nil, err
+	}
+
+	if size < 0 {
+		return nil, fmt.Errorf("negative size: %d: %w", size, fs.ErrInvalid)
+	}
+
+	if err := rootFS.checkFileSize(path, size); err != nil {
+		return nil, err
+	}
+
+	var reserved int64
+	rootFS.mu.Lock()
+	sealed := rootFS.sealedSize(int(size))
+	rootFS.mu.Unlock()
+	rootFS.makeRoom(sealed)
+
 	rootFS.mu.Lock()
 	if rootFS.maxStorage > 0 {
-		rootFS.usedStorage -= int64(len(file.Content))
+		// Reserve the size the content will have once it is encrypted
+		reserved = sealed
+		if rootFS.usedStorage+reserved > rootFS.maxStorage {
+			rootFS.mu.Unlock()
+			return nil, fmt.Errorf("storage limit exceeded: %w", fs.ErrInvalid)
+		}
+		rootFS.usedStorage += reserved
 	}
 	rootFS.mu.Unlock()
 
-	file.Content = []byte{}
-	file.ModTime = time.Now()
+	// The file is not visible before insert, so it can be set up without locking
+	file := &File{
+		Perm:    perm,
+		Content: make([]byte, 0, size),
+		ModTime: time.Now(),
+		writers: 1,
+	}
+
+	old, err := rootFS.insert(path, file)
+
+	if err != nil {
+		rootFS.mu.Lock()
+		rootFS.usedStorage -= reserved
+		rootFS.mu.Unlock()
+		return nil, err
+	}
+	if old != nil {
+		rootFS.releaseReplaced(old, file)
+	}
+
+	return &FileWriter{
+		file:     file,
+		fs:       rootFS,
+		reserved: reserved,
+	}, nil
+}
+
+// FileWriter is a handle to write to a file in the memory filesystem.
+// Until it is closed, opening the file for reading returns the content
+// written so far; with encryption enabled, the content is only encrypted
+// at rest once every writer of the file has been closed.
+type FileWriter struct {
+	file     *File
+	fs       *FS
+	closed   bool
+	reserved int64 // storage reserved by CreateWithSize that is not yet written
+}
+
+// newFileWriter returns a FileWriter for file and marks the file as being written.
+// While a file has unclosed writers its Content holds plaintext, even when
+// encryption is enabled; it is encrypted again when the last writer is closed.
+func (rootFS *FS) newFileWriter(file *File) (*FileWriter, error) {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+
+	// Writes modify the content in place
+	rootFS.ownContent(file)
+	if file.writers == 0 && rootFS.encryptor != nil && rootFS.encryptor.enable && len(file.Content) > 0 {
+		plaintext, err := rootFS.encryptor.decrypt(file.keyPath, file.Content)
+		if err != nil {
+			return nil, err
+		}
+		// The in-flight plaintext is accounted for with its encrypted size (see sealedSize)
+		file.Content = plaintext
+	}
+	file.writers++
 
 	return &FileWriter{
 		file: file,
@@ -657,11 +1823,35 @@ func (rootFS *FS) Create(path string) (*FileWriter, error) {
 	}, nil
 }
 
-// FileWriter is a handle to write to a file in the memory filesystem
-type FileWriter struct {
-	file   *File
-	fs     *FS
-	closed bool
+// sealedSize returns the number of bytes n bytes of plaintext take up once
+// they are encrypted at rest. The caller must hold rootFS.mu.
+func (rootFS *FS) sealedSize(n int) int64 {
+	if rootFS.encryptor == nil {
+		return int64(n)
+	}
+	return int64(rootFS.encryptor.ciphertextSize(n))
+}
+
+// readContent returns the plaintext content of file. If the file is still
+// being written, the unfinalized content written so far is returned as is.
+func (rootFS *FS) readContent(file *File) ([]byte, error) {
+	// The encryptor is read with the content, as the key may be rotated
+	rootFS.mu.Lock()
+	content := file.Content
+	writing := file.writers > 0
+	enc := rootFS.encryptor
+	keyPath := file.keyPath
+	rootFS.mu.Unlock()
+
+	if writing || enc == nil || !enc.enable || len(content) == 0 {
+		return content, nil
+	}
+
+	decryptedContent, err := enc.decrypt(keyPath, content)
+	if err != nil {
+		return nil, err
+	}
+	return decryptedContent, nil
 }
 
 // Write writes data to the file
@@ -670,23 +1860,62 @@ func (fw *FileWriter) Write(p []byte) (n int, err error) {
 		return 0, fs.ErrClosed
 	}
 
+	// Bytes within the reservation of CreateWithSize need no room
+	if need := int64(len(p)) - fw.reserved; need > 0 {
+		fw.fs.makeRoom(need)
+	}
+
 	fw.fs.mu.Lock()
 	defer fw.fs.mu.Unlock()
 
+	return fw.writeAt(p, int64(len(fw.file.Content)))
+}
+
+// writeAt writes p to the file content at offset off, growing the content
+// as needed. The caller must hold fw.fs.mu.
+func (fw *FileWriter) writeAt(p []byte, off int64) (n int, err error) {
+	if fw.fs.readOnly {
+		return 0, fmt.Errorf("read-only filesystem: %s: %w", fw.file.Name, fs.ErrPermission)
+	}
+
+	size := max(len(fw.file.Content), int(off)+len(p))
+	if err := fw.fs.checkFileSize(fw.file.Name, int64(size)); err != nil {
+		return 0, err
+	}
+
+	// The in-flight buffer counts against the storage limit with the size it
+	// will have once it is encrypted on Close
+	grow := fw.fs.sealedSize(size) - fw.fs.sealedSize(len(fw.file.Content))
+
+	// Bytes within the reservation are already accounted for
+	unreserved := grow
+	if fw.reserved > 0 {
+		covered := min(fw.reserved, unreserved)
+		fw.reserved -= covered
+		unreserved -= covered
+	}
+
 	// Check if the write would exceed the maximum storage limit
-	if fw.fs.maxStorage > 0 {
+	if fw.fs.maxStorage > 0 && unreserved > 0 {
 		// Only count the actual new bytes being added
-		newSize := fw.fs.usedStorage + int64(len(p))
+		newSize := fw.fs.usedStorage + unreserved
 		if newSize > fw.fs.maxStorage {
+			fw.reserved += grow - unreserved
 			return 0, fmt.Errorf("storage limit exceeded: %w", fs.ErrInvalid)
 		}
-		fw.fs.usedStorage += int64(len(p))
 	}
+	fw.fs.usedStorage += unreserved
 
-	// Note: For streaming writes, we append plaintext and will encrypt on Close
+	// Note: For streaming writes, we write plaintext and will encrypt on Close
 	// This is because encryption with AES-GCM needs the complete data
-	fw.file.Content = append(fw.file.Content, p...)
+	if size > len(fw.file.Content) {
+		fw.file.Content = append(fw.file.Content, make([]byte, size-len(fw.file.Content))...)
+	}
+	copy(fw.file.Content[off:], p)
 	fw.file.ModTime = time.Now()
+	if fw.fs.ttl > 0 {
+		fw.file.expireAt = fw.file.ModTime.Add(fw.fs.ttl)
+	}
 	return len(p), nil
 }
 
@@ -697,40 +1926,168 @@ func (fw *FileWriter) Close() error {
 	}
 	fw.closed = true
 
-	// Encrypt the content before finalizing if encryption is enabled
-	if fw.fs.encryptor != nil && fw.fs.encryptor.enable {
+	fw.fs.mu.Lock()
+	defer fw.fs.mu.Unlock()
+
+	fw.file.writers--
+	size := len(fw.file.Content)
+
+	// Release the unused part of the reservation
+	fw.fs.usedStorage -= fw.reserved
+	fw.reserved = 0
+
+	// The write hook sees the complete content once the last writer is done
+	if fw.file.writers == 0 && fw.fs.writeHook != nil {
+		content, err := fw.fs.writeHook(fw.fs.relativePath(fw.file.keyPath), fw.file.Content)
+		if err != nil {
+			// The previous content is gone, so the file is left empty
+			fw.fs.usedStorage -= fw.fs.sealedSize(size)
+			fw.file.Content = []byte{}
+			fw.file.PlaintextSize = 0
+			fw.file.reader = bytes.NewReader(fw.file.Content)
+			return fmt.Errorf("write hook: %s: %w", fw.file.Name, err)
+		}
+		fw.fs.usedStorage += fw.fs.sealedSize(len(content)) - fw.fs.sealedSize(size)
+		fw.file.Content = content
+		size = len(content)
+	}
+
+	// Encrypt the content once the last writer is done if encryption is enabled
+	if fw.file.writers == 0 && fw.fs.encryptor != nil && fw.fs.encryptor.enable {
 		plaintext := fw.file.Content
-		encryptedData, err := fw.fs.encryptor.encrypt(plaintext)
+		encryptedData, err := fw.fs.encryptor.encrypt(fw.file.keyPath, plaintext)
 		if err != nil {
 			return fmt.Errorf("encryption failed on close: %w", err)
 		}
 
-		// Update storage accounting for the difference in size
-		fw.fs.mu.Lock()
-		if fw.fs.maxStorage > 0 {
-			sizeDiff := int64(len(encryptedData)) - int64(len(plaintext))
-			fw.fs.usedStorage += sizeDiff
+		// Update storage accounting for any difference to the size reserved while writing
+		sizeDiff := int64(len(encryptedData)) - fw.fs.sealedSize(len(plaintext))
+		fw.fs.usedStorage += sizeDiff
+
+		fw.file.Content = encryptedData
+	}
+	if fw.file.writers == 0 {
+		fw.file.PlaintextSize = int64(size)
+		fw.fs.intern(fw.file)
+	}
+
+	// Update the reader in case the file is also open for reading
+	fw.file.reader = bytes.NewReader(fw.file.Content)
+	fw.fs.notify(OpWrite, fw.file.keyPath)
+	return fw.fs.checkFileSize(fw.file.Name, int64(size))
+}
+
+// Stat returns a FileInfo describing the file; while it is being written,
+// the size is the size of the content written so far
+func (fw *FileWriter) Stat() (fs.FileInfo, error) {
+	if fw.closed {
+		return nil, fs.ErrClosed
+	}
+	return fw.fs.fileStat(fw.file), nil
+}
+
+// ErrTooManyFiles is returned when a file would be created beyond the limit
+// set with WithMaxFiles
+var ErrTooManyFiles = errors.New("memfs: too many files")
+
+// addFiles counts n new files created at path, failing with an error
+// wrapping ErrTooManyFiles if that exceeds the limit set with WithMaxFiles.
+// The caller must hold rootFS.mu.
+func (rootFS *FS) addFiles(path string, n int) error {
+	if rootFS.maxFiles > 0 && rootFS.fileCount+n > rootFS.maxFiles {
+		return fmt.Errorf("file limit of %d reached: %s: %w", rootFS.maxFiles, path, ErrTooManyFiles)
+	}
+	rootFS.fileCount += n
+	return nil
+}
+
+// countFiles returns the number of files below dir, which must not be
+// visible to other goroutines yet
+func countFiles(dir *Dir) int {
+	n := 0
+	for _, child := range dir.Children {
+		switch c := child.(type) {
+		case *Dir:
+			n += countFiles(c)
+		case *File:
+			n++
 		}
-		fw.fs.mu.Unlock()
-
-		fw.file.Content = encryptedData
 	}
+	return n
+}
 
-	// Update the reader in case the file is also open for reading
-	fw.file.reader = bytes.NewReader(fw.file.Content)
+// checkFileSize returns an error if a file of size bytes of plaintext
+// exceeds the limit set with WithMaxFileSize
+func (rootFS *FS) checkFileSize(name string, size int64) error {
+	if rootFS.maxFileSize > 0 && size > rootFS.maxFileSize {
+		return fmt.Errorf("file too large: %s: %d bytes exceeds %d: %w", name, size, rootFS.maxFileSize, fs.ErrInvalid)
+	}
 	return nil
 }
 
+// WriteFileFS is a file system with a WriteFile method, the counterpart of
+// fs.ReadFileFS.
+type WriteFileFS interface {
+	fs.FS
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+var _ WriteFileFS = (*FS)(nil)
+
+// FSFile is a file opened by OpenFile. Depending on the flags it was opened
+// with, it is an fs.File for reading, a *FileWriter or a *FileReadWriter.
+type FSFile interface {
+	Stat() (fs.FileInfo, error)
+	Close() error
+}
+
 // OpenFile opens a file with specified flag and permission
-// The flag values are similar to os.OpenFile
-func (rootFS *FS) OpenFile(path string, flag int, perm os.FileMode) (interface{}, error) {
+// The flag values are similar to os.OpenFile.
+// When the file is opened with O_RDWR, a *FileReadWriter is returned that can
+// read, write and seek. When it is opened for writing only (O_WRONLY or O_APPEND),
+// a *FileWriter is returned whose writes are appended to the existing content,
+// unless O_TRUNC is set. Otherwise the file is opened for reading like Open.
+func (rootFS *FS) OpenFile(path string, flag int, perm os.FileMode) (FSFile, error) {
 	// First, check if path is valid
 	if !fs.ValidPath(path) {
 		return nil, fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
 	}
 
+	// Reject any flag that could modify the filesystem when read-only
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		if err := rootFS.checkWritable(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// O_APPEND implies write access
+	write := flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND) != 0
+
+	// Files of the lower layer of an overlay are modified in the upper layer
+	if write {
+		if err := rootFS.copyUp(path); err != nil {
+			return nil, err
+		}
+	}
+
 	// Handle creating a new file
 	if flag&os.O_CREATE != 0 {
+		// With O_EXCL the path must not exist, not even as a symbolic link
+		if flag&os.O_EXCL != 0 {
+			file, err := rootFS.createExclusive(path)
+			if errors.Is(err, fs.ErrExist) {
+				return nil, &fs.PathError{
+					Op:   "open",
+					Path: path,
+					Err:  fs.ErrExist,
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			return rootFS.openCreated(file, flag, write)
+		}
+
 		// Try to get the file first
 		child, err := rootFS.get(path)
 
@@ -744,23 +2101,12 @@ func (rootFS *FS) OpenFile(path string, flag int, perm os.FileMode) (interface{}
 				}
 
 				rootFS.mu.Lock()
-				if rootFS.maxStorage > 0 {
-					rootFS.usedStorage -= int64(len(file.Content))
-				}
+				rootFS.usedStorage -= int64(len(file.Content))
 				file.Content = []byte{}
 				file.ModTime = time.Now()
 				rootFS.mu.Unlock()
 
-				if flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 {
-					return &FileWriter{
-						file: file,
-						fs:   rootFS,
-					}, nil
-				} else {
-					// Create but only for reading (unusual case)
-					file.reader = bytes.NewReader(file.Content)
-					return file, nil
-				}
+				return rootFS.openCreated(file, flag, write)
 			}
 			return nil, err
 		}
@@ -771,49 +2117,26 @@ func (rootFS *FS) OpenFile(path string, flag int, perm os.FileMode) (interface{}
 			return nil, fmt.Errorf("path is a directory: %s: %w", path, fs.ErrInvalid)
 		}
 
-		if flag&os.O_TRUNC != 0 && (flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0) {
+		if flag&os.O_TRUNC != 0 && write {
 			// Truncate the file
 			rootFS.mu.Lock()
-			if rootFS.maxStorage > 0 {
-				rootFS.usedStorage -= int64(len(file.Content))
-			}
+			rootFS.usedStorage -= int64(len(file.Content))
+			rootFS.unshare(file)
 			file.Content = []byte{}
 			file.ModTime = time.Now()
 			rootFS.mu.Unlock()
 		}
 
-		if flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 {
-			// For write mode, we need to decrypt first if file has content
-			if rootFS.encryptor != nil && rootFS.encryptor.enable && len(file.Content) > 0 {
-				decryptedContent, err := rootFS.encryptor.decrypt(file.Content)
-				if err != nil {
-					return nil, fmt.Errorf("decryption failed: %w", err)
-				}
-				// Update to decrypted content for write operations
-				file.Content = decryptedContent
-			}
-			return &FileWriter{
-				file: file,
-				fs:   rootFS,
-			}, nil
+		if write {
+			// For write mode, newFileWriter loads the decrypted content to append to
+			return rootFS.openWriter(file, flag)
 		} else {
 			// Open for reading only - decrypt the content
-			content := file.Content
-			if rootFS.encryptor != nil && rootFS.encryptor.enable && len(content) > 0 {
-				decryptedContent, err := rootFS.encryptor.decrypt(content)
-				if err != nil {
-					return nil, fmt.Errorf("decryption failed: %w", err)
-				}
-				content = decryptedContent
-			}
-			handle := &File{
-				Name:    file.Name,
-				Perm:    file.Perm,
-				Content: content,
-				reader:  bytes.NewReader(content),
-				ModTime: file.ModTime,
+			content, err := rootFS.readContent(file)
+			if err != nil {
+				return nil, err
 			}
-			return handle, nil
+			return rootFS.openHandle(file, content), nil
 		}
 	}
 
@@ -833,28 +2156,231 @@ func (rootFS *FS) OpenFile(path string, flag int, perm os.FileMode) (interface{}
 		return nil, fmt.Errorf("path is a directory: %s: %w", path, fs.ErrInvalid)
 	}
 
-	if flag&os.O_TRUNC != 0 && (flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0) {
+	if flag&os.O_TRUNC != 0 && write {
 		// Truncate the file
 		rootFS.mu.Lock()
-		if rootFS.maxStorage > 0 {
-			rootFS.usedStorage -= int64(len(file.Content))
-		}
+		rootFS.usedStorage -= int64(len(file.Content))
+		rootFS.unshare(file)
 		file.Content = []byte{}
 		file.ModTime = time.Now()
 		rootFS.mu.Unlock()
 	}
 
-	if flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 {
-		return &FileWriter{
-			file: file,
-			fs:   rootFS,
-		}, nil
+	if write {
+		return rootFS.openWriter(file, flag)
 	}
 
 	// Default to opening for reading
 	return rootFS.Open(path)
 }
 
+// openCreated returns the handle OpenFile hands out for file, which it has
+// just created
+func (rootFS *FS) openCreated(file *File, flag int, write bool) (FSFile, error) {
+	if write {
+		return rootFS.openWriter(file, flag)
+	}
+	// Create but only for reading (unusual case)
+	file.reader = bytes.NewReader(file.Content)
+	return file, nil
+}
+
+// openWriter returns the write handle OpenFile hands out for file opened with flag
+func (rootFS *FS) openWriter(file *File, flag int) (FSFile, error) {
+	if flag&os.O_RDWR != 0 {
+		rw, err := rootFS.newFileReadWriter(file, flag&os.O_APPEND != 0)
+		if err != nil {
+			return nil, err
+		}
+		return rw, nil
+	}
+
+	fw, err := rootFS.newFileWriter(file)
+	if err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// Chmod changes the mode of the named file or directory to mode.
+// Symbolic links are followed. Chmod on the root directory "." is a no-op.
+func (rootFS *FS) Chmod(path string, mode os.FileMode) error {
+	mode &= fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+	return rootFS.updateChild(path, func(child childI) {
+		switch c := child.(type) {
+		case *File:
+			rootFS.mu.Lock()
+			defer rootFS.mu.Unlock()
+			c.Perm = mode
+		case *Dir:
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.Perm = mode
+		}
+	})
+}
+
+// Chown changes the numeric uid and gid of the named file or directory.
+// Symbolic links are followed. Chown on the root directory "." is a no-op.
+func (rootFS *FS) Chown(path string, uid, gid int) error {
+	return rootFS.updateChild(path, func(child childI) {
+		switch c := child.(type) {
+		case *File:
+			rootFS.mu.Lock()
+			defer rootFS.mu.Unlock()
+			c.Uid, c.Gid = uid, gid
+		case *Dir:
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.Uid, c.Gid = uid, gid
+		}
+	})
+}
+
+// Chtimes changes the access and modification times of the named file or
+// directory, similar to os.Chtimes. A zero time.Time value leaves the
+// corresponding time unchanged. Symbolic links are followed.
+// Chtimes on the root directory "." is a no-op.
+func (rootFS *FS) Chtimes(path string, atime, mtime time.Time) error {
+	return rootFS.updateChild(path, func(child childI) {
+		switch c := child.(type) {
+		case *File:
+			rootFS.mu.Lock()
+			defer rootFS.mu.Unlock()
+			if !atime.IsZero() {
+				c.AccessTime = atime
+			}
+			if !mtime.IsZero() {
+				c.ModTime = mtime
+			}
+		case *Dir:
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if !atime.IsZero() {
+				c.AccessTime = atime
+			}
+			if !mtime.IsZero() {
+				c.ModTime = mtime
+			}
+		}
+	})
+}
+
+// updateChild calls fn with the file or directory at path while holding
+// the lock of its parent directory. Symbolic links are followed.
+// The root directory has no parent, so updateChild does nothing for ".".
+func (rootFS *FS) updateChild(path string, fn func(child childI)) error {
+	if !fs.ValidPath(path) {
+		return fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
+	}
+
+	if err := rootFS.checkWritable(path); err != nil {
+		return err
+	}
+
+	if err := rootFS.copyUp(path); err != nil {
+		return err
+	}
+
+	path, err := rootFS.resolve(path, true)
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		return nil
+	}
+
+	dirPart, filePart := syspath.Split(path)
+	dirPart = strings.TrimSuffix(dirPart, "/")
+
+	dir, err := rootFS.getDir(dirPart)
+	if err != nil {
+		return err
+	}
+
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+
+	child, exists := dir.Children[filePart]
+	if !exists {
+		return fmt.Errorf("no such file or directory: %s: %w", path, fs.ErrNotExist)
+	}
+
+	fn(child)
+	return nil
+}
+
+// Truncate changes the size of the named file. If the file is shorter than size,
+// it is extended with zero bytes. The size refers to the plaintext content,
+// the file is re-encrypted afterwards if encryption is enabled.
+func (rootFS *FS) Truncate(path string, size int64) error {
+	if err := rootFS.checkWritable(path); err != nil {
+		return err
+	}
+
+	if size < 0 {
+		return fmt.Errorf("negative size: %d: %w", size, fs.ErrInvalid)
+	}
+
+	if err := rootFS.checkFileSize(path, size); err != nil {
+		return err
+	}
+
+	if err := rootFS.copyUp(path); err != nil {
+		return err
+	}
+
+	file, err := rootFS.getFile(path)
+	if err != nil {
+		return err
+	}
+
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+
+	// Files with open writers hold plaintext that is encrypted on Close
+	encrypt := file.writers == 0 && rootFS.encryptor != nil && rootFS.encryptor.enable
+
+	content := file.Content
+	if encrypt && len(content) > 0 {
+		content, err = rootFS.encryptor.decrypt(file.keyPath, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	if size <= int64(len(content)) {
+		content = content[:size:size]
+	} else {
+		content = append(content[:len(content):len(content)], make([]byte, size-int64(len(content)))...)
+	}
+
+	if encrypt {
+		content, err = rootFS.encryptor.encrypt(file.keyPath, content)
+		if err != nil {
+			return fmt.Errorf("encryption failed: %w", err)
+		}
+	}
+
+	delta := int64(len(content)) - int64(len(file.Content))
+	if file.writers > 0 {
+		delta = rootFS.sealedSize(len(content)) - rootFS.sealedSize(len(file.Content))
+	}
+	if rootFS.maxStorage > 0 && delta > 0 && rootFS.usedStorage+delta > rootFS.maxStorage {
+		return fmt.Errorf("storage limit exceeded: %w", fs.ErrInvalid)
+	}
+	rootFS.usedStorage += delta
+
+	rootFS.unshare(file)
+	file.Content = content
+	file.PlaintextSize = size
+	file.ModTime = time.Now()
+	rootFS.intern(file)
+	rootFS.notify(OpWrite, file.keyPath)
+	return nil
+}
+
 // Remove deletes a file or empty directory from the filesystem.
 // If the path refers to a non-empty directory, an error is returned.
 func (rootFS *FS) Remove(path string) error {
@@ -862,10 +2388,34 @@ func (rootFS *FS) Remove(path string) error {
 		return fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
 	}
 
+	if err := rootFS.checkWritable(path); err != nil {
+		return err
+	}
+
 	if path == "." {
 		return fmt.Errorf("cannot remove root directory: %w", fs.ErrInvalid)
 	}
 
+	if rootFS.lower == nil {
+		return rootFS.remove(path)
+	}
+
+	// In an overlay, a path of the lower layer is hidden instead of removed
+	if _, err := rootFS.lowerStat(path); err != nil {
+		return rootFS.remove(path)
+	}
+	if err := rootFS.checkOverlayEmpty(path); err != nil {
+		return err
+	}
+	if err := rootFS.remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	rootFS.whiteout(path)
+	return nil
+}
+
+// remove removes the file or empty directory path from the filesystem
+func (rootFS *FS) remove(path string) error {
 	dirPart, filePart := syspath.Split(path)
 	dirPart = strings.TrimSuffix(dirPart, "/")
 
@@ -873,6 +2423,7 @@ func (rootFS *FS) Remove(path string) error {
 	if err != nil {
 		return err
 	}
+	keyPath := syspath.Join(rootFS.keyPath(dirPart), filePart)
 
 	dir.mu.Lock()
 	defer dir.mu.Unlock()
@@ -884,9 +2435,9 @@ func (rootFS *FS) Remove(path string) error {
 
 	// If it's a directory, check if it's empty
 	if childDir, ok := child.(*Dir); ok {
-		childDir.mu.Lock()
+		childDir.mu.RLock()
 		isEmpty := len(childDir.Children) == 0
-		childDir.mu.Unlock()
+		childDir.mu.RUnlock()
 
 		if !isEmpty {
 			return fmt.Errorf("directory not empty: %s", path)
@@ -896,14 +2447,14 @@ func (rootFS *FS) Remove(path string) error {
 	// If it's a file, adjust the storage usage
 	if file, ok := child.(*File); ok {
 		rootFS.mu.Lock()
-		if rootFS.maxStorage > 0 {
-			rootFS.usedStorage -= int64(len(file.Content))
-		}
+		rootFS.releaseFile(file)
 		rootFS.mu.Unlock()
 	}
 
 	// Remove the entry
-	delete(dir.Children, filePart)
+	dir.deleteChild(filePart)
+	dir.ModTime = time.Now()
+	rootFS.notify(OpRemove, keyPath)
 	return nil
 }
 
@@ -915,20 +2466,47 @@ func (rootFS *FS) RemoveAll(path string) error {
 		return fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
 	}
 
+	if err := rootFS.checkWritable(path); err != nil {
+		return err
+	}
+
+	if err := rootFS.removeAll(path); err != nil {
+		return err
+	}
+
+	// In an overlay, the path is hidden in the lower layer as well
+	if rootFS.lower != nil {
+		rootFS.whiteout(path)
+	}
+	return nil
+}
+
+// removeAll removes path and any children it contains from the filesystem
+func (rootFS *FS) removeAll(path string) error {
 	if path == "." {
 		// Special case: clear entire filesystem but keep root dir
 		rootFS.dir.mu.Lock()
 
-		// Adjust storage counters
-		if rootFS.maxStorage > 0 {
-			rootFS.mu.Lock()
-			rootFS.usedStorage = 0
-			rootFS.mu.Unlock()
+		// Adjust storage counters. They are not simply reset, as the tree of
+		// a Sub filesystem is part of the tree of its parent.
+		for _, child := range rootFS.dir.Children {
+			switch c := child.(type) {
+			case *File:
+				rootFS.mu.Lock()
+				rootFS.releaseFile(c)
+				rootFS.mu.Unlock()
+			case *Dir:
+				rootFS.removeStorageUsed(c)
+			}
 		}
 
 		// Clear all children
-		rootFS.dir.Children = make(map[string]childI)
+		if len(rootFS.dir.Children) > 0 {
+			rootFS.dir.clearChildren()
+			rootFS.dir.ModTime = time.Now()
+		}
 		rootFS.dir.mu.Unlock()
+		rootFS.notify(OpRemove, rootFS.keyPrefix)
 		return nil
 	}
 
@@ -941,6 +2519,7 @@ func (rootFS *FS) RemoveAll(path string) error {
 		// which is not an error for RemoveAll (matches os.RemoveAll behavior)
 		return nil
 	}
+	keyPath := syspath.Join(rootFS.keyPath(dirPart), filePart)
 
 	dir.mu.Lock()
 	defer dir.mu.Unlock()
@@ -950,48 +2529,82 @@ func (rootFS *FS) RemoveAll(path string) error {
 		// Path doesn't exist, which is not an error for RemoveAll
 		return nil
 	}
+	dir.ModTime = time.Now()
+	defer rootFS.notify(OpRemove, keyPath)
 
 	// If it's a file, adjust the storage usage and remove it
 	if file, ok := child.(*File); ok {
 		rootFS.mu.Lock()
-		if rootFS.maxStorage > 0 {
-			rootFS.usedStorage -= int64(len(file.Content))
-		}
+		rootFS.releaseFile(file)
 		rootFS.mu.Unlock()
-		delete(dir.Children, filePart)
+		dir.deleteChild(filePart)
 		return nil
 	}
 
 	// If it's a directory, we need to calculate storage used by all files in it recursively
 	if childDir, ok := child.(*Dir); ok {
 		// Calculate storage used by the directory and its contents
-		if rootFS.maxStorage > 0 {
-			rootFS.removeStorageUsed(childDir)
-		}
+		rootFS.removeStorageUsed(childDir)
 
 		// Remove the directory entry
-		delete(dir.Children, filePart)
+		dir.deleteChild(filePart)
+	}
+
+	// Symbolic links are removed without touching their target
+	if _, ok := child.(*Symlink); ok {
+		dir.deleteChild(filePart)
 	}
 
 	return nil
 }
 
+// walk calls fn for every directory and file in the filesystem in lexical order,
+// parents before their children. The root directory itself is not visited.
+// If fn returns an error, walking stops and that error is returned.
+func (rootFS *FS) walk(fn func(path string, child childI) error) error {
+	return walkDir(rootFS.dir, "", fn)
+}
+
+func walkDir(dir *Dir, prefix string, fn func(path string, child childI) error) error {
+	dir.mu.RLock()
+	names := dir.sortedChildNames()
+	children := make(map[string]childI, len(dir.Children))
+	for name, child := range dir.Children {
+		children[name] = child
+	}
+	dir.mu.RUnlock()
+
+	for _, name := range names {
+		path := syspath.Join(prefix, name)
+		child := children[name]
+		if err := fn(path, child); err != nil {
+			return err
+		}
+		if childDir, ok := child.(*Dir); ok {
+			if err := walkDir(childDir, path, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // removeStorageUsed recursively calculates and removes the storage used by a directory
 func (rootFS *FS) removeStorageUsed(dir *Dir) {
 	// First collect all the files and directories that need to be processed
-	var fileSizes []int
+	var files []*File
 	var subdirs []*Dir
 
 	// Lock the directory to safely iterate through its children
-	dir.mu.Lock()
+	dir.mu.RLock()
 	for _, child := range dir.Children {
 		if file, ok := child.(*File); ok {
-			fileSizes = append(fileSizes, len(file.Content))
+			files = append(files, file)
 		} else if childDir, ok := child.(*Dir); ok {
 			subdirs = append(subdirs, childDir)
 		}
 	}
-	dir.mu.Unlock()
+	dir.mu.RUnlock()
 
 	// Process subdirectories recursively
 	for _, subdir := range subdirs {
@@ -999,30 +2612,194 @@ func (rootFS *FS) removeStorageUsed(dir *Dir) {
 	}
 
 	// Update the storage usage for files in this directory
-	if len(fileSizes) > 0 {
+	if len(files) > 0 {
 		rootFS.mu.Lock()
-		for _, size := range fileSizes {
-			rootFS.usedStorage -= int64(size)
+		for _, file := range files {
+			rootFS.releaseFile(file)
 		}
 		rootFS.mu.Unlock()
 	}
 }
 
 // UsedStorage returns the current amount of storage space (in bytes) being used by the filesystem.
-// If storage tracking is not enabled (maxStorage <= 0), this will still return the actual space used.
+// Storage usage is tracked whether or not a limit has been set with WithMaxStorage.
 func (rootFS *FS) UsedStorage() int64 {
 	rootFS.mu.Lock()
 	defer rootFS.mu.Unlock()
 	return rootFS.usedStorage
 }
 
+// LogicalSize returns the total plaintext size (in bytes) of all files in the filesystem.
+// Unlike UsedStorage, it does not include the overhead of encryption at rest.
+func (rootFS *FS) LogicalSize() int64 {
+	var size int64
+	rootFS.walk(func(path string, child childI) error {
+		if f, ok := child.(*File); ok {
+			rootFS.mu.Lock()
+			size += rootFS.plainSize(f)
+			rootFS.mu.Unlock()
+		}
+		return nil
+	})
+	return size
+}
+
+// DirSize returns the total plaintext size (in bytes) of all files below the
+// directory at path, like LogicalSize does for the whole filesystem. An
+// error wrapping fs.ErrNotExist is returned if path does not exist, and one
+// wrapping fs.ErrInvalid if it is not a directory.
+func (rootFS *FS) DirSize(path string) (int64, error) {
+	if !fs.ValidPath(path) {
+		return 0, fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
+	}
+
+	child, err := rootFS.get(path)
+	if err != nil {
+		return 0, err
+	}
+	dir, ok := child.(*Dir)
+	if !ok {
+		return 0, fmt.Errorf("not a directory: %s: %w", path, fs.ErrInvalid)
+	}
+
+	var size int64
+	walkDir(dir, path, func(path string, child childI) error {
+		if f, ok := child.(*File); ok {
+			rootFS.mu.Lock()
+			size += rootFS.plainSize(f)
+			rootFS.mu.Unlock()
+		}
+		return nil
+	})
+	return size, nil
+}
+
+// FSStats is a breakdown of the content of a filesystem, see Stats
+type FSStats struct {
+	TotalFiles int   // number of files
+	TotalDirs  int   // number of directories, not counting the root
+	TotalBytes int64 // plaintext size of the current content, see LogicalSize
+
+	// Besides the totals, Stats reports symbolic links, which are neither
+	// files nor directories, and splits the stored bytes between the current
+	// content and previous versions, which together make up UsedStorage
+	Symlinks     int   // number of symbolic links
+	StoredBytes  int64 // stored size of the current content of all files
+	VersionBytes int64 // stored size of the previous versions of all files
+
+	MaxDepth         int    // number of path elements of the deepest entry, 0 if empty
+	LargestFile      string // path of the file with the largest plaintext, "" if none
+	LargestFileBytes int64  // plaintext size of LargestFile
+
+	EncryptionEnabled  bool // content is encrypted at rest, see WithEncryption
+	CompressionEnabled bool // content is compressed, see WithCompression
+}
+
+// Stats returns the number of files, directories and symbolic links, and
+// the bytes stored for them. With encryption, StoredBytes includes the
+// overhead of encryption at rest and TotalBytes does not; files are not
+// decrypted to count them. Content shared by WithDeduplication is counted
+// for every file. Of files of equal size, LargestFile is the first in
+// lexical order.
+//
+// The tree is read locked as a whole while it is traversed, and the files
+// are counted at once, so the result is a consistent view even while the
+// filesystem is modified; changes wait until Stats is done.
+func (rootFS *FS) Stats() FSStats {
+	var (
+		stats  FSStats
+		locked []*Dir
+		paths  []string
+		files  []*File
+	)
+	var visit func(dir *Dir, prefix string)
+	visit = func(dir *Dir, prefix string) {
+		// Directories are locked in the order of a walk, see lockDirs
+		dir.mu.RLock()
+		locked = append(locked, dir)
+		for _, name := range dir.sortedChildNames() {
+			path := syspath.Join(prefix, name)
+			stats.MaxDepth = max(stats.MaxDepth, strings.Count(path, "/")+1)
+
+			switch c := dir.Children[name].(type) {
+			case *File:
+				paths = append(paths, path)
+				files = append(files, c)
+			case *Dir:
+				stats.TotalDirs++
+				visit(c, path)
+			case *Symlink:
+				stats.Symlinks++
+			}
+		}
+	}
+	visit(rootFS.dir, "")
+	defer func() {
+		for _, dir := range locked {
+			dir.mu.RUnlock()
+		}
+	}()
+
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	stats.EncryptionEnabled = rootFS.encryptor != nil && rootFS.encryptor.enable
+	stats.CompressionEnabled = rootFS.compress
+	for i, f := range files {
+		size := rootFS.plainSize(f)
+		stats.TotalFiles++
+		stats.StoredBytes += int64(len(f.Content))
+		stats.VersionBytes += f.storedSize() - int64(len(f.Content))
+		stats.TotalBytes += size
+
+		if stats.LargestFile == "" || size > stats.LargestFileBytes {
+			stats.LargestFile = paths[i]
+			stats.LargestFileBytes = size
+		}
+	}
+	return stats
+}
+
+// ModifiedSince returns the paths of all files whose modification time is
+// after t, in lexical order. Expired files are left out.
+func (rootFS *FS) ModifiedSince(t time.Time) ([]string, error) {
+	var paths []string
+	err := rootFS.walk(func(path string, child childI) error {
+		f, ok := child.(*File)
+		if !ok || rootFS.expired(f) {
+			return nil
+		}
+
+		rootFS.mu.Lock()
+		modified := f.ModTime.After(t)
+		rootFS.mu.Unlock()
+
+		if modified {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
 type childI any
 
 type fileInfo struct {
 	name    string
 	size    int64
 	modTime time.Time
+	atime   time.Time
 	mode    fs.FileMode
+	sys     any
+}
+
+// Owner is returned by the Sys method of the fs.FileInfo of files and
+// directories, holding the ownership set with Chown.
+type Owner struct {
+	Uid int
+	Gid int
 }
 
 // base name of the file
@@ -1045,6 +2822,13 @@ func (fi *fileInfo) ModTime() time.Time {
 	return fi.modTime
 }
 
+// AccessTime returns the time the file was last opened or read, see WithNoAtime.
+// It is not part of fs.FileInfo; use a type assertion to an interface with
+// an AccessTime method to get it.
+func (fi *fileInfo) AccessTime() time.Time {
+	return fi.atime
+}
+
 // abbreviation for Mode().IsDir()
 func (fi *fileInfo) IsDir() bool {
 	return fi.mode&fs.ModeDir > 0
@@ -1052,7 +2836,7 @@ func (fi *fileInfo) IsDir() bool {
 
 // underlying data source (can return nil)
 func (fi *fileInfo) Sys() any {
-	return nil
+	return fi.sys
 }
 
 type dirEntry struct {
@@ -1083,6 +2867,19 @@ func NewGzipWriter(w io.Writer) *GzipWriter {
 	}
 }
 
+// NewGzipWriterLevel creates a new gzip writer compressing with the given level,
+// or returns an error wrapping fs.ErrInvalid if the level is invalid
+func NewGzipWriterLevel(w io.Writer, level int) (*GzipWriter, error) {
+	gw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", err, fs.ErrInvalid)
+	}
+	return &GzipWriter{
+		gw: gw,
+		w:  w,
+	}, nil
+}
+
 // GzipWriter is a wrapper around a gzip.Writer that also implements the io.Writer interface
 type GzipWriter struct {
 	gw *gzip.Writer
@@ -1,13 +1,17 @@
 package memfs
 
 import (
+	"bytes"
+	"encoding/gob"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"sync"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -115,6 +119,212 @@ func TestMemFS(t *testing.T) {
 	}
 }
 
+// TestSubEncryption tests writing and reading through a Sub, with and without encryption
+func TestSubEncryption(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"plain":     nil,
+		"encrypted": {WithEncryption([]byte("sub-key"))},
+	} {
+		rootFS := New(opts...)
+		if err := rootFS.MkdirAll("sub", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := rootFS.WriteFile("sub/parent.txt", []byte("from parent"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		sub, err := rootFS.Sub("sub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		subFS := sub.(*FS)
+
+		if err := subFS.WriteFile("child.txt", []byte("from sub"), 0o644); err != nil {
+			t.Fatalf("%s: WriteFile on sub: %v", name, err)
+		}
+
+		content, err := fs.ReadFile(subFS, "parent.txt")
+		if err != nil {
+			t.Fatalf("%s: read through sub: %v", name, err)
+		}
+		if diff := cmp.Diff("from parent", string(content)); diff != "" {
+			t.Fatalf("%s: read through sub mismatch %s", name, diff)
+		}
+
+		content, err = fs.ReadFile(rootFS, "sub/child.txt")
+		if err != nil {
+			t.Fatalf("%s: read from parent: %v", name, err)
+		}
+		if diff := cmp.Diff("from sub", string(content)); diff != "" {
+			t.Fatalf("%s: read from parent mismatch %s", name, diff)
+		}
+
+		// Content written through the sub is encrypted like the rest
+		file, err := rootFS.getFile("sub/child.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypted := string(file.Content) != "from sub"; encrypted != (name == "encrypted") {
+			t.Fatalf("%s: expected content to be encrypted: %v", name, !encrypted)
+		}
+	}
+}
+
+// TestSubStorage tests that a Sub counts against the limits of its parent
+func TestSubStorage(t *testing.T) {
+	rootFS := New(WithMaxStorage(100), WithMaxFiles(3))
+	if err := rootFS.MkdirAll("d", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("top.txt", []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := rootFS.Sub("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subFS := sub.(*FS)
+
+	if err := subFS.WriteFile("big", make([]byte, 500), 0o644); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected the storage limit of the parent to apply, got: %v", err)
+	}
+	if err := subFS.WriteFile("a", make([]byte, 40), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := rootFS.UsedStorage(); got != 50 {
+		t.Fatalf("Expected used storage 50 after writing through the sub, got %d", got)
+	}
+	if got := subFS.UsedStorage(); got != 50 {
+		t.Fatalf("Expected the sub to report the usage of the parent, got %d", got)
+	}
+	if err := subFS.WriteFile("b", []byte("b"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := subFS.WriteFile("c", []byte("c"), 0o644); err == nil {
+		t.Fatal("Expected the file limit of the parent to apply")
+	}
+
+	// Removing everything below the sub keeps the files of the parent
+	if err := subFS.RemoveAll("."); err != nil {
+		t.Fatal(err)
+	}
+	if got := rootFS.UsedStorage(); got != 10 {
+		t.Fatalf("Expected used storage 10 after clearing the sub, got %d", got)
+	}
+	if got := rootFS.Stats().TotalFiles; got != 1 {
+		t.Fatalf("Expected 1 file after clearing the sub, got %d", got)
+	}
+}
+
+// TestSubOptions tests that the hooks and write options of the parent apply
+// to writes through a Sub
+func TestSubOptions(t *testing.T) {
+	var hooked []string
+	rootFS := New(
+		WithWriteHook(func(path string, data []byte) ([]byte, error) {
+			hooked = append(hooked, path)
+			return bytes.ToUpper(data), nil
+		}),
+		WithOpenHook(func(path string, content []byte, err error) ([]byte, error) {
+			return append([]byte(path+": "), content...), err
+		}),
+		WithVersioning(2),
+		WithTTL(time.Hour),
+		WithAutoMkdir(true),
+	)
+	defer rootFS.Close()
+	if err := rootFS.MkdirAll("d", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := rootFS.Sub("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subFS := sub.(*FS)
+
+	for _, content := range []string{"one", "two"} {
+		if err := subFS.WriteFile("new/file.txt", []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, err := rootFS.ReadFile("d/new/file.txt"); err != nil || string(got) != "d/new/file.txt: TWO" {
+		t.Fatalf("Expected the write hook to apply, got %q (%v)", got, err)
+	}
+	if diff := cmp.Diff([]string{"new/file.txt", "new/file.txt"}, hooked); diff != "" {
+		t.Fatalf("Expected the hook to get paths of the sub (-want +got):\n%s", diff)
+	}
+	if versions, err := rootFS.Versions("d/new/file.txt"); err != nil || len(versions) != 1 {
+		t.Fatalf("Expected 1 previous version, got %v (%v)", versions, err)
+	}
+	file, err := rootFS.getFile("d/new/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootFS.mu.Lock()
+	expireAt := file.expireAt
+	rootFS.mu.Unlock()
+	if expireAt.IsZero() {
+		t.Fatal("Expected the file to expire")
+	}
+
+	if got, err := fs.ReadFile(subFS, "new/file.txt"); err != nil || string(got) != "new/file.txt: TWO" {
+		t.Fatalf("Expected the open hook to apply, got %q (%v)", got, err)
+	}
+}
+
+// TestSubConcurrent tests changing files through a Sub while the parent
+// reads them, which is meant to be run with the race detector
+func TestSubConcurrent(t *testing.T) {
+	rootFS := New()
+	if err := rootFS.MkdirAll("d", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("d/file", make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := rootFS.Sub("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subFS := sub.(*FS)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if err := subFS.Truncate("file", int64(i)); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := subFS.Chmod("file", fs.FileMode(0o600+i%2)); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := subFS.Chown("file", i, i); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if _, err := rootFS.Stat("d/file"); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := fs.ReadDir(rootFS, "d"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
 func TestOpenHook(t *testing.T) {
 	openHook := func(path string, content []byte, origError error) ([]byte, error) {
 		if path == "foo/bar/override" {
@@ -156,6 +366,175 @@ func TestOpenHook(t *testing.T) {
 	}
 }
 
+// TestOpenHookKeepsStoredContent tests that the hook's content is not written back
+func TestOpenHookKeepsStoredContent(t *testing.T) {
+	openHook := func(path string, content []byte, origError error) ([]byte, error) {
+		if len(content) > 0 {
+			content[0] = 'X'
+		}
+		return append(content, " (hooked)"...), origError
+	}
+
+	rootFS := New(WithOpenHook(openHook), WithEncryption([]byte("hook-key")))
+
+	if err := rootFS.WriteFile("file.txt", []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		content, err := fs.ReadFile(rootFS, "file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(string(content), "Xriginal (hooked)"); diff != "" {
+			t.Fatalf("open %d: hook read mismatch %s", i+1, diff)
+		}
+	}
+
+	// The stored content is still the original, encrypted content
+	file, err := rootFS.getFile("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := rootFS.readContent(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(content), "original"); diff != "" {
+		t.Fatalf("stored content mismatch %s", diff)
+	}
+}
+
+// TestWriteHook tests that the write hook transforms content before it is stored
+func TestWriteHook(t *testing.T) {
+	var paths []string
+	errRejected := errors.New("rejected")
+	writeHook := func(path string, data []byte) ([]byte, error) {
+		paths = append(paths, path)
+		if bytes.Contains(data, []byte("reject")) {
+			return nil, errRejected
+		}
+		return bytes.ToUpper(data), nil
+	}
+
+	rootFS := New(WithWriteHook(writeHook), WithEncryption([]byte("hook-key")))
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := rootFS.Create("dir/written.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hel")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("lo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"dir/file.txt", "dir/written.txt"} {
+		content, err := rootFS.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(string(content), "HELLO"); diff != "" {
+			t.Fatalf("%s: content mismatch %s", path, diff)
+		}
+	}
+	if diff := cmp.Diff(paths, []string{"dir/file.txt", "dir/written.txt"}); diff != "" {
+		t.Fatalf("hook paths mismatch %s", diff)
+	}
+
+	// Storage is accounted for with the size of the stored content
+	if used, want := rootFS.UsedStorage(), 2*int64(rootFS.encryptor.ciphertextSize(5)); used != want {
+		t.Fatalf("Expected %d bytes used, got %d", want, used)
+	}
+
+	// A failing hook fails the write
+	if err := rootFS.WriteFile("dir/file.txt", []byte("reject"), 0644); !errors.Is(err, errRejected) {
+		t.Fatalf("Expected the hook error, got: %v", err)
+	}
+	if content, _ := rootFS.ReadFile("dir/file.txt"); string(content) != "HELLO" {
+		t.Fatalf("Expected a rejected write to keep the file, got %q", content)
+	}
+	w, err = rootFS.Create("dir/written.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("reject")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); !errors.Is(err, errRejected) {
+		t.Fatalf("Expected the hook error on close, got: %v", err)
+	}
+	if info, err := rootFS.Stat("dir/written.txt"); err != nil || info.Size() != 0 {
+		t.Fatalf("Expected an empty file after a rejected close, got %v, %v", info, err)
+	}
+	if used, want := rootFS.UsedStorage(), int64(rootFS.encryptor.ciphertextSize(5)); used != want {
+		t.Fatalf("Expected %d bytes used, got %d", want, used)
+	}
+}
+
+// TestOpenAt tests opening a file with the read offset in the middle
+func TestOpenAt(t *testing.T) {
+	key := []byte("openat-key")
+	for name, opts := range map[string][]Option{
+		"encrypted": {WithEncryption(key)},
+		"chunked":   {WithEncryption(key), WithChunkedEncryption()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rootFS := New(opts...)
+
+			if err := rootFS.WriteFile("foo", []byte("0123456789"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := rootFS.OpenAt("foo", 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			buf := make([]byte, 3)
+			n, err := f.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(buf[:n]), "456"); diff != "" {
+				t.Fatalf("read at offset mismatch %s", diff)
+			}
+
+			// An offset at the end of the file is at EOF
+			f, err = rootFS.OpenAt("foo", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.Read(buf); err != io.EOF {
+				t.Fatalf("Expected io.EOF, got: %v", err)
+			}
+
+			for _, offset := range []int64{-1, 11} {
+				if _, err := rootFS.OpenAt("foo", offset); !errors.Is(err, fs.ErrInvalid) {
+					t.Fatalf("Expected fs.ErrInvalid for offset %d, got: %v", offset, err)
+				}
+			}
+			if _, err := rootFS.OpenAt(".", 0); err == nil {
+				t.Fatal("Expected error when opening a directory at an offset")
+			}
+			if _, err := rootFS.OpenAt("missing", 0); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("Expected fs.ErrNotExist, got: %v", err)
+			}
+		})
+	}
+}
+
 func TestSeek(t *testing.T) {
 	rootFS := New()
 
@@ -331,6 +710,308 @@ func TestSaveLoad(t *testing.T) {
 	}
 }
 
+// TestSaveLoadUsedStorage tests that UsedStorage is accurate right after loading.
+func TestSaveLoadUsedStorage(t *testing.T) {
+	rootFS := New()
+
+	if err := rootFS.MkdirAll("foo/bar", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	testFiles := map[string][]byte{
+		"foo/file1.txt":     []byte("content1"),
+		"foo/bar/file2.txt": []byte("content2"),
+		"root.txt":          []byte("root content"),
+	}
+
+	var expectedSize int64
+	for path, content := range testFiles {
+		if err := rootFS.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		expectedSize += int64(len(content))
+	}
+
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := loadedFS.UsedStorage(); got != expectedSize {
+		t.Fatalf("expected used storage %d after load, got %d", expectedSize, got)
+	}
+
+	buf.Reset()
+	if err := rootFS.CompressAndSaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedFS, err = DecompressAndLoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := loadedFS.UsedStorage(); got != expectedSize {
+		t.Fatalf("expected used storage %d after compressed load, got %d", expectedSize, got)
+	}
+}
+
+// TestStats tests the breakdown of the filesystem returned by Stats
+func TestStats(t *testing.T) {
+	key := []byte("stats-key")
+	rootFS := New(WithEncryption(key), WithVersioning(2))
+
+	if err := rootFS.MkdirAll("a/b", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"a/one.txt", "a/b/two.txt", "a/b/two.txt"} {
+		if err := rootFS.WriteFile(path, []byte("12345"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rootFS.Symlink("a/one.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed := int64(rootFS.encryptor.ciphertextSize(5))
+	want := FSStats{
+		TotalFiles:   2,
+		TotalDirs:    2,
+		TotalBytes:   10,
+		Symlinks:     1,
+		StoredBytes:  2 * sealed,
+		VersionBytes: sealed,
+
+		MaxDepth:         3,
+		LargestFile:      "a/b/two.txt",
+		LargestFileBytes: 5,
+
+		EncryptionEnabled: true,
+	}
+	if diff := cmp.Diff(want, rootFS.Stats()); diff != "" {
+		t.Fatalf("stats mismatch (-want +got):\n%s", diff)
+	}
+	if got := rootFS.UsedStorage(); got != want.StoredBytes+want.VersionBytes {
+		t.Fatalf("expected used storage %d, got %d", want.StoredBytes+want.VersionBytes, got)
+	}
+}
+
+// TestStatsConsistent tests that Stats sees files that are moved around
+// concurrently exactly once
+func TestStatsConsistent(t *testing.T) {
+	rootFS := New()
+	for _, dir := range []string{"a", "m/n", "z"} {
+		if err := rootFS.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const files = 10
+	for i := range files {
+		if err := rootFS.WriteFile(fmt.Sprintf("z/%d", i), []byte("content"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 200 {
+			for i := range files {
+				for _, r := range [][2]string{{"z", "a"}, {"a", "m/n"}, {"m/n", "z"}} {
+					if err := rootFS.Rename(fmt.Sprintf("%s/%d", r[0], i), fmt.Sprintf("%s/%d", r[1], i)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		stats := rootFS.Stats()
+		if stats.TotalFiles != files || stats.TotalBytes != files*7 {
+			t.Fatalf("Expected %d files of 7 bytes, got %d files and %d bytes", files, stats.TotalFiles, stats.TotalBytes)
+		}
+	}
+}
+
+// TestUsedStorageUnlimited tests that storage usage is tracked without a limit
+func TestUsedStorageUnlimited(t *testing.T) {
+	rootFS := New()
+
+	if err := rootFS.MkdirAll("dir", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("a.txt", []byte("12345"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := rootFS.UsedStorage(); got != 5 {
+		t.Fatalf("expected used storage 5 after WriteFile, got %d", got)
+	}
+
+	w, err := rootFS.Create("dir/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := rootFS.UsedStorage(); got != 8 {
+		t.Fatalf("expected used storage 8 after FileWriter, got %d", got)
+	}
+
+	// Overwriting releases the old content
+	if err := rootFS.WriteFile("a.txt", []byte("1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := rootFS.UsedStorage(); got != 4 {
+		t.Fatalf("expected used storage 4 after overwrite, got %d", got)
+	}
+
+	if err := rootFS.Remove("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := rootFS.UsedStorage(); got != 3 {
+		t.Fatalf("expected used storage 3 after Remove, got %d", got)
+	}
+
+	if err := rootFS.RemoveAll("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if got := rootFS.UsedStorage(); got != 0 {
+		t.Fatalf("expected used storage 0 after RemoveAll, got %d", got)
+	}
+}
+
+// TestSaveLoadMaxStorage tests that the storage limit survives a save/load round trip.
+func TestSaveLoadMaxStorage(t *testing.T) {
+	rootFS := New(WithMaxStorage(20))
+
+	if err := rootFS.WriteFile("file.txt", []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loadedFS.maxStorage != 20 {
+		t.Fatalf("expected max storage 20 after load, got %d", loadedFS.maxStorage)
+	}
+	if got := loadedFS.UsedStorage(); got != 10 {
+		t.Fatalf("expected used storage 10 after load, got %d", got)
+	}
+
+	err = loadedFS.WriteFile("other.txt", []byte("0123456789abc"), 0o644)
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("expected storage limit error after load, got: %v", err)
+	}
+}
+
+// TestLoadLegacyFormat tests loading a stream that contains a bare Dir.
+func TestLoadLegacyFormat(t *testing.T) {
+	rootFS := New(WithMaxStorage(20))
+
+	if err := rootFS.WriteFile("file.txt", []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(rootFS.dir); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loadedFS.maxStorage != -1 {
+		t.Fatalf("expected unlimited storage for legacy format, got %d", loadedFS.maxStorage)
+	}
+
+	content, err := fs.ReadFile(loadedFS, "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "content" {
+		t.Fatalf("expected 'content', got %q", content)
+	}
+}
+
+var _ fs.StatFS = (*FS)(nil)
+var _ fs.ReadFileFS = (*FS)(nil)
+
+// TestStat tests Stat on the filesystem itself.
+func TestStat(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("stat-key")))
+
+	if err := rootFS.MkdirAll("foo", 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("foo/bar.txt", []byte("0123456789"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := rootFS.Stat("foo/bar.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "bar.txt" || info.Size() != 10 || info.Mode() != 0o640 || info.IsDir() {
+		t.Fatalf("unexpected file info: %s %d %v", info.Name(), info.Size(), info.Mode())
+	}
+
+	info, err = rootFS.Stat("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0o750 {
+		t.Fatalf("unexpected dir info: %v", info.Mode())
+	}
+
+	if _, err := rootFS.Stat("."); err != nil {
+		t.Fatalf("expected root to be stat-able, got: %v", err)
+	}
+
+	_, err = rootFS.Stat("foo/missing.txt")
+	var pathErr *fs.PathError
+	if !errors.Is(err, fs.ErrNotExist) || !errors.As(err, &pathErr) {
+		t.Fatalf("expected *fs.PathError wrapping fs.ErrNotExist, got: %v", err)
+	}
+
+	if _, err := rootFS.Stat("../invalid"); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("expected fs.ErrInvalid, got: %v", err)
+	}
+
+	// fs.Stat dispatches to the same result
+	info, err = fs.Stat(rootFS, "foo/bar.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 10 {
+		t.Fatalf("expected size 10 from fs.Stat, got %d", info.Size())
+	}
+}
+
 // TestSeekWithClosedFile tests that seeking on a closed file returns an error.
 func TestSeekWithClosedFile(t *testing.T) {
 	rootFS := New()
@@ -547,3 +1228,290 @@ func TestOpenFile2(t *testing.T) {
 	}
 
 }
+
+// TestModifiedSince tests listing files changed after a cutoff
+func TestModifiedSince(t *testing.T) {
+	rootFS := New()
+
+	if err := rootFS.MkdirAll("dir", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	times := map[string]time.Time{
+		"old.txt":     base,
+		"dir/old.txt": base.Add(time.Hour),
+		"cutoff.txt":  base.Add(2 * time.Hour),
+		"dir/new.txt": base.Add(3 * time.Hour),
+		"new.txt":     base.Add(4 * time.Hour),
+	}
+	for path, mtime := range times {
+		if err := rootFS.WriteFile(path, []byte(path), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := rootFS.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := rootFS.ModifiedSince(base.Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"dir/new.txt", "new.txt"}, paths); diff != "" {
+		t.Fatalf("modified paths mismatch %s", diff)
+	}
+
+	// Writing a file updates its modification time
+	if err := rootFS.WriteFile("old.txt", []byte("rewritten"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	paths, err = rootFS.ModifiedSince(base.Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"dir/new.txt", "new.txt", "old.txt"}, paths); diff != "" {
+		t.Fatalf("modified paths after write mismatch %s", diff)
+	}
+}
+
+// TestAutoMkdir tests creating missing parent directories on write
+func TestAutoMkdir(t *testing.T) {
+	rootFS := New()
+	if err := rootFS.WriteFile("a/b/c.txt", []byte("c"), 0o644); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist without WithAutoMkdir, got: %v", err)
+	}
+
+	rootFS = New(WithAutoMkdir(true))
+	if err := rootFS.WriteFile("a/b/c.txt", []byte("c"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := rootFS.Create("x/y/z.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{"a", "a/b", "x/y"} {
+		info, err := rootFS.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != fs.ModeDir|0o755 {
+			t.Fatalf("Expected %s to have mode 0755, got %v", dir, info.Mode())
+		}
+	}
+
+	rootFS = New(WithAutoMkdir(true), WithAutoMkdirPerm(0o700))
+	if err := rootFS.WriteFile("private/file.txt", []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := rootFS.Stat("private")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != fs.ModeDir|0o700 {
+		t.Fatalf("Expected mode 0700, got %v", info.Mode())
+	}
+
+	// A file in the way of a parent directory is still an error
+	if err := rootFS.WriteFile("private/file.txt/nested.txt", []byte("x"), 0o600); err == nil {
+		t.Fatal("Expected error when a parent is a file")
+	}
+
+	// The directory of the file is not left locked
+	done := make(chan error, 1)
+	go func() {
+		if _, err := rootFS.Create("private/file.txt/nested.txt"); err == nil {
+			done <- errors.New("expected error when a parent is a file")
+			return
+		}
+		if err := rootFS.WriteFile("private/other.txt", []byte("x"), 0o600); err != nil {
+			done <- err
+			return
+		}
+		done <- rootFS.WriteFile("private/sub/file.txt", []byte("x"), 0o600)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out writing after a failed auto mkdir")
+	}
+}
+
+// TestReadFile tests reading whole files without opening them
+func TestReadFile(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"plain":     nil,
+		"encrypted": {WithEncryption([]byte("readfile-key"))},
+	} {
+		rootFS := New(opts...)
+		if err := rootFS.MkdirAll("dir", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := rootFS.WriteFile("dir/file.txt", []byte("content"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := rootFS.WriteFile("empty.txt", nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := rootFS.ReadFile("dir/file.txt")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if diff := cmp.Diff("content", string(content)); diff != "" {
+			t.Fatalf("%s: content mismatch %s", name, diff)
+		}
+
+		// The result is a copy
+		content[0] = 'X'
+		content, err = rootFS.ReadFile("dir/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff("content", string(content)); diff != "" {
+			t.Fatalf("%s: stored content changed %s", name, diff)
+		}
+
+		content, err = rootFS.ReadFile("empty.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content == nil || len(content) != 0 {
+			t.Fatalf("%s: expected empty non-nil content, got %#v", name, content)
+		}
+
+		if _, err := rootFS.ReadFile("dir"); err == nil {
+			t.Fatalf("%s: expected error when reading a directory", name)
+		}
+		if _, err := rootFS.ReadFile("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("%s: expected fs.ErrNotExist, got: %v", name, err)
+		}
+	}
+}
+
+// TestExistsIsDir tests checking paths with Exists, IsDir and IsFile
+func TestExistsIsDir(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("exists-key")))
+	if err := rootFS.MkdirAll("dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Symlink("dir", "dirlink"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Symlink("missing", "dangling"); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]bool{
+		".":            true,
+		"dir":          true,
+		"dir/sub":      true,
+		"dir/file.txt": true,
+		"dirlink":      true,
+		"missing":      false,
+		"dir/missing":  false,
+		"dangling":     false,
+		"../dir":       false,
+	} {
+		if got := rootFS.Exists(path); got != want {
+			t.Errorf("Exists(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	for path, want := range map[string]bool{
+		".":            true,
+		"dir/sub":      true,
+		"dirlink":      true,
+		"dir/file.txt": false,
+	} {
+		got, err := rootFS.IsDir(path)
+		if err != nil {
+			t.Fatalf("IsDir(%q): %v", path, err)
+		}
+		if got != want {
+			t.Errorf("IsDir(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if _, err := rootFS.IsDir("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for a missing path, got: %v", err)
+	}
+	if _, err := rootFS.IsDir("/dir"); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid for an invalid path, got: %v", err)
+	}
+
+	if err := rootFS.Symlink("dir/file.txt", "filelink"); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{
+		".":            false,
+		"dir/sub":      false,
+		"dirlink":      false,
+		"dir/file.txt": true,
+		"filelink":     true,
+	} {
+		got, err := rootFS.IsFile(path)
+		if err != nil {
+			t.Fatalf("IsFile(%q): %v", path, err)
+		}
+		if got != want {
+			t.Errorf("IsFile(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if _, err := rootFS.IsFile("dangling"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for a dangling link, got: %v", err)
+	}
+}
+
+// TestMkdir tests creating single directories with Mkdir
+func TestMkdir(t *testing.T) {
+	rootFS := New()
+
+	before := time.Now()
+	if err := rootFS.Mkdir("dir", 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Mkdir("dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := rootFS.Stat("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0750 {
+		t.Fatalf("Expected a directory with mode 0750, got %v", info.Mode())
+	}
+	if info.ModTime().Before(before) {
+		t.Fatalf("Expected the modification time to be set, got %v", info.ModTime())
+	}
+
+	if err := rootFS.Mkdir("missing/sub", 0755); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for a missing parent, got: %v", err)
+	}
+	if rootFS.Exists("missing") {
+		t.Fatal("Expected Mkdir not to create the parent")
+	}
+
+	if err := rootFS.WriteFile("file", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{".", "dir", "dir/sub", "file"} {
+		if err := rootFS.Mkdir(path, 0755); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("Expected fs.ErrExist for %s, got: %v", path, err)
+		}
+	}
+
+	if err := rootFS.Mkdir("/abs", 0755); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid for an invalid path, got: %v", err)
+	}
+}
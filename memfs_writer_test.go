@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"compress/gzip"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"strings"
 	"sync"
 	"testing"
+	"testing/iotest"
 )
 
 // TestFileWriter tests the FileWriter implementation
@@ -184,6 +186,193 @@ func TestOpenFile(t *testing.T) {
 	}
 }
 
+// TestOpenFileAppend tests appending to existing files with O_APPEND
+func TestOpenFileAppend(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("append-key")))
+
+	if err := rootFS.WriteFile("log.txt", []byte("line1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chunk := range []string{"line2\n", "line3\n"} {
+		f, err := rootFS.OpenFile("log.txt", os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw, ok := f.(*FileWriter)
+		if !ok {
+			t.Fatalf("Expected *FileWriter for O_APPEND, got %T", f)
+		}
+		if _, err := fw.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name() != "log.txt" || info.Size() == 0 {
+			t.Fatalf("Expected the name and size written so far, got %s, %d", info.Name(), info.Size())
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := fs.ReadFile(rootFS, "log.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "line1\nline2\nline3\n" {
+		t.Fatalf("Expected appended content, got %q", content)
+	}
+
+	// O_APPEND|O_CREATE creates missing files
+	f, err := rootFS.OpenFile("new.log", os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw := f.(*FileWriter)
+	if _, err := fw.Write([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err = fs.ReadFile(rootFS, "new.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "first" {
+		t.Fatalf("Expected 'first', got %q", content)
+	}
+}
+
+// TestWriteReader tests writing a file from an io.Reader
+func TestWriteReader(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("reader-key")), WithMaxStorage(1<<20))
+
+	content := bytes.Repeat([]byte("streamed "), 10000)
+	if err := rootFS.WriteReader("big.txt", bytes.NewReader(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := rootFS.ReadFile("big.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("Content mismatch")
+	}
+	info, err := rootFS.Stat("big.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected mode 0600, got %v", info.Mode())
+	}
+	if used, want := rootFS.UsedStorage(), int64(rootFS.encryptor.ciphertextSize(len(content))); used != want {
+		t.Fatalf("Expected %d bytes used, got %d", want, used)
+	}
+
+	// A failing reader leaves no partial file behind
+	errBroken := errors.New("broken")
+	r := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errBroken))
+	if err := rootFS.WriteReader("broken.txt", r, 0644); !errors.Is(err, errBroken) {
+		t.Fatalf("Expected the read error, got: %v", err)
+	}
+	if _, err := rootFS.Stat("broken.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected the partial file to be removed, got: %v", err)
+	}
+
+	// Storage limits apply while streaming
+	if err := rootFS.WriteReader("huge.txt", bytes.NewReader(make([]byte, 2<<20)), 0644); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected the storage limit to be exceeded, got: %v", err)
+	}
+	if used, want := rootFS.UsedStorage(), int64(rootFS.encryptor.ciphertextSize(len(content))); used != want {
+		t.Fatalf("Expected %d bytes used after failed writes, got %d", want, used)
+	}
+}
+
+// TestOpenFileExclusive tests that O_CREATE|O_EXCL fails for existing files
+func TestOpenFileExclusive(t *testing.T) {
+	rootFS := New()
+
+	f, err := rootFS.OpenFile("lock.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Expected exclusive create of a new file to succeed, got: %v", err)
+	}
+	if err := f.(*FileWriter).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = rootFS.OpenFile("lock.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("Expected fs.ErrExist, got: %v", err)
+	}
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "lock.txt" {
+		t.Fatalf("Expected *fs.PathError for lock.txt, got: %#v", err)
+	}
+
+	// Without O_CREATE, O_EXCL has no effect
+	f, err = rootFS.OpenFile("lock.txt", os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Expected O_EXCL without O_CREATE to succeed, got: %v", err)
+	}
+	if err := f.(*FileWriter).Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOpenFileExclusiveConcurrent tests that only one of several concurrent
+// exclusive creates of the same file succeeds
+func TestOpenFileExclusiveConcurrent(t *testing.T) {
+	rootFS := New(WithAutoMkdir(true))
+
+	for i := range 200 {
+		path := fmt.Sprintf("locks/%d.lock", i)
+		const goroutines = 8
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			created int
+		)
+		for range goroutines {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f, err := rootFS.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+				if errors.Is(err, fs.ErrExist) {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				f.Close()
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		if created != 1 {
+			t.Fatalf("Expected exactly one exclusive create of %s to succeed, got %d", path, created)
+		}
+	}
+
+	// A dangling symbolic link is in the way as well
+	if err := rootFS.Symlink("missing", "link"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rootFS.OpenFile("link", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("Expected fs.ErrExist for a symbolic link, got: %v", err)
+	}
+	if rootFS.Exists("missing") {
+		t.Fatal("Expected the target of the symbolic link not to be created")
+	}
+}
+
 // TestConcurrentAccess tests concurrent access to the filesystem
 func TestConcurrentAccess(t *testing.T) {
 	rootFS := New()
@@ -241,6 +430,36 @@ func TestConcurrentAccess(t *testing.T) {
 	wg.Wait()
 }
 
+// BenchmarkConcurrentReads measures lookups and listings of a nested
+// directory from many goroutines, which only share read locks of the
+// directories along the path
+func BenchmarkConcurrentReads(b *testing.B) {
+	rootFS := New()
+	if err := rootFS.MkdirAll("a/b/c", 0755); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := rootFS.WriteFile(fmt.Sprintf("a/b/c/%d.txt", i), []byte("data"), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := rootFS.Stat(fmt.Sprintf("a/b/c/%d.txt", i%100)); err != nil {
+				b.Fatal(err)
+			}
+			if i%10 == 0 {
+				if _, err := fs.ReadDir(rootFS, "a/b/c"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			i++
+		}
+	})
+}
+
 // TestMaxStorageLimits tests the behavior when approaching and exceeding max storage limits
 func TestMaxStorageLimits(t *testing.T) {
 	// Test with very tight storage limit
@@ -305,6 +524,78 @@ func TestMaxStorageLimits(t *testing.T) {
 	}
 }
 
+// TestCreateWithSize tests reserving storage up front
+func TestCreateWithSize(t *testing.T) {
+	rootFS := New(WithMaxStorage(20))
+
+	err := rootFS.WriteFile("small.txt", []byte("1234567890"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reservation over the limit fails before anything is written
+	_, err = rootFS.CreateWithSize("big.txt", 11, 0644)
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected storage limit error, got: %v", err)
+	}
+	if _, err := rootFS.get("big.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected big.txt not to be created, got: %v", err)
+	}
+	if got := rootFS.UsedStorage(); got != 10 {
+		t.Fatalf("Expected used storage 10, got %d", got)
+	}
+
+	// Reservation within the limit streams without limit errors
+	fw, err := rootFS.CreateWithSize("reserved.txt", 8, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rootFS.UsedStorage(); got != 18 {
+		t.Fatalf("Expected used storage 18 after reservation, got %d", got)
+	}
+
+	// Other writers cannot use the reserved space
+	err = rootFS.WriteFile("other.txt", []byte("abc"), 0644)
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected storage limit error, got: %v", err)
+	}
+
+	for _, chunk := range []string{"12", "345", "678"} {
+		if _, err := fw.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write within reservation failed: %v", err)
+		}
+	}
+	if got := rootFS.UsedStorage(); got != 18 {
+		t.Fatalf("Expected used storage 18 while writing, got %d", got)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := fs.ReadFile(rootFS, "reserved.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "12345678" {
+		t.Fatalf("Expected content %q, got %q", "12345678", content)
+	}
+
+	// Unused reservation is released on Close
+	fw, err = rootFS.CreateWithSize("partial.txt", 2, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := rootFS.UsedStorage(); got != 19 {
+		t.Fatalf("Expected used storage 19 after Close, got %d", got)
+	}
+}
+
 // TestGzipWriter tests the GzipWriter implementation
 func TestGzipWriter(t *testing.T) {
 	// Set up a buffer to capture the output
@@ -537,3 +828,276 @@ func TestFS_OpenFile(t *testing.T) {
 		t.Fatalf("Expected content %q, got %q", "Initial content", string(content))
 	}
 }
+
+// TestOpenFileReadWrite tests reading back writes through an O_RDWR handle
+func TestOpenFileReadWrite(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("rdwr-key")), WithMaxStorage(1000))
+
+	f, err := rootFS.OpenFile("data.txt", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw, ok := f.(io.ReadWriteSeeker)
+	if !ok {
+		t.Fatalf("Expected io.ReadWriteSeeker for O_RDWR, got %T", f)
+	}
+
+	if _, err := rw.Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello world" {
+		t.Fatalf("Expected 'hello world', got %q", content)
+	}
+
+	// Overwrite in the middle and extend past the end
+	if _, err := rw.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.Write([]byte("there, all")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err = fs.ReadFile(rootFS, "data.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello there, all" {
+		t.Fatalf("Expected 'hello there, all', got %q", content)
+	}
+
+	// The stored content is encrypted again and accounted for at its sealed size
+	if rootFS.usedStorage != int64(rootFS.encryptor.ciphertextSize(len(content))) {
+		t.Fatalf("Expected used storage %d, got %d", rootFS.encryptor.ciphertextSize(len(content)), rootFS.usedStorage)
+	}
+
+	// An existing file opens at offset 0 and O_APPEND writes to the end
+	f, err = rootFS.OpenFile("data.txt", os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw = f.(io.ReadWriteSeeker)
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(rw, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("Expected 'hello', got %q", buf)
+	}
+	if _, err := rw.Write([]byte("!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err = fs.ReadFile(rootFS, "data.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello there, all!" {
+		t.Fatalf("Expected 'hello there, all!', got %q", content)
+	}
+}
+
+// TestMaxFileSize tests the per-file size limit
+func TestMaxFileSize(t *testing.T) {
+	rootFS := New(WithMaxFileSize(10), WithEncryption([]byte("size-key")))
+
+	// The limit applies to the plaintext, not the larger ciphertext
+	if err := rootFS.WriteFile("a.txt", []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("b.txt", []byte("0123456789"), 0644); err != nil {
+		t.Fatalf("Expected a second file within the limit to succeed, got: %v", err)
+	}
+	if err := rootFS.WriteFile("c.txt", []byte("0123456789A"), 0644); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid for a file over the limit, got: %v", err)
+	}
+
+	// Streaming writes are accepted until the file would cross the limit
+	w, err := rootFS.Create("stream.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range []string{"0123", "4567", "89"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Expected write of %q to succeed, got: %v", chunk, err)
+		}
+	}
+	if _, err := w.Write([]byte("X")); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid for the write crossing the limit, got: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := fs.ReadFile(rootFS, "stream.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "0123456789" {
+		t.Fatalf("Expected the accepted writes to be kept, got %q", content)
+	}
+
+	if err := rootFS.Truncate("a.txt", 11); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid when truncating beyond the limit, got: %v", err)
+	}
+	if _, err := rootFS.CreateWithSize("big.txt", 11, 0644); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid when reserving beyond the limit, got: %v", err)
+	}
+}
+
+// TestMaxFiles tests the limit on the number of files
+func TestMaxFiles(t *testing.T) {
+	rootFS := New(WithMaxFiles(2))
+
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := rootFS.Create("b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rootFS.WriteFile("c.txt", []byte("c"), 0644); !errors.Is(err, ErrTooManyFiles) {
+		t.Fatalf("Expected ErrTooManyFiles for a file over the limit, got: %v", err)
+	}
+	if _, err := rootFS.TempFile("", "tmp*"); !errors.Is(err, ErrTooManyFiles) {
+		t.Fatalf("Expected ErrTooManyFiles for a temporary file over the limit, got: %v", err)
+	}
+	if err := rootFS.CopyDir("dir", "copy"); !errors.Is(err, ErrTooManyFiles) {
+		t.Fatalf("Expected ErrTooManyFiles when copying over the limit, got: %v", err)
+	}
+	if _, err := rootFS.Stat("copy"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected the failed copy not to be created, got: %v", err)
+	}
+
+	// Overwriting, directories and symbolic links do not count
+	if err := rootFS.WriteFile("b.txt", []byte("b"), 0644); err != nil {
+		t.Fatalf("Expected overwriting a file to succeed, got: %v", err)
+	}
+	if err := rootFS.MkdirAll("other", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Symlink("b.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removed files free their slot
+	if err := rootFS.RemoveAll("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("c.txt", []byte("c"), 0644); err != nil {
+		t.Fatalf("Expected a file to fit after removing one, got: %v", err)
+	}
+	if err := rootFS.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.CopyFile("c.txt", "d.txt"); err != nil {
+		t.Fatalf("Expected a copy to fit after removing a file, got: %v", err)
+	}
+	if err := rootFS.CopyFile("c.txt", "e.txt"); !errors.Is(err, ErrTooManyFiles) {
+		t.Fatalf("Expected ErrTooManyFiles for a copy over the limit, got: %v", err)
+	}
+
+	if err := rootFS.Clone().WriteFile("e.txt", []byte("e"), 0644); !errors.Is(err, ErrTooManyFiles) {
+		t.Fatalf("Expected the clone to keep the limit, got: %v", err)
+	}
+}
+
+// TestWriteFileAtomic tests that readers never see a partly written file
+func TestWriteFileAtomic(t *testing.T) {
+	key := []byte("atomic-key")
+	rootFS := New(WithPerFileKeys(key), WithVersioning(1))
+	contents := [][]byte{
+		bytes.Repeat([]byte("a"), 4096),
+		bytes.Repeat([]byte("b"), 8192),
+	}
+	if err := rootFS.WriteFileAtomic("file.txt", contents[0], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			got, err := rootFS.ReadFile("file.txt")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(got, contents[0]) && !bytes.Equal(got, contents[1]) {
+				t.Errorf("Read partial content of %d bytes", len(got))
+				return
+			}
+		}
+	}()
+	for i := range 200 {
+		if err := rootFS.WriteFileAtomic("file.txt", contents[i%2], 0600); err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	info, err := rootFS.Stat("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+	versions, err := rootFS.Versions("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("Expected 1 previous version, got %d", len(versions))
+	}
+	if used, want := rootFS.UsedStorage(), rootFS.encryptor.ciphertextSize(4096)+rootFS.encryptor.ciphertextSize(8192); used != int64(want) {
+		t.Fatalf("Expected %d bytes used, got %d", want, used)
+	}
+
+	// Content written through a symbolic link is encrypted for its target
+	if err := rootFS.Symlink("file.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFileAtomic("link", []byte("via link"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := rootFS.ReadFile("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "via link" {
+		t.Fatalf("Expected 'via link', got %q", got)
+	}
+
+	if err := rootFS.WriteFileAtomic("missing/file.txt", []byte("x"), 0644); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist without the parent directory, got: %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package memfs
+
+import (
+	"fmt"
+	"io/fs"
+	syspath "path"
+	"strings"
+	"time"
+)
+
+// MoveAll moves the file, directory or symbolic link src to dst, like
+// mv -f. If dst does not exist, src is moved there as a whole, with
+// everything below it; the parent directory of dst must exist. If both src
+// and dst are directories, the contents of src are merged into dst: entries
+// of src replace files of the same name in dst, directories are merged
+// recursively, and src is removed once it is empty. A file or symbolic link
+// at dst is replaced by a file or symbolic link, but a directory never
+// replaces a non-directory or vice versa; that fails with an error wrapping
+// fs.ErrExist. A symbolic link at src or dst is moved or replaced itself,
+// not its target.
+//
+// MoveAll is not atomic when directories are merged: the entries are moved
+// one by one, each like with Rename, so concurrent readers may observe a
+// partly moved tree. If an error occurs, the entries moved so far stay at
+// dst. Content is moved, not copied, so the storage usage only shrinks by
+// the files that are replaced; encrypted content that is bound to its path
+// is encrypted again for the new path.
+func (rootFS *FS) MoveAll(src, dst string) error {
+	src, dst, err := rootFS.resolveMove(src, dst)
+	if err != nil || src == dst {
+		return err
+	}
+	return rootFS.moveAll(src, dst)
+}
+
+// Rename renames the file, directory or symbolic link oldpath to newpath,
+// like os.Rename. A file or symbolic link at newpath is replaced, but a
+// directory never replaces anything or is replaced; that fails with an
+// error wrapping fs.ErrExist. Unlike MoveAll, directories are not merged.
+// A symbolic link at oldpath or newpath is renamed or replaced itself, not
+// its target; the parent directory of newpath must exist.
+//
+// Like os.Rename, Rename is atomic: concurrent readers find the entry under
+// either oldpath or newpath, never under neither. The parent directories
+// are looked up before they are locked, though, so a Rename racing with the
+// removal or renaming of one of them may apply to the directory that was
+// taken away.
+//
+// Watchers receive an event with OpRename for oldpath, followed by one with
+// OpCreate for newpath. Encrypted content that is bound to its path is
+// encrypted again for the new path.
+func (rootFS *FS) Rename(oldpath, newpath string) error {
+	src, dst, err := rootFS.resolveMove(oldpath, newpath)
+	if err != nil || src == dst {
+		return err
+	}
+	return rootFS.move(src, dst)
+}
+
+// resolveMove validates the paths src and dst of a move and resolves them,
+// without following a symbolic link in the final element
+func (rootFS *FS) resolveMove(src, dst string) (string, string, error) {
+	if !fs.ValidPath(src) || src == "." {
+		return "", "", fmt.Errorf("invalid path: %s: %w", src, fs.ErrInvalid)
+	}
+	if !fs.ValidPath(dst) || dst == "." {
+		return "", "", fmt.Errorf("invalid path: %s: %w", dst, fs.ErrInvalid)
+	}
+
+	if err := rootFS.checkWritable(dst); err != nil {
+		return "", "", err
+	}
+
+	src, err := rootFS.resolve(src, false)
+	if err != nil {
+		return "", "", err
+	}
+	dst, err = rootFS.resolve(dst, false)
+	if err != nil {
+		return "", "", err
+	}
+
+	if src != dst && pathWithin(dst, src) {
+		return "", "", fmt.Errorf("cannot move %s into itself: %s: %w", src, dst, fs.ErrInvalid)
+	}
+	return src, dst, nil
+}
+
+// moveAll moves the resolved path src to the resolved path dst, merging directories
+func (rootFS *FS) moveAll(src, dst string) error {
+	child, err := rootFS.lget(src)
+	if err != nil {
+		return err
+	}
+	existing, err := rootFS.lget(dst)
+	exists := err == nil
+
+	srcDir, srcIsDir := child.(*Dir)
+	_, dstIsDir := existing.(*Dir)
+	switch {
+	case srcIsDir && dstIsDir:
+		srcDir.mu.RLock()
+		names := srcDir.sortedChildNames()
+		srcDir.mu.RUnlock()
+
+		for _, name := range names {
+			if err := rootFS.moveAll(syspath.Join(src, name), syspath.Join(dst, name)); err != nil {
+				return err
+			}
+		}
+		return rootFS.remove(src)
+	case dstIsDir:
+		return fmt.Errorf("path is a directory: %s: %w", dst, fs.ErrExist)
+	case srcIsDir && exists:
+		return fmt.Errorf("not a directory: %s: %w", dst, fs.ErrExist)
+	}
+	return rootFS.move(src, dst)
+}
+
+// move moves the entry at the resolved path src to the resolved path dst,
+// replacing a file or symbolic link at dst; a directory never replaces
+// anything or is replaced. The parent directories of src and dst stay
+// locked while the entry is moved, so it is always found under exactly one
+// of the two names.
+func (rootFS *FS) move(src, dst string) error {
+	srcPart, srcName := syspath.Split(src)
+	srcPart = strings.TrimSuffix(srcPart, "/")
+	dstPart, dstName := syspath.Split(dst)
+	dstPart = strings.TrimSuffix(dstPart, "/")
+
+	from, err := rootFS.getDir(srcPart)
+	if err != nil {
+		return err
+	}
+	to, err := rootFS.getDir(dstPart)
+	if err != nil {
+		return err
+	}
+	srcKey := rootFS.keyPath(src)
+	dstKey := rootFS.keyPath(dst)
+
+	unlock := rootFS.lockDirs(from, srcPart, to, dstPart)
+	child := from.Children[srcName]
+	if child == nil {
+		unlock()
+		return fmt.Errorf("no such file or directory: %s: %w", src, fs.ErrNotExist)
+	}
+	existing := to.Children[dstName]
+	if _, ok := existing.(*Dir); ok {
+		unlock()
+		return fmt.Errorf("path is a directory: %s: %w", dst, fs.ErrExist)
+	}
+	if _, ok := child.(*Dir); ok && existing != nil {
+		unlock()
+		return fmt.Errorf("not a directory: %s: %w", dst, fs.ErrExist)
+	}
+
+	if err := rootFS.rekey(child, dstKey); err != nil {
+		// Content that could not be encrypted for dst stays where it was
+		rootFS.rekey(child, srcKey)
+		unlock()
+		return err
+	}
+	from.deleteChild(srcName)
+	from.ModTime = time.Now()
+	rootFS.attach(to, dstName, child)
+	unlock()
+
+	rootFS.notify(OpRename, srcKey)
+	rootFS.notify(OpCreate, dstKey)
+	return nil
+}
+
+// lockDirs locks the directories a and b at the resolved paths aPath and
+// bPath, once if they are the same, and returns a function that unlocks
+// them. They are locked in the order of a walk, see pathBefore, so parents
+// are locked before their children like in descend. Only one move at a time
+// holds two directories, as the moved entry is locked after them and may
+// come after the other directory in that order.
+func (rootFS *FS) lockDirs(a *Dir, aPath string, b *Dir, bPath string) (unlock func()) {
+	if a == b {
+		a.mu.Lock()
+		return a.mu.Unlock
+	}
+	if pathBefore(bPath, aPath) {
+		a, b = b, a
+	}
+	rootFS.moveMu.Lock()
+	a.mu.Lock()
+	b.mu.Lock()
+	return func() {
+		b.mu.Unlock()
+		a.mu.Unlock()
+		rootFS.moveMu.Unlock()
+	}
+}
+
+// pathBefore reports whether the entry at path a comes before the one at b
+// in a walk of the tree, which visits the entries of a directory in lexical
+// order and those below a directory right after it
+func pathBefore(a, b string) bool {
+	for a != "" && b != "" {
+		aName, aRest, _ := strings.Cut(a, "/")
+		bName, bRest, _ := strings.Cut(b, "/")
+		if aName != bName {
+			return aName < bName
+		}
+		a, b = aRest, bRest
+	}
+	return a == "" && b != ""
+}
+
+// attach places child as name into dir, replacing a file or symbolic link of
+// that name. The storage used by a replaced file is released. The caller
+// must hold dir.mu.
+func (rootFS *FS) attach(dir *Dir, name string, child childI) {
+	if c, ok := dir.Children[name].(*File); ok {
+		rootFS.mu.Lock()
+		rootFS.releaseFile(c)
+		rootFS.mu.Unlock()
+	}
+
+	switch c := child.(type) {
+	case *File:
+		rootFS.mu.Lock()
+		c.Name = name
+		rootFS.mu.Unlock()
+	case *Dir:
+		c.mu.Lock()
+		c.Name = name
+		c.mu.Unlock()
+	case *Symlink:
+		c.Name = name
+	}
+	dir.setChild(name, child)
+	dir.ModTime = time.Now()
+}
+
+// rekey makes keyPath the key path of child, and of every file below it if
+// child is a directory. Stored content that is bound to its path is
+// encrypted again for the new path.
+func (rootFS *FS) rekey(child childI, keyPath string) error {
+	switch c := child.(type) {
+	case *File:
+		return rootFS.rekeyFile(c, keyPath)
+	case *Dir:
+		c.mu.RLock()
+		names := c.sortedChildNames()
+		children := make(map[string]childI, len(names))
+		for _, name := range names {
+			children[name] = c.Children[name]
+		}
+		c.mu.RUnlock()
+
+		for _, name := range names {
+			if err := rootFS.rekey(children[name], syspath.Join(keyPath, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rekeyFile makes keyPath the key path of f and its previous versions. If
+// any of them cannot be encrypted again, f is left unchanged.
+func (rootFS *FS) rekeyFile(f *File, keyPath string) error {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+
+	if f.keyPath == keyPath {
+		return nil
+	}
+
+	// Files that are being written hold plaintext that is encrypted on Close
+	versions := f.History
+	if f.writers == 0 {
+		versions = append(versions[:len(versions):len(versions)], f)
+	}
+
+	type update struct {
+		file    *File
+		content []byte
+	}
+	var updates []update
+	enc := rootFS.encryptor
+	for _, v := range versions {
+		if enc == nil || !enc.pathBound() || len(v.Content) == 0 {
+			continue
+		}
+		plaintext, err := enc.decrypt(v.keyPath, v.Content)
+		if err != nil {
+			return err
+		}
+		content, err := enc.encrypt(keyPath, plaintext)
+		if err != nil {
+			return fmt.Errorf("encryption failed: %w", err)
+		}
+		updates = append(updates, update{file: v, content: content})
+	}
+
+	for _, u := range updates {
+		rootFS.usedStorage += int64(len(u.content)) - int64(len(u.file.Content))
+		rootFS.unshare(u.file)
+		u.file.Content = u.content
+		rootFS.intern(u.file)
+	}
+	for _, v := range f.History {
+		v.keyPath = keyPath
+	}
+	f.keyPath = keyPath
+	return nil
+}
@@ -0,0 +1,336 @@
+package memfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMoveAllRename(t *testing.T) {
+	key := []byte("move-key")
+	rootFS := New(WithEncryption(key), WithVersioning(1))
+
+	if err := rootFS.MkdirAll("src/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{
+		"src/a.txt":     "first",
+		"src/sub/b.txt": "second",
+	} {
+		if err := rootFS.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rootFS.WriteFile("src/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	used := rootFS.UsedStorage()
+
+	if err := rootFS.MoveAll("src", "dst"); err != nil {
+		t.Fatal(err)
+	}
+	if rootFS.Exists("src") {
+		t.Fatal("Expected src to be gone")
+	}
+	if got := rootFS.UsedStorage(); got != used {
+		t.Fatalf("Expected used storage %d after the move, got %d", used, got)
+	}
+
+	// Content bound to the old path is encrypted for the new one
+	for path, want := range map[string]string{
+		"dst/a.txt":     "a",
+		"dst/sub/b.txt": "second",
+	} {
+		content, err := rootFS.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile(%q): %v", path, err)
+		}
+		if string(content) != want {
+			t.Fatalf("Expected %q in %s, got %q", want, path, content)
+		}
+	}
+	versions, err := rootFS.Versions("dst/a.txt")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("Expected one previous version, got %v, %v", versions, err)
+	}
+	old, err := rootFS.OpenVersion("dst/a.txt", versions[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	if content, err := io.ReadAll(old); err != nil || string(content) != "first" {
+		t.Fatalf("Expected the previous version %q, got %q, %v", "first", content, err)
+	}
+
+	// The moved tree survives a save and load, which derives key paths from names
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.SetEncryptionKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := loaded.ReadFile("dst/sub/b.txt"); err != nil || string(content) != "second" {
+		t.Fatalf("Expected to read the moved file after loading, got %q, %v", content, err)
+	}
+}
+
+func TestMoveAllMerge(t *testing.T) {
+	rootFS := New()
+
+	for p, content := range map[string]string{
+		"src/same.txt":    "new",
+		"src/only.txt":    "only in src",
+		"src/sub/b.txt":   "b",
+		"dst/same.txt":    "old content",
+		"dst/keep.txt":    "kept",
+		"dst/sub/c.txt":   "c",
+		"other/unrelated": "x",
+	} {
+		if err := rootFS.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := rootFS.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rootFS.MoveAll("src", "dst"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"dst/same.txt":    "new",
+		"dst/only.txt":    "only in src",
+		"dst/keep.txt":    "kept",
+		"dst/sub/b.txt":   "b",
+		"dst/sub/c.txt":   "c",
+		"other/unrelated": "x",
+	}
+	var size int64
+	for path, content := range want {
+		got, err := rootFS.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile(%q): %v", path, err)
+		}
+		if string(got) != content {
+			t.Fatalf("Expected %q in %s, got %q", content, path, got)
+		}
+		size += int64(len(content))
+	}
+	if rootFS.Exists("src") {
+		t.Fatal("Expected src to be removed after merging")
+	}
+	if got := rootFS.UsedStorage(); got != size {
+		t.Fatalf("Expected used storage %d, got %d", size, got)
+	}
+	if got := rootFS.Stats().TotalFiles; got != len(want) {
+		t.Fatalf("Expected %d files, got %d", len(want), got)
+	}
+}
+
+func TestMoveAllErrors(t *testing.T) {
+	rootFS := New()
+	if err := rootFS.MkdirAll("dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("file", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		src, dst string
+		want     error
+	}{
+		{"dir", "file", fs.ErrExist},
+		{"file", "dir", fs.ErrExist},
+		{"dir", "dir/sub/inner", fs.ErrInvalid},
+		{"missing", "new", fs.ErrNotExist},
+		{"file", "missing/new", fs.ErrNotExist},
+		{".", "new", fs.ErrInvalid},
+	}
+	for _, tt := range tests {
+		if err := rootFS.MoveAll(tt.src, tt.dst); !errors.Is(err, tt.want) {
+			t.Errorf("MoveAll(%q, %q): expected %v, got %v", tt.src, tt.dst, tt.want, err)
+		}
+	}
+
+	// Nothing was moved by the failed calls
+	if !rootFS.Exists("file") || !rootFS.Exists("dir/sub") {
+		t.Fatal("Expected the failed moves to leave the tree unchanged")
+	}
+}
+
+func TestRename(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("rename-key")))
+	if err := rootFS.MkdirAll("dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for p, content := range map[string]string{
+		"old.txt":         "content",
+		"existing.txt":    "replaced",
+		"dir/sub/in.txt":  "inside",
+		"dir/sibling.txt": "sibling",
+	} {
+		if err := rootFS.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, stop, err := rootFS.Watch("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	inner, stopInner, err := rootFS.Watch("dir/sub/in.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopInner()
+
+	if err := rootFS.Rename("old.txt", "new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Rename("new.txt", "existing.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Rename("dir/sub", "moved"); err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string]string{
+		"existing.txt": "content",
+		"moved/in.txt": "inside",
+	} {
+		content, err := rootFS.ReadFile(p)
+		if err != nil {
+			t.Fatalf("ReadFile(%q): %v", p, err)
+		}
+		if string(content) != want {
+			t.Fatalf("Expected %q in %s, got %q", want, p, content)
+		}
+	}
+	for _, p := range []string{"old.txt", "new.txt", "dir/sub"} {
+		if rootFS.Exists(p) {
+			t.Fatalf("Expected %s to be gone", p)
+		}
+	}
+	if got := rootFS.Stats().TotalFiles; got != 3 {
+		t.Fatalf("Expected 3 files after replacing one, got %d", got)
+	}
+
+	expectEvents(t, events,
+		Event{Op: OpRename, Path: "old.txt"},
+		Event{Op: OpCreate, Path: "new.txt"},
+		Event{Op: OpRename, Path: "new.txt"},
+		Event{Op: OpCreate, Path: "existing.txt"},
+		Event{Op: OpRename, Path: "dir/sub"},
+		Event{Op: OpCreate, Path: "moved"},
+	)
+	expectEvents(t, inner, Event{Op: OpRename, Path: "dir/sub"})
+
+	if err := rootFS.Rename("missing", "x"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got: %v", err)
+	}
+	if err := rootFS.Rename("existing.txt", "dir"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Expected fs.ErrExist when replacing a directory, got: %v", err)
+	}
+	if err := rootFS.Rename("moved", "existing.txt"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Expected fs.ErrExist when replacing a file with a directory, got: %v", err)
+	}
+	if err := rootFS.Rename("dir", "dir/inner"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Expected fs.ErrInvalid when renaming into itself, got: %v", err)
+	}
+
+	rootFS.SetReadOnly(true)
+	if err := rootFS.Rename("existing.txt", "other.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Expected fs.ErrPermission on a read-only filesystem, got: %v", err)
+	}
+}
+
+// TestRenameConcurrent tests that a renamed file is always found under one of
+// its names, and that renames between directories do not deadlock
+func TestRenameConcurrent(t *testing.T) {
+	// Content bound to its path is encrypted again while it is renamed
+	rootFS := New(WithEncryption([]byte("rename-key")))
+	for _, dir := range []string{"a/x", "b"} {
+		if err := rootFS.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The file is renamed along names in the same and in other directories,
+	// and only forward, so a scan in the same order always finds it
+	const steps = 500
+	names := make([]string, steps)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s/%d", []string{"a", "a", "b"}[i%3], i)
+	}
+	if err := rootFS.WriteFile(names[0], []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("a/x/f", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 1; i < steps; i++ {
+			if err := rootFS.Rename(names[i-1], names[i]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	// Moves of a directory and of an entry of it between the same directories
+	go func() {
+		defer wg.Done()
+		for range steps {
+			rootFS.Rename("a/x", "b/x")
+			rootFS.Rename("b/x", "a/x")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range steps {
+			rootFS.Rename("a/x/f", "b/f")
+			rootFS.Rename("b/f", "a/x/f")
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timeout := time.After(10 * time.Second)
+	for cur := 0; ; {
+		select {
+		case <-done:
+			if !rootFS.Exists(names[steps-1]) {
+				t.Fatalf("Expected the file at %s", names[steps-1])
+			}
+			return
+		case <-timeout:
+			t.Fatal("Timed out renaming concurrently")
+		default:
+		}
+		for !rootFS.Exists(names[cur]) {
+			if cur++; cur == steps {
+				t.Fatal("Expected the renamed file under one of its names")
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package memfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	syspath "path"
+)
+
+// WriteMultipart writes every file of the filesystem to w as a form file part.
+// The path of the file is used as both the field name and the file name of
+// the part, so the directory structure can be rebuilt by LoadMultipart.
+// File contents are written decrypted. Empty directories are not written.
+// WriteMultipart does not close w.
+func (rootFS *FS) WriteMultipart(w *multipart.Writer) error {
+	return rootFS.walk(func(path string, child childI) error {
+		file, ok := child.(*File)
+		if !ok {
+			return nil
+		}
+
+		content, err := rootFS.readContent(file)
+		if err != nil {
+			return err
+		}
+
+		part, err := w.CreateFormFile(path, path)
+		if err != nil {
+			return err
+		}
+		_, err = part.Write(content)
+		return err
+	})
+}
+
+// LoadMultipart creates a new FS from the form file parts in r, as written by
+// WriteMultipart. The field name of each part is used as the file path and
+// missing parent directories are created. Parts that are not files are skipped.
+// The options are applied to the new FS, so the files are encrypted if
+// WithEncryption is given.
+func LoadMultipart(r *multipart.Reader, opts ...Option) (*FS, error) {
+	rootFS := New(opts...)
+
+	for {
+		part, err := r.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		path := part.FormName()
+		if !fs.ValidPath(path) || path == "." {
+			part.Close()
+			return nil, fmt.Errorf("invalid path in multipart form: %s: %w", path, fs.ErrInvalid)
+		}
+
+		content, err := io.ReadAll(part)
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if dirPart := syspath.Dir(path); dirPart != "." {
+			if err := rootFS.MkdirAll(dirPart, 0755); err != nil {
+				return nil, err
+			}
+		}
+		if err := rootFS.WriteFile(path, content, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	return rootFS, nil
+}
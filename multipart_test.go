@@ -0,0 +1,76 @@
+package memfs
+
+import (
+	"bytes"
+	"io/fs"
+	"mime/multipart"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMultipartRoundTrip(t *testing.T) {
+	key := []byte("multipart-key")
+	rootFS := New(WithEncryption(key))
+
+	if err := rootFS.MkdirAll("foo/bar", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	testFiles := map[string][]byte{
+		"foo/file1.txt":     []byte("content1"),
+		"foo/bar/file2.txt": []byte("content2"),
+		"root.txt":          []byte("root content"),
+	}
+	for path, content := range testFiles {
+		if err := rootFS.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := rootFS.WriteMultipart(mw); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Contents are written decrypted
+	if !bytes.Contains(buf.Bytes(), []byte("root content")) {
+		t.Fatal("multipart form does not contain the decrypted content")
+	}
+
+	loadedFS, err := LoadMultipart(multipart.NewReader(&buf, mw.Boundary()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotPaths []string
+	err = fs.WalkDir(loadedFS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			gotPaths = append(gotPaths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotPaths) != len(testFiles) {
+		t.Fatalf("expected %d files, got %v", len(testFiles), gotPaths)
+	}
+
+	for path, content := range testFiles {
+		got, err := fs.ReadFile(loadedFS, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(content, got); diff != "" {
+			t.Fatalf("content mismatch for %s: %s", path, diff)
+		}
+	}
+}
@@ -1,13 +1,44 @@
 package memfs
 
+import (
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"time"
+)
+
 type Option interface {
 	setOption(*fsOption)
 }
 
 type fsOption struct {
-	openHook      func(path string, existingContent []byte, origErr error) ([]byte, error)
-	maxStorage    int64
-	encryptionKey []byte
+	openHook       func(path string, existingContent []byte, origErr error) ([]byte, error)
+	writeHook      func(path string, data []byte) ([]byte, error)
+	maxStorage     int64
+	encryptionKey  []byte
+	readOnly       bool
+	versions       int
+	ttl            time.Duration
+	onEvict        func(path string)
+	sortedDirs     bool
+	lower          fs.FS
+	autoMkdir      bool
+	autoMkdirPerm  os.FileMode
+	maxFileSize    int64
+	maxFiles       int
+	lru            bool
+	noAtime        bool
+	maxSymlinkHops int
+	cipher         string
+
+	encryptedPaths bool
+	perFileKeys    bool
+	kdf            *KDFParams
+	hmacKey        []byte
+	compress       bool
+	compressLevel  int
+	dedup          bool
+	chunked        bool
 }
 
 type openHookOption struct {
@@ -35,6 +66,30 @@ func WithOpenHook(f func(string, []byte, error) ([]byte, error)) Option {
 	}
 }
 
+type writeHookOption struct {
+	hook func(path string, data []byte) ([]byte, error)
+}
+
+func (o *writeHookOption) setOption(fsOpt *fsOption) {
+	fsOpt.writeHook = o.hook
+}
+
+// WithWriteHook returns an Option that sets a hook function to be called
+// with the content of a file before it is stored, the inverse of
+// WithOpenHook. The content returned by the hook is stored instead, and is
+// what counts against the storage and file size limits; with encryption it is
+// encrypted after the hook. If the hook returns an error, the write fails.
+//
+// The hook is called by WriteFile, and by Close on the last FileWriter of a
+// file, with the complete content written. If it fails there, the file is
+// left empty. Close calls the hook with the filesystem locked, so the hook
+// must not call methods of the filesystem.
+func WithWriteHook(f func(path string, data []byte) ([]byte, error)) Option {
+	return &writeHookOption{
+		hook: f,
+	}
+}
+
 type maxStorageOption struct {
 	size int64
 }
@@ -45,6 +100,8 @@ func (o *maxStorageOption) setOption(fsOpt *fsOption) {
 
 // WithMaxStorage returns an Option that sets the maximum storage space (in bytes) for the MemFS instance.
 // If the total size of all files in the MemFS exceeds this limit, an error will be returned when trying to write new files.
+// Content buffered by FileWriters that are not closed yet counts against the limit
+// with the size it will take up once stored, including the overhead of encryption.
 func WithMaxStorage(size int64) Option {
 	return &maxStorageOption{
 		size: size,
@@ -75,3 +132,435 @@ func WithEncryption(key []byte) Option {
 		key: key,
 	}
 }
+
+type encryptionCipherOption struct {
+	cipher string
+}
+
+func (o *encryptionCipherOption) setOption(fsOpt *fsOption) {
+	fsOpt.cipher = o.cipher
+}
+
+// WithEncryptionCipher returns an Option that selects the cipher used together
+// with WithEncryption: CipherAESGCM ("aes-gcm", the default) or
+// CipherChaCha20Poly1305 ("chacha20-poly1305"). ChaCha20-Poly1305 is faster on
+// systems without hardware support for AES, such as many ARM boards.
+//
+// The cipher is saved with the filesystem, so SetEncryptionKey picks the
+// right one after loading. WithEncryptionCipher panics if cipher is not one
+// of the supported names.
+func WithEncryptionCipher(cipher string) Option {
+	if err := checkCipher(cipher); err != nil {
+		panic("memfs: " + err.Error())
+	}
+	return &encryptionCipherOption{
+		cipher: cipher,
+	}
+}
+
+type encryptedPathsOption struct {
+	enabled bool
+}
+
+func (o *encryptedPathsOption) setOption(fsOpt *fsOption) {
+	fsOpt.encryptedPaths = o.enabled
+}
+
+// WithEncryptedPaths returns an Option that encrypts the names of all files,
+// directories and symbolic link targets when the filesystem is saved with
+// SaveTo or CompressAndSaveTo, so that the saved file does not reveal the
+// directory tree. It only has an effect together with WithEncryption.
+//
+// A filesystem loaded from such a file shows encrypted names until
+// SetEncryptionKey is called with the right key, which decrypts them again.
+// The loaded filesystem keeps encrypting the names when it is saved.
+func WithEncryptedPaths(enabled bool) Option {
+	return &encryptedPathsOption{
+		enabled: enabled,
+	}
+}
+
+type perFileKeysOption struct {
+	masterKey []byte
+}
+
+func (o *perFileKeysOption) setOption(fsOpt *fsOption) {
+	fsOpt.encryptionKey = o.masterKey
+	fsOpt.perFileKeys = true
+}
+
+// WithPerFileKeys returns an Option that enables encryption at rest like
+// WithEncryption, but encrypts every file with its own key, derived from
+// masterKey and the path of the file with HKDF-SHA256. A derived key that is
+// exposed only gives access to a single file.
+//
+// Files that are copied to another path with CopyFile or CopyDir are
+// encrypted again with the key of their new path. To load a saved filesystem,
+// call SetEncryptionKey with masterKey.
+func WithPerFileKeys(masterKey []byte) Option {
+	return &perFileKeysOption{
+		masterKey: masterKey,
+	}
+}
+
+type encryptionPassphraseOption struct {
+	key []byte
+	kdf *KDFParams
+}
+
+func (o *encryptionPassphraseOption) setOption(fsOpt *fsOption) {
+	fsOpt.encryptionKey = o.key
+	fsOpt.kdf = o.kdf
+}
+
+// WithEncryptionPassphrase returns an Option that enables encryption at rest
+// like WithEncryption, with a key derived from passphrase by scrypt or
+// argon2id as selected by params. Unlike the plain SHA-256 hash applied by
+// WithEncryption, these functions make guessing the passphrase expensive.
+//
+// The parameters and salt are saved with the filesystem, so a loaded
+// filesystem is unlocked with SetEncryptionPassphrase and the passphrase
+// alone. The key is derived once, when the option is created.
+// WithEncryptionPassphrase panics if params are invalid.
+func WithEncryptionPassphrase(passphrase []byte, params KDFParams) Option {
+	kdf := params.withDefaults()
+	key, err := kdf.deriveKey(passphrase)
+	if err != nil {
+		panic("memfs: " + err.Error())
+	}
+	return &encryptionPassphraseOption{
+		key: key,
+		kdf: &kdf,
+	}
+}
+
+type hmacOption struct {
+	key []byte
+}
+
+func (o *hmacOption) setOption(fsOpt *fsOption) {
+	fsOpt.hmacKey = o.key
+}
+
+// WithHMAC returns an Option that appends an HMAC-SHA256 of key to the stored
+// content of every file and verifies it whenever the content is read, so
+// that modified content is detected with ErrTampered. It does not hide the
+// content, and is cheaper than WithEncryption for that. Together with
+// WithEncryption the HMAC is computed over the encrypted content.
+//
+// The key is not saved with the filesystem; after loading, call SetHMACKey.
+func WithHMAC(key []byte) Option {
+	return &hmacOption{
+		key: key,
+	}
+}
+
+type compressionOption struct {
+	level int
+}
+
+func (o *compressionOption) setOption(fsOpt *fsOption) {
+	fsOpt.compress = true
+	fsOpt.compressLevel = o.level
+}
+
+// WithCompression returns an Option that gzip compresses the content of every
+// file before it is stored, and decompresses it when the file is read, so
+// resident files such as logs or JSON take up less memory. This is
+// independent of CompressAndSaveTo, which compresses the saved filesystem as a
+// whole. Together with WithEncryption, content is compressed before it is
+// encrypted. UsedStorage and the storage limit count the compressed size.
+// Content that does not become smaller, such as tiny or already compressed
+// files, is stored uncompressed after a 0x00 marker byte, so it takes up a
+// single byte more.
+//
+// The setting is saved with the filesystem, so loaded filesystems keep
+// compressing their files.
+func WithCompression() Option {
+	return &compressionOption{
+		level: gzip.DefaultCompression,
+	}
+}
+
+// WithCompressionLevel returns an Option like WithCompression that compresses
+// with the given gzip level, from gzip.HuffmanOnly and gzip.BestSpeed to
+// gzip.BestCompression. It panics if the level is invalid.
+func WithCompressionLevel(level int) Option {
+	if err := checkCompressionLevel(level); err != nil {
+		panic("memfs: " + err.Error())
+	}
+	return &compressionOption{
+		level: level,
+	}
+}
+
+type deduplicationOption struct{}
+
+func (o *deduplicationOption) setOption(fsOpt *fsOption) {
+	fsOpt.dedup = true
+}
+
+// WithDeduplication returns an Option that stores identical file content only
+// once. Files are matched by the SHA-256 of their stored content, and the
+// content is freed when the last file using it is removed or changed.
+// UsedStorage counts shared content once. The storage limit is checked
+// before content is shared, so a write needs room for a copy of its content.
+//
+// With WithEncryption, every file is encrypted with its own nonce, so equal
+// plaintext is not shared. Saved filesystems hold a copy of the content for
+// every file; it is shared again when they are loaded.
+func WithDeduplication() Option {
+	return &deduplicationOption{}
+}
+
+type chunkedEncryptionOption struct{}
+
+func (o *chunkedEncryptionOption) setOption(fsOpt *fsOption) {
+	fsOpt.chunked = true
+}
+
+// WithChunkedEncryption returns an Option that encrypts the content of files
+// in frames of 64KiB, each sealed with its own nonce and authenticated with
+// its position, so frames cannot be reordered, dropped or cut off. Files
+// opened for reading are decrypted one frame at a time while they are read,
+// instead of as a whole when they are opened, which bounds the memory needed
+// to read large files. It has no effect without WithEncryption.
+//
+// Content that is compressed with WithCompression is still decrypted as a
+// whole, and files being written hold their plaintext until the last
+// FileWriter is closed, like without chunked encryption.
+func WithChunkedEncryption() Option {
+	return &chunkedEncryptionOption{}
+}
+
+type readOnlyOption struct {
+	readOnly bool
+}
+
+func (o *readOnlyOption) setOption(fsOpt *fsOption) {
+	fsOpt.readOnly = o.readOnly
+}
+
+// WithReadOnly returns an Option that makes the MemFS instance read-only.
+// All mutating operations (WriteFile, Create, OpenFile with write flags, Remove,
+// RemoveAll, MkdirAll, ...) return an error wrapping fs.ErrPermission, while
+// reading and walking the filesystem keep working as usual.
+//
+// To populate a filesystem first and lock it afterwards, use (*FS).Freeze.
+func WithReadOnly(readOnly bool) Option {
+	return &readOnlyOption{
+		readOnly: readOnly,
+	}
+}
+
+type ttlOption struct {
+	ttl time.Duration
+}
+
+func (o *ttlOption) setOption(fsOpt *fsOption) {
+	fsOpt.ttl = o.ttl
+}
+
+// WithTTL returns an Option that makes files expire d after they were last written.
+// Expired files are no longer visible: opening them returns fs.ErrNotExist and
+// they are removed lazily. A background goroutine also evicts expired files
+// periodically; call (*FS).Close to stop it once the filesystem is no longer used.
+func WithTTL(d time.Duration) Option {
+	return &ttlOption{
+		ttl: d,
+	}
+}
+
+type onEvictOption struct {
+	fn func(path string)
+}
+
+func (o *onEvictOption) setOption(fsOpt *fsOption) {
+	fsOpt.onEvict = o.fn
+}
+
+// WithOnEvict returns an Option that sets a function to be called with the
+// path of every file that is removed because its TTL expired (see WithTTL),
+// or to make room for new content (see WithEvictionLRU).
+func WithOnEvict(f func(path string)) Option {
+	return &onEvictOption{
+		fn: f,
+	}
+}
+
+type evictionLRUOption struct{}
+
+func (o *evictionLRUOption) setOption(fsOpt *fsOption) {
+	fsOpt.lru = true
+}
+
+// WithEvictionLRU returns an Option that turns the filesystem into a bounded
+// cache: instead of failing a write that would exceed the limit set with
+// WithMaxStorage, the least recently read files are removed until the new
+// content fits. Files that have never been read count as used when they
+// were last written. Files that are being written are never evicted; if
+// the content does not fit once nothing else can be evicted, the write
+// fails as usual. Evicted files are reported to watchers as removed and
+// to the function set with WithOnEvict.
+//
+// Opening a file or reading it with ReadFile updates its AccessTime, even
+// with WithNoAtime.
+func WithEvictionLRU() Option {
+	return &evictionLRUOption{}
+}
+
+type noAtimeOption struct{}
+
+func (o *noAtimeOption) setOption(fsOpt *fsOption) {
+	fsOpt.noAtime = true
+}
+
+// WithNoAtime returns an Option that stops recording the access time of
+// files when they are opened or read, like mounting with -o noatime. Access
+// times are still set by Chtimes.
+func WithNoAtime() Option {
+	return &noAtimeOption{}
+}
+
+type versioningOption struct {
+	n int
+}
+
+func (o *versioningOption) setOption(fsOpt *fsOption) {
+	fsOpt.versions = o.n
+}
+
+// WithVersioning returns an Option that keeps up to n previous versions of every file.
+// Whenever an existing file is replaced, for example by WriteFile or Create, its
+// current content is kept as a previous version. Previous versions count towards
+// the storage limit, are saved along with the filesystem and are dropped when
+// the file is removed. See (*FS).Versions and (*FS).OpenVersion.
+func WithVersioning(n int) Option {
+	return &versioningOption{
+		n: n,
+	}
+}
+
+type sortedDirsOption struct{}
+
+func (o *sortedDirsOption) setOption(fsOpt *fsOption) {
+	fsOpt.sortedDirs = true
+}
+
+// WithSortedDirs returns an Option that keeps the children of every directory
+// in lexical order as they are added and removed. Directory listings and walks
+// then come out sorted without sorting on every call, at the cost of slower
+// file creation and removal in large directories.
+func WithSortedDirs() Option {
+	return &sortedDirsOption{}
+}
+
+type overlayOption struct {
+	lower fs.FS
+}
+
+func (o *overlayOption) setOption(fsOpt *fsOption) {
+	fsOpt.lower = o.lower
+}
+
+// WithOverlay returns an Option that stacks the MemFS on top of lower.
+// Paths that do not exist in the MemFS are read from lower, and directories
+// list the entries of both. All writes go to the MemFS: files of lower are
+// copied into it before they are modified, and removing a file of lower
+// hides it without touching lower.
+//
+// Only the content of the MemFS itself is saved or exported.
+func WithOverlay(lower fs.FS) Option {
+	return &overlayOption{
+		lower: lower,
+	}
+}
+
+type autoMkdirOption struct {
+	enabled bool
+}
+
+func (o *autoMkdirOption) setOption(fsOpt *fsOption) {
+	fsOpt.autoMkdir = o.enabled
+}
+
+// WithAutoMkdir returns an Option that makes WriteFile, Create and OpenFile
+// with O_CREATE create any missing parent directories of the new file, like
+// MkdirAll. The directories get the permissions set by WithAutoMkdirPerm,
+// 0755 by default.
+func WithAutoMkdir(enabled bool) Option {
+	return &autoMkdirOption{
+		enabled: enabled,
+	}
+}
+
+type autoMkdirPermOption struct {
+	perm os.FileMode
+}
+
+func (o *autoMkdirPermOption) setOption(fsOpt *fsOption) {
+	fsOpt.autoMkdirPerm = o.perm
+}
+
+// WithAutoMkdirPerm returns an Option that sets the permissions of the
+// directories created by WithAutoMkdir.
+func WithAutoMkdirPerm(perm os.FileMode) Option {
+	return &autoMkdirPermOption{
+		perm: perm,
+	}
+}
+
+type maxFileSizeOption struct {
+	size int64
+}
+
+func (o *maxFileSizeOption) setOption(fsOpt *fsOption) {
+	fsOpt.maxFileSize = o.size
+}
+
+// WithMaxFileSize returns an Option that limits the size (in bytes) of every
+// single file, independent of WithMaxStorage. The limit applies to the
+// plaintext content: WriteFile, Truncate and the writes of a FileWriter fail
+// with an error if the file would grow beyond it.
+func WithMaxFileSize(size int64) Option {
+	return &maxFileSizeOption{
+		size: size,
+	}
+}
+
+type maxFilesOption struct {
+	n int
+}
+
+func (o *maxFilesOption) setOption(fsOpt *fsOption) {
+	fsOpt.maxFiles = o.n
+}
+
+// WithMaxFiles returns an Option that limits the number of files in the MemFS
+// instance to n. Creating a file beyond the limit fails with an error
+// wrapping ErrTooManyFiles, while existing files can still be overwritten.
+// Directories and symbolic links are not counted. Like WithMaxFileSize, the
+// limit is not saved with the filesystem.
+func WithMaxFiles(n int) Option {
+	return &maxFilesOption{
+		n: n,
+	}
+}
+
+type maxSymlinkDepthOption struct {
+	n int
+}
+
+func (o *maxSymlinkDepthOption) setOption(fsOpt *fsOption) {
+	fsOpt.maxSymlinkHops = o.n
+}
+
+// WithMaxSymlinkDepth returns an Option that sets how many symbolic links are
+// followed while resolving a single path, 40 by default. Resolving a path
+// that needs more fails with ErrTooManyLinks, which also stops link loops.
+// A depth of 0 or less keeps the default.
+func WithMaxSymlinkDepth(n int) Option {
+	return &maxSymlinkDepthOption{
+		n: n,
+	}
+}
@@ -0,0 +1,278 @@
+package memfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	syspath "path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CopyFromDir copies the directory tree rooted at osDir on the local disk
+// into the filesystem, keeping permissions and modification times. Content
+// is encrypted and counts against the storage limit like any other write;
+// files are streamed into the filesystem instead of being read into memory
+// first.
+//
+// Symbolic links are skipped unless followSymlinks is set, in which case the
+// files and directories they point to are copied in their place. Links that
+// lead back into a directory that is being copied are skipped.
+// Other file types, such as devices and sockets, are always skipped.
+func (rootFS *FS) CopyFromDir(osDir string, followSymlinks bool) error {
+	return rootFS.copyFromDir(osDir, ".", followSymlinks, make(map[string]bool))
+}
+
+// copyFromDir copies osDir to prefix. Visiting holds the real paths of the
+// directories being copied, to detect symbolic link loops.
+func (rootFS *FS) copyFromDir(osDir, prefix string, followSymlinks bool, visiting map[string]bool) error {
+	realDir, err := filepath.EvalSymlinks(osDir)
+	if err != nil {
+		return err
+	}
+	if visiting[realDir] {
+		return nil
+	}
+	visiting[realDir] = true
+	defer delete(visiting, realDir)
+
+	// Directory times are set last, as adding entries may change them
+	dirTimes := make(map[string]time.Time)
+
+	err = filepath.WalkDir(realDir, func(osPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(realDir, osPath)
+		if err != nil {
+			return err
+		}
+		path := syspath.Join(prefix, filepath.ToSlash(rel))
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if !followSymlinks {
+				return nil
+			}
+			info, err = os.Stat(osPath)
+			if err != nil {
+				// Dangling links are skipped
+				return nil
+			}
+			if info.IsDir() {
+				return rootFS.copyFromDir(osPath, path, followSymlinks, visiting)
+			}
+		}
+
+		switch {
+		case info.IsDir():
+			if path == "." {
+				return nil
+			}
+			if err := rootFS.MkdirAll(path, info.Mode().Perm()); err != nil {
+				return err
+			}
+			dirTimes[path] = info.ModTime()
+			return nil
+		case info.Mode().IsRegular():
+			if err := rootFS.copyFromOSFile(osPath, path, info); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		return rootFS.Chtimes(path, time.Time{}, info.ModTime())
+	})
+	if err != nil {
+		return err
+	}
+
+	for path, modTime := range dirTimes {
+		if err := rootFS.Chtimes(path, time.Time{}, modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFromOSFile streams the regular file at osPath to path
+func (rootFS *FS) copyFromOSFile(osPath, path string, info fs.FileInfo) error {
+	f, err := os.Open(osPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := rootFS.CreateWithSize(path, info.Size(), info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// WriteToDir recreates the filesystem below osDir on the local disk, which is
+// created if needed. Files are written with their decrypted content, and
+// permissions and modification times are restored. Symbolic links are
+// recreated with targets relative to the link; a link whose target lies
+// outside the filesystem makes WriteToDir fail, as it would escape osDir.
+//
+// Files and symbolic links that already exist below osDir are replaced, so
+// WriteToDir can be called again to update an earlier copy; entries that do
+// not exist in the filesystem are left alone.
+//
+// WriteToDir stops at the first error. Only the in-memory tree is written;
+// the lower layer of an overlay is not.
+func (rootFS *FS) WriteToDir(osDir string) error {
+	if err := os.MkdirAll(osDir, 0755); err != nil {
+		return err
+	}
+
+	// Directories are made writable until all their entries exist, then
+	// their permissions and modification times are restored, deepest first
+	type dirMeta struct {
+		osPath string
+		info   fs.FileInfo
+	}
+	var dirs []dirMeta
+
+	err := rootFS.walk(func(path string, child childI) error {
+		osPath, err := osPathIn(osDir, path)
+		if err != nil {
+			return err
+		}
+
+		switch c := child.(type) {
+		case *Dir:
+			info, err := (&fhDir{dir: c}).Stat()
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(osPath, 0700); err != nil {
+				return err
+			}
+			// A directory of an earlier copy may not be writable
+			if err := os.Chmod(osPath, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMeta{osPath: osPath, info: info})
+		case *File:
+			if rootFS.expired(c) {
+				return nil
+			}
+			content, err := rootFS.readContent(c)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", path, err)
+			}
+			info := rootFS.fileStat(c)
+			if err := removeOSFile(osPath); err != nil {
+				return err
+			}
+			if err := os.WriteFile(osPath, content, info.Mode().Perm()); err != nil {
+				return err
+			}
+			// The permissions given to os.WriteFile are subject to the umask
+			if err := os.Chmod(osPath, info.Mode().Perm()); err != nil {
+				return err
+			}
+			if err := os.Chtimes(osPath, time.Time{}, info.ModTime()); err != nil {
+				return err
+			}
+		case *Symlink:
+			target, err := osLinkTarget(path, c.Target)
+			if err != nil {
+				return err
+			}
+			if err := removeOSFile(osPath); err != nil {
+				return err
+			}
+			if err := os.Symlink(target, osPath); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := os.Chmod(d.osPath, d.info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(d.osPath, time.Time{}, d.info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyTo writes the filesystem to the directory dst on the local disk, the
+// counterpart of NewFromFS. It is the same as WriteToDir.
+func (rootFS *FS) CopyTo(dst string) error {
+	return rootFS.WriteToDir(dst)
+}
+
+// removeOSFile removes the file or symbolic link at osPath on disk, so that
+// it can be replaced. Directories are kept, and a missing file is not an error.
+func removeOSFile(osPath string) error {
+	info, err := os.Lstat(osPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	return os.Remove(osPath)
+}
+
+// osPathIn returns the path on disk of path below osDir, or an error if it
+// would escape osDir
+func osPathIn(osDir, path string) (string, error) {
+	if !fs.ValidPath(path) {
+		return "", fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
+	}
+	osPath := filepath.Join(osDir, filepath.FromSlash(path))
+	rel, err := filepath.Rel(osDir, osPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path escapes %s: %s: %w", osDir, path, fs.ErrInvalid)
+	}
+	return osPath, nil
+}
+
+// osLinkTarget returns the target of the symbolic link at path as a path
+// relative to the directory of the link, so that it can be recreated on disk
+func osLinkTarget(path, target string) (string, error) {
+	dir := syspath.Dir(path)
+	resolved := syspath.Join(dir, target)
+	if strings.HasPrefix(target, "/") {
+		resolved = syspath.Clean(strings.TrimPrefix(target, "/"))
+		if resolved == "" {
+			resolved = "."
+		}
+	}
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", fmt.Errorf("symlink target escapes the filesystem: %s -> %s: %w", path, target, fs.ErrInvalid)
+	}
+
+	rel, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(resolved))
+	if err != nil {
+		return "", err
+	}
+	return rel, nil
+}
@@ -0,0 +1,239 @@
+package memfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestCopyFromDir tests importing a directory tree from disk
+func TestCopyFromDir(t *testing.T) {
+	osDir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(osDir, "dir", "sub"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"top.txt":         "top",
+		"dir/file.txt":    "file",
+		"dir/sub/big.bin": string(make([]byte, 1<<20)),
+	}
+	mtime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for path, content := range files {
+		osPath := filepath.Join(osDir, filepath.FromSlash(path))
+		if err := os.WriteFile(osPath, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(osPath, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("dir", filepath.Join(osDir, "dirlink")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink("top.txt", filepath.Join(osDir, "filelink")); err != nil {
+		t.Fatal(err)
+	}
+	// A loop back to the root of the copy
+	if err := os.Symlink("..", filepath.Join(osDir, "dir", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, follow := range []bool{false, true} {
+		rootFS := New(WithEncryption([]byte("osdir-key")))
+		if err := rootFS.CopyFromDir(osDir, follow); err != nil {
+			t.Fatalf("follow=%v: %v", follow, err)
+		}
+
+		for path, want := range files {
+			content, err := fs.ReadFile(rootFS, path)
+			if err != nil {
+				t.Fatalf("follow=%v: %v", follow, err)
+			}
+			if string(content) != want {
+				t.Fatalf("follow=%v: content mismatch for %s", follow, path)
+			}
+
+			info, err := rootFS.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode() != 0640 || !info.ModTime().Equal(mtime) {
+				t.Fatalf("follow=%v: expected mode 0640 and mtime %v for %s, got %v and %v", follow, mtime, path, info.Mode(), info.ModTime())
+			}
+		}
+
+		info, err := rootFS.Stat("dir/sub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != fs.ModeDir|0750 {
+			t.Fatalf("Expected directory mode 0750, got %v", info.Mode())
+		}
+
+		// Content is stored encrypted
+		file, err := rootFS.getFile("top.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(file.Content) == "top" {
+			t.Fatal("Expected imported content to be encrypted")
+		}
+
+		_, linkErr := rootFS.Stat("dirlink/file.txt")
+		_, fileLinkErr := rootFS.Stat("filelink")
+		_, loopErr := rootFS.Stat("dir/loop")
+		if follow {
+			if linkErr != nil || fileLinkErr != nil {
+				t.Fatalf("Expected followed links to be copied, got: %v, %v", linkErr, fileLinkErr)
+			}
+		} else if linkErr == nil || fileLinkErr == nil {
+			t.Fatal("Expected links to be skipped")
+		}
+		if loopErr == nil {
+			t.Fatalf("follow=%v: expected the loop to be skipped", follow)
+		}
+	}
+}
+
+// TestWriteToDir tests exporting the filesystem to disk
+func TestWriteToDir(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("osdir-key")))
+	if err := rootFS.MkdirAll("dir/sub", 0750); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"top.txt":          "top",
+		"dir/file.txt":     "file",
+		"dir/sub/deep.txt": "deep",
+	}
+	mtime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for path, content := range files {
+		if err := rootFS.WriteFile(path, []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := rootFS.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rootFS.Symlink("/dir/file.txt", "dir/sub/abs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Symlink("../top.txt", "dir/rel"); err != nil {
+		t.Fatal(err)
+	}
+	// Adding entries changes the modification time of the directory
+	if err := rootFS.Chtimes("dir", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	osDir := filepath.Join(t.TempDir(), "out")
+	if err := rootFS.WriteToDir(osDir); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range files {
+		osPath := filepath.Join(osDir, filepath.FromSlash(path))
+		content, err := os.ReadFile(osPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want {
+			t.Fatalf("Expected %q for %s, got %q", want, path, content)
+		}
+		info, err := os.Stat(osPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Fatalf("Expected mode 0640 for %s, got %v", path, info.Mode().Perm())
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Fatalf("Expected mtime %v for %s, got %v", mtime, path, info.ModTime())
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(osDir, "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0750 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("Expected dir with mode 0750 and mtime %v, got %v and %v", mtime, info.Mode().Perm(), info.ModTime())
+	}
+
+	for link, want := range map[string]string{"dir/sub/abs": "file", "dir/rel": "top"} {
+		content, err := os.ReadFile(filepath.Join(osDir, filepath.FromSlash(link)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want {
+			t.Fatalf("Expected %q through %s, got %q", want, link, content)
+		}
+	}
+
+	// Links pointing outside the filesystem would escape the directory
+	escaping := New()
+	if err := escaping.Symlink("../../etc/passwd", "evil"); err != nil {
+		t.Fatal(err)
+	}
+	if err := escaping.WriteToDir(t.TempDir()); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid for an escaping link, got: %v", err)
+	}
+}
+
+// TestCopyToOverwrites tests exporting again into the same directory
+func TestCopyToOverwrites(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("osdir-key")))
+	if err := rootFS.MkdirAll("dir", 0555); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", []byte("old"), 0444); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Symlink("dir/file.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	osDir := filepath.Join(t.TempDir(), "out")
+	if err := rootFS.CopyTo(osDir); err != nil {
+		t.Fatal(err)
+	}
+	// Allow the test cleanup to remove the read-only directory
+	t.Cleanup(func() { os.Chmod(filepath.Join(osDir, "dir"), 0755) })
+
+	if err := rootFS.Chmod("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Chmod("dir/file.txt", 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Chmod("dir", 0555); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.CopyTo(osDir); err != nil {
+		t.Fatalf("Expected the second copy to replace the first, got: %v", err)
+	}
+
+	for _, path := range []string{"dir/file.txt", "link"} {
+		content, err := os.ReadFile(filepath.Join(osDir, filepath.FromSlash(path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "new" {
+			t.Fatalf("Expected %q in %s, got %q", "new", path, content)
+		}
+	}
+	info, err := os.Stat(filepath.Join(osDir, "dir", "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("Expected mode 0644, got %v", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,247 @@
+package memfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	syspath "path"
+	"sort"
+)
+
+// lowerVisible reports whether the path name of the lower layer shows through
+// the overlay: it has not been removed, and no parent of it is shadowed by a
+// non-directory in the upper layer.
+func (rootFS *FS) lowerVisible(name string) bool {
+	if rootFS.lower == nil {
+		return false
+	}
+
+	rootFS.mu.Lock()
+	for p := name; ; p = syspath.Dir(p) {
+		if _, ok := rootFS.whiteouts[p]; ok {
+			rootFS.mu.Unlock()
+			return false
+		}
+		if p == "." {
+			break
+		}
+	}
+	rootFS.mu.Unlock()
+
+	for p := syspath.Dir(name); p != "."; p = syspath.Dir(p) {
+		if child, err := rootFS.lget(p); err == nil {
+			if _, ok := child.(*Dir); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// lowerStat returns the FileInfo of name in the lower layer, if it is visible
+func (rootFS *FS) lowerStat(name string) (fs.FileInfo, error) {
+	if !rootFS.lowerVisible(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return fs.Stat(rootFS.lower, name)
+}
+
+// whiteout hides name of the lower layer, if it exists there
+func (rootFS *FS) whiteout(name string) {
+	if _, err := rootFS.lowerStat(name); err != nil && name != "." {
+		return
+	}
+
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	if rootFS.whiteouts == nil {
+		rootFS.whiteouts = make(map[string]struct{})
+	}
+	rootFS.whiteouts[name] = struct{}{}
+}
+
+// openLower opens name from the lower layer. Files are read into a handle
+// like the ones of the upper layer, directories are merged with the upper layer.
+func (rootFS *FS) openLower(name string) (fs.File, error) {
+	info, err := rootFS.lowerStat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &overlayDir{fs: rootFS, name: name}, nil
+	}
+
+	content, err := fs.ReadFile(rootFS.lower, name)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{
+		Name:    info.Name(),
+		Perm:    info.Mode().Perm(),
+		ModTime: info.ModTime(),
+	}
+	return file.handle(content), nil
+}
+
+// copyUp copies the file at path from the lower to the upper layer, so that it
+// can be modified. Nothing is done if path already exists in the upper layer.
+func (rootFS *FS) copyUp(path string) error {
+	if rootFS.lower == nil {
+		return nil
+	}
+	if _, err := rootFS.lget(path); !errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	info, err := rootFS.lowerStat(path)
+	if err != nil {
+		// Missing files are reported by the caller
+		return nil
+	}
+
+	if info.IsDir() {
+		return rootFS.copyUpDir(path)
+	}
+
+	content, err := fs.ReadFile(rootFS.lower, path)
+	if err != nil {
+		return err
+	}
+	if err := rootFS.copyUpDir(syspath.Dir(path)); err != nil {
+		return err
+	}
+	if err := rootFS.WriteFile(path, content, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return rootFS.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+// copyUpDir creates the directory dir of the lower layer in the upper layer,
+// so that files can be created in it
+func (rootFS *FS) copyUpDir(dir string) error {
+	if rootFS.lower == nil || dir == "." || dir == "" {
+		return nil
+	}
+	if _, err := rootFS.getDir(dir); err == nil {
+		return nil
+	}
+
+	info, err := rootFS.lowerStat(dir)
+	if err != nil || !info.IsDir() {
+		// Missing directories are reported by the caller
+		return nil
+	}
+	return rootFS.MkdirAll(dir, info.Mode().Perm())
+}
+
+// overlayDir is the handle of a directory of an overlay filesystem.
+// It lists the entries of both layers; an entry of the upper layer hides
+// the entry with the same name in the lower layer.
+type overlayDir struct {
+	fs      *FS
+	name    string
+	upper   *Dir // nil if the directory only exists in the lower layer
+	entries []fs.DirEntry
+	read    bool
+	idx     int
+}
+
+func (d *overlayDir) Stat() (fs.FileInfo, error) {
+	if d.upper != nil {
+		return (&fhDir{dir: d.upper}).Stat()
+	}
+	return d.fs.lowerStat(d.name)
+}
+
+func (d *overlayDir) Read(b []byte) (int, error) {
+	return 0, errors.New("is a directory")
+}
+
+func (d *overlayDir) Close() error {
+	return nil
+}
+
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.merge()
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+
+	rest := d.entries[d.idx:]
+	if n <= 0 {
+		d.idx = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.idx += n
+	return rest[:n], nil
+}
+
+// merge returns the entries of both layers sorted by name
+func (d *overlayDir) merge() ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	seen := make(map[string]bool)
+
+	if d.upper != nil {
+		upper, err := (&fhDir{dir: d.upper, fs: d.fs}).ReadDir(-1)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range upper {
+			seen[entry.Name()] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	if d.fs.lowerVisible(d.name) {
+		lower, err := fs.ReadDir(d.fs.lower, d.name)
+		if err != nil && d.upper == nil {
+			return nil, err
+		}
+		for _, entry := range lower {
+			if seen[entry.Name()] || !d.fs.lowerVisible(syspath.Join(d.name, entry.Name())) {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// checkOverlayEmpty returns an error if the directory path of an overlay
+// filesystem has entries in either layer
+func (rootFS *FS) checkOverlayEmpty(path string) error {
+	info, err := rootFS.lowerStat(path)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+
+	d := &overlayDir{fs: rootFS, name: path}
+	if dir, err := rootFS.getDir(path); err == nil {
+		d.upper = dir
+	}
+	entries, err := d.merge()
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("directory not empty: %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package memfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newOverlayTestFS(t *testing.T) (*FS, fstest.MapFS) {
+	t.Helper()
+
+	lower := fstest.MapFS{
+		"base.txt":         {Data: []byte("base"), Mode: 0644},
+		"shared.txt":       {Data: []byte("lower shared"), Mode: 0644},
+		"dir/lower.txt":    {Data: []byte("lower"), Mode: 0600, ModTime: time.Unix(1000, 0)},
+		"dir/sub/deep.txt": {Data: []byte("deep"), Mode: 0644},
+		"gone/file.txt":    {Data: []byte("gone"), Mode: 0644},
+	}
+
+	rootFS := New(WithOverlay(lower))
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/upper.txt", []byte("upper"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("shared.txt", []byte("upper shared"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return rootFS, lower
+}
+
+// TestOverlayRead tests that reads fall through to the lower layer
+func TestOverlayRead(t *testing.T) {
+	rootFS, _ := newOverlayTestFS(t)
+
+	expected := map[string]string{
+		"base.txt":         "base",
+		"shared.txt":       "upper shared",
+		"dir/lower.txt":    "lower",
+		"dir/upper.txt":    "upper",
+		"dir/sub/deep.txt": "deep",
+	}
+	for path, want := range expected {
+		content, err := fs.ReadFile(rootFS, path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if string(content) != want {
+			t.Fatalf("Expected %q for %s, got %q", want, path, content)
+		}
+	}
+
+	entries, err := fs.ReadDir(rootFS, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"lower.txt", "sub", "upper.txt"}; !slices.Equal(names, want) {
+		t.Fatalf("Expected merged entries %v, got %v", want, names)
+	}
+
+	info, err := rootFS.Stat("dir/lower.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0600 || info.Size() != int64(len("lower")) {
+		t.Fatalf("Expected lower file info, got mode %v size %d", info.Mode(), info.Size())
+	}
+
+	matches, err := rootFS.Glob("dir/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dir/lower.txt", "dir/upper.txt"}; !slices.Equal(matches, want) {
+		t.Fatalf("Expected %v, got %v", want, matches)
+	}
+
+	if err := fstest.TestFS(rootFS, "base.txt", "shared.txt", "dir/lower.txt", "dir/upper.txt", "dir/sub/deep.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOverlayWrite tests that writes go to the upper layer only
+func TestOverlayWrite(t *testing.T) {
+	rootFS, lower := newOverlayTestFS(t)
+
+	// Creating a file in a directory that only exists below
+	if err := rootFS.WriteFile("dir/sub/new.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lower["dir/sub/new.txt"]; ok {
+		t.Fatal("Expected the lower layer to stay untouched")
+	}
+
+	// Appending to a file of the lower layer copies it up first
+	f, err := rootFS.OpenFile("dir/lower.txt", os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw := f.(*FileWriter)
+	if _, err := fw.Write([]byte(" appended")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := fs.ReadFile(rootFS, "dir/lower.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "lower appended" {
+		t.Fatalf("Expected 'lower appended', got %q", content)
+	}
+	if string(lower["dir/lower.txt"].Data) != "lower" {
+		t.Fatalf("Expected the lower file to stay unchanged, got %q", lower["dir/lower.txt"].Data)
+	}
+
+	info, err := rootFS.Stat("dir/lower.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0600 {
+		t.Fatalf("Expected copied up file to keep mode 0600, got %v", info.Mode())
+	}
+
+	// Metadata changes copy up as well
+	if err := rootFS.Chmod("base.txt", 0400); err != nil {
+		t.Fatal(err)
+	}
+	info, err = rootFS.Stat("base.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0400 {
+		t.Fatalf("Expected mode 0400, got %v", info.Mode())
+	}
+}
+
+// TestOverlayRemove tests that removing lower files hides them
+func TestOverlayRemove(t *testing.T) {
+	rootFS, lower := newOverlayTestFS(t)
+
+	if err := rootFS.Remove("base.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rootFS.Open("base.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected removed lower file to be gone, got: %v", err)
+	}
+	if _, ok := lower["base.txt"]; !ok {
+		t.Fatal("Expected the lower layer to stay untouched")
+	}
+
+	// A file in both layers is gone from both
+	if err := rootFS.Remove("shared.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rootFS.Stat("shared.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected shared.txt to be gone, got: %v", err)
+	}
+
+	// Directories with lower entries are not empty
+	if err := rootFS.Remove("gone"); err == nil {
+		t.Fatal("Expected error when removing a non-empty lower directory")
+	}
+	if err := rootFS.RemoveAll("gone"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rootFS.Stat("gone/file.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected gone/file.txt to be hidden, got: %v", err)
+	}
+
+	// Recreating a removed path does not bring back the lower entries
+	if err := rootFS.MkdirAll("gone", 0755); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := fs.ReadDir(rootFS, "gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("Expected recreated directory to be empty, got %d entries", len(entries))
+	}
+
+	var paths []string
+	err = fs.WalkDir(rootFS, ".", func(path string, d fs.DirEntry, err error) error {
+		paths = append(paths, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", "dir", "dir/lower.txt", "dir/sub", "dir/sub/deep.txt", "dir/upper.txt", "gone"}
+	if !slices.Equal(paths, want) {
+		t.Fatalf("Expected walk %v, got %v", want, paths)
+	}
+
+	if err := rootFS.RemoveAll("."); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = fs.ReadDir(rootFS, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("Expected empty overlay after RemoveAll(\".\"), got %d entries", len(entries))
+	}
+}
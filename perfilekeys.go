@@ -0,0 +1,36 @@
+package memfs
+
+import (
+	syspath "path"
+)
+
+// keyPath returns the path the key of the file at path is derived from with
+// per-file keys: the path with symbolic links resolved, relative to the root
+// of the filesystem that Sub was called on, if any
+func (rootFS *FS) keyPath(path string) string {
+	if path == "." {
+		path = ""
+	}
+	if resolved, err := rootFS.resolve(path, true); err == nil {
+		path = resolved
+	}
+	return syspath.Join(rootFS.keyPrefix, path)
+}
+
+// setKeyPaths sets the key path of every file below dir, which is found at
+// prefix, and of its previous versions after the tree has been loaded. The
+// caller must either own the tree or hold the locks of all its directories.
+func setKeyPaths(dir *Dir, prefix string) {
+	for name, child := range dir.Children {
+		path := syspath.Join(prefix, name)
+		switch c := child.(type) {
+		case *Dir:
+			setKeyPaths(c, path)
+		case *File:
+			c.keyPath = path
+			for _, v := range c.History {
+				v.keyPath = path
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package memfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+// TestReadOnly tests that a read-only filesystem rejects every mutation
+func TestReadOnly(t *testing.T) {
+	rootFS := New()
+
+	if err := rootFS.MkdirAll("dir1", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir1/file1.txt", []byte("file1 content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Open a writer before freezing
+	w, err := rootFS.Create("dir1/file2.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootFS.Freeze()
+
+	mutations := map[string]func() error{
+		"WriteFile": func() error { return rootFS.WriteFile("dir1/file1.txt", []byte("new"), 0644) },
+		"MkdirAll":  func() error { return rootFS.MkdirAll("dir2", 0755) },
+		"Remove":    func() error { return rootFS.Remove("dir1/file1.txt") },
+		"RemoveAll": func() error { return rootFS.RemoveAll("dir1") },
+		"Truncate":  func() error { return rootFS.Truncate("dir1/file1.txt", 0) },
+		"Create": func() error {
+			_, err := rootFS.Create("dir1/file3.txt")
+			return err
+		},
+		"OpenFile": func() error {
+			_, err := rootFS.OpenFile("dir1/file1.txt", os.O_WRONLY, 0644)
+			return err
+		},
+		"Write": func() error {
+			_, err := w.Write([]byte("data"))
+			return err
+		},
+	}
+
+	for name, mutate := range mutations {
+		if err := mutate(); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("%s: expected fs.ErrPermission, got: %v", name, err)
+		}
+	}
+
+	// Reads keep working
+	content, err := fs.ReadFile(rootFS, "dir1/file1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "file1 content" {
+		t.Fatalf("Expected 'file1 content', got %q", content)
+	}
+
+	if _, err := rootFS.OpenFile("dir1/file1.txt", os.O_RDONLY, 0); err != nil {
+		t.Fatalf("Expected read-only OpenFile to succeed, got: %v", err)
+	}
+
+	var paths []string
+	err = fs.WalkDir(rootFS, ".", func(path string, d fs.DirEntry, err error) error {
+		paths = append(paths, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 4 {
+		t.Fatalf("Expected 4 walked paths, got %v", paths)
+	}
+}
+
+// TestWithReadOnlyOption tests the WithReadOnly option
+func TestWithReadOnlyOption(t *testing.T) {
+	rootFS := New(WithReadOnly(true))
+
+	err := rootFS.WriteFile("file.txt", []byte("content"), 0644)
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Expected fs.ErrPermission, got: %v", err)
+	}
+
+	rootFS = New(WithReadOnly(false))
+	if err := rootFS.WriteFile("file.txt", []byte("content"), 0644); err != nil {
+		t.Fatalf("Expected write to succeed, got: %v", err)
+	}
+}
+
+// TestSetReadOnly tests toggling the read-only mode after loading
+func TestSetReadOnly(t *testing.T) {
+	rootFS := New()
+	if err := rootFS.WriteFile("file.txt", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rootFS.SetReadOnly(true)
+	if err := rootFS.WriteFile("file.txt", []byte("new"), 0644); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Expected fs.ErrPermission, got: %v", err)
+	}
+	if err := rootFS.Remove("file.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Expected fs.ErrPermission, got: %v", err)
+	}
+
+	rootFS.SetReadOnly(false)
+	if err := rootFS.WriteFile("file.txt", []byte("new"), 0644); err != nil {
+		t.Fatalf("Expected write to succeed, got: %v", err)
+	}
+	content, err := fs.ReadFile(rootFS, "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "new" {
+		t.Fatalf("Expected 'new', got %q", content)
+	}
+}
@@ -0,0 +1,106 @@
+package memfs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// FileReadWriter is a file handle returned by OpenFile for O_RDWR. Reads and
+// writes share a single offset that starts at the beginning of the file and
+// can be moved with Seek. If the file was opened with O_APPEND, every write
+// goes to the end of the file.
+//
+// Like a FileWriter, it works on the decrypted content while open and the
+// content is encrypted again when the last writer of the file is closed.
+type FileReadWriter struct {
+	fw     *FileWriter
+	offset int64
+	append bool
+}
+
+// newFileReadWriter returns a FileReadWriter for file and marks the file as being written
+func (rootFS *FS) newFileReadWriter(file *File, append bool) (*FileReadWriter, error) {
+	fw, err := rootFS.newFileWriter(file)
+	if err != nil {
+		return nil, err
+	}
+	return &FileReadWriter{
+		fw:     fw,
+		append: append,
+	}, nil
+}
+
+// Read reads up to len(b) bytes from the current offset
+func (rw *FileReadWriter) Read(b []byte) (int, error) {
+	if rw.fw.closed {
+		return 0, fs.ErrClosed
+	}
+
+	rw.fw.fs.mu.Lock()
+	defer rw.fw.fs.mu.Unlock()
+
+	content := rw.fw.file.Content
+	if rw.offset >= int64(len(content)) {
+		return 0, io.EOF
+	}
+	n := copy(b, content[rw.offset:])
+	rw.offset += int64(n)
+	return n, nil
+}
+
+// Write writes b at the current offset, or at the end of the file with O_APPEND
+func (rw *FileReadWriter) Write(b []byte) (int, error) {
+	if rw.fw.closed {
+		return 0, fs.ErrClosed
+	}
+
+	rw.fw.fs.mu.Lock()
+	defer rw.fw.fs.mu.Unlock()
+
+	if rw.append {
+		rw.offset = int64(len(rw.fw.file.Content))
+	}
+	n, err := rw.fw.writeAt(b, rw.offset)
+	rw.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read or Write, interpreted according to whence
+func (rw *FileReadWriter) Seek(offset int64, whence int) (int64, error) {
+	if rw.fw.closed {
+		return 0, fs.ErrClosed
+	}
+
+	rw.fw.fs.mu.Lock()
+	defer rw.fw.fs.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rw.offset
+	case io.SeekEnd:
+		offset += int64(len(rw.fw.file.Content))
+	default:
+		return 0, fmt.Errorf("invalid whence: %d: %w", whence, fs.ErrInvalid)
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset: %d: %w", offset, fs.ErrInvalid)
+	}
+	rw.offset = offset
+	return offset, nil
+}
+
+// Stat returns a FileInfo describing the file
+func (rw *FileReadWriter) Stat() (fs.FileInfo, error) {
+	if rw.fw.closed {
+		return nil, fs.ErrClosed
+	}
+	return rw.fw.fs.fileStat(rw.fw.file), nil
+}
+
+// Close closes the handle, encrypting the content if it was the last writer
+func (rw *FileReadWriter) Close() error {
+	return rw.fw.Close()
+}
@@ -2,7 +2,9 @@ package memfs
 
 import (
 	"errors"
+	"io"
 	"io/fs"
+	"sync"
 	"testing"
 )
 
@@ -219,3 +221,120 @@ func TestRemoveEdgeCases(t *testing.T) {
 		t.Fatalf("Failed to remove directory: %v", err)
 	}
 }
+
+// TestWalkDuringRemoveAll walks the filesystem while subtrees are removed
+// and created again; run with -race. A walk may miss removed entries, but
+// must neither panic nor fail with anything but fs.ErrNotExist.
+func TestWalkDuringRemoveAll(t *testing.T) {
+	rootFS := New()
+
+	populate := func() error {
+		for _, dir := range []string{"tree/a/b", "tree/c"} {
+			if err := rootFS.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			for _, name := range []string{"1.txt", "2.txt", "3.txt"} {
+				if err := rootFS.WriteFile(dir+"/"+name, []byte(name), 0644); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := populate(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := rootFS.RemoveAll("tree"); err != nil {
+				t.Errorf("RemoveAll: %v", err)
+				return
+			}
+			if err := populate(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("populate: %v", err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		err := fs.WalkDir(rootFS, ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("WalkDir: %v", err)
+		}
+
+		rootFS.Walk(".", func(path string, d fs.DirEntry, err error) error {
+			return nil
+		}, nil)
+		rootFS.DirSize(".")
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+// TestReadDirBatches reads a directory a few entries at a time while it is changed
+func TestReadDirBatches(t *testing.T) {
+	rootFS := New()
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		if err := rootFS.WriteFile("dir/"+name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := rootFS.Open("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d := f.(fs.ReadDirFile)
+
+	seen := make(map[string]bool)
+	for {
+		entries, err := d.ReadDir(2)
+		for _, e := range entries {
+			if seen[e.Name()] {
+				t.Fatalf("Entry %s returned twice", e.Name())
+			}
+			seen[e.Name()] = true
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			t.Fatal("Expected io.EOF instead of an empty batch")
+		}
+
+		// Changes after the first batch do not affect the listing
+		if err := rootFS.WriteFile("dir/z", nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := rootFS.Remove("dir/e"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal(err)
+		}
+	}
+	if len(seen) != 5 {
+		t.Fatalf("Expected the 5 entries at the time of the first read, got %v", seen)
+	}
+}
@@ -0,0 +1,62 @@
+package memfs
+
+import (
+	"slices"
+	"sort"
+)
+
+// The helpers below must be used for every change to Dir.Children so that
+// directories created with WithSortedDirs keep their names in order.
+// Callers must hold d.mu.
+
+// setChild adds or replaces the child called name
+func (d *Dir) setChild(name string, child childI) {
+	if _, exists := d.Children[name]; !exists && d.sorted {
+		i, _ := slices.BinarySearch(d.names, name)
+		d.names = slices.Insert(d.names, i, name)
+	}
+	d.Children[name] = child
+}
+
+// deleteChild removes the child called name, if any
+func (d *Dir) deleteChild(name string) {
+	if _, exists := d.Children[name]; !exists {
+		return
+	}
+	if d.sorted {
+		if i, found := slices.BinarySearch(d.names, name); found {
+			d.names = slices.Delete(d.names, i, i+1)
+		}
+	}
+	delete(d.Children, name)
+}
+
+// clearChildren removes all children
+func (d *Dir) clearChildren() {
+	d.Children = make(map[string]childI)
+	d.names = nil
+}
+
+// childNames returns the names of all children. For sorted directories the
+// names are in lexical order and the returned slice must not be modified;
+// otherwise they are in map order.
+func (d *Dir) childNames() []string {
+	if d.sorted {
+		return d.names
+	}
+	names := make([]string, 0, len(d.Children))
+	for name := range d.Children {
+		names = append(names, name)
+	}
+	return names
+}
+
+// sortedChildNames returns a copy of the names of all children in lexical order
+func (d *Dir) sortedChildNames() []string {
+	if d.sorted {
+		return slices.Clone(d.names)
+	}
+	names := d.childNames()
+	sort.Strings(names)
+	return names
+}
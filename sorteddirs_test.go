@@ -0,0 +1,99 @@
+package memfs
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+// TestSortedDirs tests that listings are sorted with the WithSortedDirs option
+func TestSortedDirs(t *testing.T) {
+	rootFS := New(WithSortedDirs())
+
+	for _, name := range []string{"m.txt", "b.txt", "z.txt", "a.txt", "k.txt"} {
+		if err := rootFS.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rootFS.MkdirAll("dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"y", "c", "q"} {
+		if err := rootFS.WriteFile("dir/"+name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rootFS.Symlink("a.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacing and removing entries keeps the order intact
+	if err := rootFS.WriteFile("m.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Remove("k.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.RemoveAll("dir/q"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := slices.Clone(rootFS.dir.childNames())
+	expected := []string{"a.txt", "b.txt", "dir", "link", "m.txt", "z.txt"}
+	if !slices.Equal(entries, expected) {
+		t.Fatalf("Expected %v, got %v", expected, entries)
+	}
+
+	// The directory handle lists in order without fs.ReadDir sorting
+	f, err := rootFS.Open("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	list, err := f.(fs.ReadDirFile).ReadDir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range list {
+		names = append(names, entry.Name())
+	}
+	expected = []string{"c", "sub", "y"}
+	if !slices.Equal(names, expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+
+	if err := rootFS.RemoveAll("."); err != nil {
+		t.Fatal(err)
+	}
+	if names := rootFS.dir.childNames(); len(names) != 0 {
+		t.Fatalf("Expected no names after RemoveAll(\".\"), got %v", names)
+	}
+}
+
+func benchmarkReadDir(b *testing.B, opts ...Option) {
+	rootFS := New(opts...)
+	for i := 0; i < 1000; i++ {
+		if err := rootFS.WriteFile(fmt.Sprintf("file%04d.txt", i), []byte("x"), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fs.ReadDir(rootFS, "."); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadDir measures listing a directory with 1000 files
+func BenchmarkReadDir(b *testing.B) {
+	benchmarkReadDir(b)
+}
+
+// BenchmarkReadDirSorted measures the same listing with WithSortedDirs
+func BenchmarkReadDirSorted(b *testing.B) {
+	benchmarkReadDir(b, WithSortedDirs())
+}
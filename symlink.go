@@ -0,0 +1,229 @@
+package memfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	syspath "path"
+	"strings"
+	"time"
+)
+
+// defaultMaxSymlinkHops is the number of symbolic links followed while
+// resolving a single path before giving up, see WithMaxSymlinkDepth
+const defaultMaxSymlinkHops = 40
+
+// ErrTooManyLinks is returned when resolving a path requires following more
+// symbolic links than allowed, which usually means the links form a loop.
+var ErrTooManyLinks = errors.New("too many levels of symbolic links")
+
+// Symlink represents a symbolic link in the filesystem.
+// A Target starting with "/" is resolved from the root of the filesystem,
+// any other Target is resolved relative to the directory holding the link.
+type Symlink struct {
+	Name    string
+	Target  string
+	ModTime time.Time
+}
+
+func (l *Symlink) stat() fs.FileInfo {
+	return &fileInfo{
+		name:    l.Name,
+		size:    int64(len(l.Target)),
+		modTime: l.ModTime,
+		mode:    fs.ModeSymlink | 0777,
+	}
+}
+
+// Symlink creates linkpath as a symbolic link to target.
+// The target does not need to exist. If linkpath already exists,
+// an error wrapping fs.ErrExist is returned.
+func (rootFS *FS) Symlink(target, linkpath string) error {
+	if !fs.ValidPath(linkpath) || linkpath == "." {
+		return fmt.Errorf("invalid path: %s: %w", linkpath, fs.ErrInvalid)
+	}
+
+	if target == "" {
+		return fmt.Errorf("empty symlink target: %s: %w", linkpath, fs.ErrInvalid)
+	}
+
+	if err := rootFS.checkWritable(linkpath); err != nil {
+		return err
+	}
+
+	dirPart, filePart := syspath.Split(linkpath)
+	dirPart = strings.TrimSuffix(dirPart, "/")
+
+	if err := rootFS.copyUpDir(dirPart); err != nil {
+		return err
+	}
+
+	dir, err := rootFS.getDir(dirPart)
+	if err != nil {
+		return err
+	}
+	keyPath := syspath.Join(rootFS.keyPath(dirPart), filePart)
+
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+
+	if _, exists := dir.Children[filePart]; exists {
+		return fmt.Errorf("file exists: %s: %w", linkpath, fs.ErrExist)
+	}
+
+	now := time.Now()
+	dir.setChild(filePart, &Symlink{
+		Name:    filePart,
+		Target:  target,
+		ModTime: now,
+	})
+	dir.ModTime = now
+	rootFS.notify(OpCreate, keyPath)
+	return nil
+}
+
+// Readlink returns the target of the symbolic link at path without following it.
+func (rootFS *FS) Readlink(path string) (string, error) {
+	if !fs.ValidPath(path) {
+		return "", fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
+	}
+
+	child, err := rootFS.lget(path)
+	if err != nil {
+		return "", err
+	}
+
+	link, ok := child.(*Symlink)
+	if !ok {
+		return "", fmt.Errorf("not a symlink: %s: %w", path, fs.ErrInvalid)
+	}
+	return link.Target, nil
+}
+
+// Lstat returns a FileInfo describing the named file. If the file is a
+// symbolic link, the FileInfo describes the link itself: its mode has
+// fs.ModeSymlink set and its size is the length of the target.
+func (rootFS *FS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	child, err := rootFS.lget(name)
+	if err != nil {
+		if rootFS.lower != nil && errors.Is(err, fs.ErrNotExist) {
+			return rootFS.lowerStat(name)
+		}
+		return nil, err
+	}
+
+	if link, ok := child.(*Symlink); ok {
+		return link.stat(), nil
+	}
+	return fs.Stat(rootFS, name)
+}
+
+// putSymlink creates a symbolic link at path, creating any missing parent
+// directories with mode 0755. It is used when importing archives.
+func (rootFS *FS) putSymlink(path, target string, modTime time.Time) error {
+	if dirPart := syspath.Dir(path); dirPart != "." {
+		if err := rootFS.MkdirAll(dirPart, 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := rootFS.Symlink(target, path); err != nil {
+		return err
+	}
+
+	child, err := rootFS.lget(path)
+	if err != nil {
+		return err
+	}
+	child.(*Symlink).ModTime = modTime
+	return nil
+}
+
+// resolve returns path with every symbolic link in it replaced by its target.
+// A symbolic link in the final element is only followed if followLast is set.
+// Resolution stops at the first element that does not exist or is not a
+// directory; the remaining elements are kept, so callers report those errors.
+func (rootFS *FS) resolve(path string, followLast bool) (string, error) {
+	if path == "." {
+		path = ""
+	}
+
+	for hops := 0; ; hops++ {
+		next, followed, err := rootFS.followFirstLink(path, followLast)
+		if err != nil {
+			return "", err
+		}
+		if !followed {
+			return path, nil
+		}
+		if hops >= rootFS.symlinkHops() {
+			return "", fmt.Errorf("resolving %s: %w", path, ErrTooManyLinks)
+		}
+		path = next
+	}
+}
+
+// symlinkHops returns the number of symbolic links resolve follows
+func (rootFS *FS) symlinkHops() int {
+	if rootFS.maxSymlinkHops > 0 {
+		return rootFS.maxSymlinkHops
+	}
+	return defaultMaxSymlinkHops
+}
+
+// followFirstLink replaces the first symbolic link in path by its target
+// and reports whether there was a link to follow.
+func (rootFS *FS) followFirstLink(path string, followLast bool) (string, bool, error) {
+	if path == "" {
+		return "", false, nil
+	}
+
+	parts := strings.Split(path, "/")
+	cur := rootFS.dir
+	for i, part := range parts {
+		cur.mu.RLock()
+		child := cur.Children[part]
+		cur.mu.RUnlock()
+
+		switch c := child.(type) {
+		case *Dir:
+			cur = c
+		case *Symlink:
+			if i == len(parts)-1 && !followLast {
+				return path, false, nil
+			}
+
+			target, err := linkTarget(syspath.Join(parts[:i]...), c.Target)
+			if err != nil {
+				return "", false, err
+			}
+			return syspath.Join(append([]string{target}, parts[i+1:]...)...), true, nil
+		default:
+			return path, false, nil
+		}
+	}
+
+	return path, false, nil
+}
+
+// linkTarget returns the path that target refers to for a link inside dir
+func linkTarget(dir, target string) (string, error) {
+	var p string
+	if strings.HasPrefix(target, "/") {
+		p = syspath.Clean(strings.TrimLeft(target, "/"))
+	} else {
+		p = syspath.Join(dir, target)
+	}
+
+	if p == "." {
+		return "", nil
+	}
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("symlink target outside of filesystem: %s: %w", target, fs.ErrNotExist)
+	}
+	return p, nil
+}
@@ -0,0 +1,298 @@
+package memfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestSymlink(t *testing.T) {
+	rootFS := New()
+
+	if err := rootFS.MkdirAll("dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/sub/file.txt", []byte("target content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Relative link, absolute link and a link to a directory
+	if err := rootFS.Symlink("sub/file.txt", "dir/rel"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Symlink("/dir/sub/file.txt", "abs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Symlink("dir/sub", "subdir"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"dir/rel", "abs", "subdir/file.txt"} {
+		content, err := fs.ReadFile(rootFS, path)
+		if err != nil {
+			t.Fatalf("Failed to read through link %s: %v", path, err)
+		}
+		if string(content) != "target content" {
+			t.Fatalf("Expected 'target content' via %s, got %q", path, content)
+		}
+	}
+
+	target, err := rootFS.Readlink("dir/rel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "sub/file.txt" {
+		t.Fatalf("Expected target 'sub/file.txt', got %q", target)
+	}
+
+	if _, err := rootFS.Readlink("dir/sub/file.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid for Readlink on a file, got: %v", err)
+	}
+
+	if err := rootFS.Symlink("elsewhere", "abs"); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("Expected fs.ErrExist for existing link path, got: %v", err)
+	}
+
+	// Writing through a link updates the target
+	if err := rootFS.WriteFile("abs", []byte("updated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content, err := fs.ReadFile(rootFS, "dir/sub/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "updated" {
+		t.Fatalf("Expected 'updated', got %q", content)
+	}
+
+	// WalkDir reports links without following them
+	var links []string
+	err = fs.WalkDir(rootFS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			links = append(links, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 3 {
+		t.Fatalf("Expected 3 links during walk, got %v", links)
+	}
+
+	// Removing a link keeps the target
+	if err := rootFS.Remove("abs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.RemoveAll("subdir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rootFS.lget("subdir"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected subdir link to be removed, got: %v", err)
+	}
+	if _, err := fs.ReadFile(rootFS, "dir/sub/file.txt"); err != nil {
+		t.Fatalf("Expected target to survive link removal, got: %v", err)
+	}
+}
+
+func TestSymlinkLoop(t *testing.T) {
+	rootFS := New()
+
+	if err := rootFS.Symlink("b", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Symlink("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := rootFS.Open("a")
+	if !errors.Is(err, ErrTooManyLinks) {
+		t.Fatalf("Expected ErrTooManyLinks, got: %v", err)
+	}
+
+	// A dangling link reports a missing file
+	if err := rootFS.Symlink("missing.txt", "dangling"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rootFS.Open("dangling"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for dangling link, got: %v", err)
+	}
+
+	// A link may not escape the filesystem
+	if err := rootFS.Symlink("../outside", "escape"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rootFS.Open("escape"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for escaping link, got: %v", err)
+	}
+}
+
+func TestLstat(t *testing.T) {
+	rootFS := New()
+
+	if err := rootFS.WriteFile("file.txt", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Symlink("file.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := rootFS.Lstat("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&fs.ModeSymlink == 0 {
+		t.Fatalf("Expected ModeSymlink, got mode %v", info.Mode())
+	}
+	if info.Size() != int64(len("file.txt")) {
+		t.Fatalf("Expected size %d, got %d", len("file.txt"), info.Size())
+	}
+	if info.Name() != "link" {
+		t.Fatalf("Expected name 'link', got %q", info.Name())
+	}
+
+	// Stat follows the link
+	info, err = fs.Stat(rootFS, "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&fs.ModeSymlink != 0 || info.Size() != int64(len("content")) {
+		t.Fatalf("Expected Stat to describe the target, got mode %v size %d", info.Mode(), info.Size())
+	}
+
+	// Regular files and dangling links
+	info, err = rootFS.Lstat("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Mode().IsRegular() {
+		t.Fatalf("Expected regular file, got mode %v", info.Mode())
+	}
+
+	if err := rootFS.Symlink("missing.txt", "dangling"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rootFS.Lstat("dangling"); err != nil {
+		t.Fatalf("Expected Lstat on dangling link to succeed, got: %v", err)
+	}
+	if _, err := rootFS.Lstat("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist, got: %v", err)
+	}
+}
+
+func TestSymlinkPersistence(t *testing.T) {
+	rootFS := New()
+
+	if err := rootFS.WriteFile("file.txt", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Symlink("../file.txt", "dir/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	gobFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := rootFS.ExportToTar(&buf); err != nil {
+		t.Fatal(err)
+	}
+	tarFS, err := ImportFromTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := rootFS.ExportToZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zipFS, err := ImportFromZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, loadedFS := range map[string]*FS{"gob": gobFS, "tar": tarFS, "zip": zipFS} {
+		target, err := loadedFS.Readlink("dir/link")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if target != "../file.txt" {
+			t.Fatalf("%s: expected target '../file.txt', got %q", name, target)
+		}
+
+		content, err := fs.ReadFile(loadedFS, "dir/link")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(content) != "content" {
+			t.Fatalf("%s: expected 'content', got %q", name, content)
+		}
+	}
+}
+
+func TestImportFromTarSymlink(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "dir/link", Linkname: "file.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedFS, err := ImportFromTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := loadedFS.Readlink("dir/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "file.txt" {
+		t.Fatalf("Expected target 'file.txt', got %q", target)
+	}
+}
+
+func TestMaxSymlinkDepth(t *testing.T) {
+	// A chain of three links needs three hops
+	newChain := func(opts ...Option) *FS {
+		rootFS := New(opts...)
+		if err := rootFS.WriteFile("file.txt", []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, link := range [][2]string{{"file.txt", "c"}, {"c", "b"}, {"b", "a"}} {
+			if err := rootFS.Symlink(link[0], link[1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rootFS
+	}
+
+	if _, err := newChain(WithMaxSymlinkDepth(3)).ReadFile("a"); err != nil {
+		t.Fatalf("Expected the chain to resolve within 3 hops, got: %v", err)
+	}
+	if _, err := newChain(WithMaxSymlinkDepth(2)).ReadFile("a"); !errors.Is(err, ErrTooManyLinks) {
+		t.Fatalf("Expected ErrTooManyLinks with 2 hops, got: %v", err)
+	}
+	if _, err := newChain(WithMaxSymlinkDepth(2)).Clone().ReadFile("a"); !errors.Is(err, ErrTooManyLinks) {
+		t.Fatalf("Expected a clone to keep the depth, got: %v", err)
+	}
+	if _, err := newChain(WithMaxSymlinkDepth(0)).ReadFile("a"); err != nil {
+		t.Fatalf("Expected the default depth for 0, got: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package memfs
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportToTarFile writes the entire filesystem to a tar archive on disk
+func (rootFS *FS) ExportToTarFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return rootFS.ExportToTar(f)
+}
+
+// ExportToTar writes the entire filesystem to w as a tar archive.
+// Directory structure, symbolic links, permissions and modification times are
+// preserved. File contents are stored exactly as they are held in memory, so
+// files of an encrypted filesystem stay encrypted inside the archive. The
+// settings they are stored with, such as the cipher, compression and HMAC,
+// are recorded in PAX records for ImportFromTar.
+func (rootFS *FS) ExportToTar(w io.Writer) error {
+	return rootFS.writeTar(w, false)
+}
+
+// WriteTar writes the entire filesystem to w as a tar archive, like
+// ExportToTar, but with the decrypted content of the files. Wrap w in a
+// gzip.Writer to produce a .tar.gz.
+func (rootFS *FS) WriteTar(w io.Writer) error {
+	return rootFS.writeTar(w, true)
+}
+
+// writeTar writes the tar archive of the filesystem to w, with the files
+// decrypted if decrypt is set
+func (rootFS *FS) writeTar(w io.Writer, decrypt bool) error {
+	tw := tar.NewWriter(w)
+
+	if !decrypt {
+		settings, err := rootFS.archiveSettings()
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag:   tar.TypeXGlobalHeader,
+			PAXRecords: map[string]string{archiveSettingsKey: settings},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := rootFS.walk(func(path string, child childI) error {
+		var (
+			header  *tar.Header
+			content []byte
+			size    int64
+		)
+		switch c := child.(type) {
+		case *Dir:
+			header = &tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     path + "/",
+				Mode:     int64(c.Perm.Perm()),
+				ModTime:  c.ModTime,
+			}
+		case *File:
+			if decrypt {
+				var err error
+				if content, err = rootFS.readContent(c); err != nil {
+					return fmt.Errorf("reading %s: %w", path, err)
+				}
+			} else {
+				rootFS.mu.Lock()
+				content = c.Content
+				size = rootFS.plainSize(c)
+				rootFS.mu.Unlock()
+			}
+
+			header = &tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     path,
+				Mode:     int64(c.Perm.Perm()),
+				ModTime:  c.ModTime,
+				Size:     int64(len(content)),
+			}
+			if !decrypt {
+				header.PAXRecords = map[string]string{archiveSizeKey: strconv.FormatInt(size, 10)}
+			}
+		case *Symlink:
+			header = &tar.Header{
+				Typeflag: tar.TypeSymlink,
+				Name:     path,
+				Linkname: c.Target,
+				Mode:     0777,
+				ModTime:  c.ModTime,
+			}
+		default:
+			return fmt.Errorf("unexpected file type in fs: %s: %w", path, fs.ErrInvalid)
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// ImportFromTarFile creates a new FS from a tar archive on disk
+func ImportFromTarFile(filename string) (*FS, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ImportFromTar(f)
+}
+
+// ImportFromTar creates a new FS from the tar archive in r, including empty
+// directories and symbolic links. Other entries, such as hard links and
+// devices, are skipped. File contents are stored as found in the archive,
+// with the storage settings recorded by ExportToTar. Like after LoadFrom, the
+// keys are not part of the archive: if it was exported from an encrypted
+// filesystem, call SetEncryptionKey with the same key before reading, and
+// SetHMACKey if the filesystem used WithHMAC.
+func ImportFromTar(r io.Reader) (*FS, error) {
+	return importTar(r, true)
+}
+
+// FromTar creates a new FS from the tar archive in r, such as one written by
+// WriteTar, including empty directories and symbolic links. Other entries,
+// such as hard links and devices, are skipped. Unlike ImportFromTar, the
+// archive is expected to hold plain content: files are stored like with
+// WriteFile, so they are encrypted and limited as configured by opts.
+// Parent directories without an entry of their own are created with mode 0755.
+func FromTar(r io.Reader, opts ...Option) (*FS, error) {
+	return importTar(r, false, opts...)
+}
+
+// importTar creates a new FS with opts from the tar archive in r. If raw is
+// set, file contents are stored as found in the archive, see ImportFromTar;
+// otherwise they are stored like with WriteFile, see FromTar.
+func importTar(r io.Reader, raw bool, opts ...Option) (*FS, error) {
+	rootFS := New(opts...)
+	tr := tar.NewReader(r)
+
+	restore := rootFS.writable()
+	defer restore()
+
+	// Directory times are set last, as adding entries may change them
+	dirTimes := make(map[string]time.Time)
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			if settings, ok := header.PAXRecords[archiveSettingsKey]; ok && raw {
+				if err := rootFS.applyArchiveSettings(settings); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		path := strings.TrimSuffix(strings.TrimPrefix(header.Name, "./"), "/")
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeSymlink:
+		default:
+			continue
+		}
+		if path == "" || path == "." {
+			// the root directory itself
+			continue
+		}
+		if !fs.ValidPath(path) {
+			return nil, fmt.Errorf("invalid path in tar archive: %s: %w", header.Name, fs.ErrInvalid)
+		}
+
+		perm := fs.FileMode(header.Mode).Perm()
+
+		if header.Typeflag == tar.TypeDir {
+			// The directory may already exist if one of its children came first
+			if err := rootFS.MkdirAll(path, perm); err != nil {
+				return nil, err
+			}
+			if err := rootFS.Chmod(path, perm); err != nil {
+				return nil, err
+			}
+			dirTimes[path] = header.ModTime
+			continue
+		}
+
+		if header.Typeflag == tar.TypeSymlink {
+			if err := rootFS.putSymlink(path, header.Linkname, header.ModTime); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		size, ok := header.PAXRecords[archiveSizeKey]
+		plainSize, err := parseArchiveSize(size, ok)
+		if err != nil {
+			return nil, err
+		}
+		if err := rootFS.importFile(path, content, plainSize, perm, header.ModTime, raw); err != nil {
+			return nil, err
+		}
+	}
+
+	for path, modTime := range dirTimes {
+		if err := rootFS.Chtimes(path, time.Time{}, modTime); err != nil {
+			return nil, err
+		}
+	}
+
+	return rootFS, nil
+}
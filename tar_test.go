@@ -0,0 +1,205 @@
+package memfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTarRoundTrip(t *testing.T) {
+	rootFS := New()
+
+	if err := rootFS.MkdirAll("foo/bar", 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.MkdirAll("empty", 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	testFiles := map[string][]byte{
+		"foo/file1.txt":     []byte("content1"),
+		"foo/bar/file2.txt": []byte("content2"),
+		"root.txt":          []byte("root content"),
+	}
+	for path, content := range testFiles {
+		if err := rootFS.WriteFile(path, content, 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := rootFS.ExportToTar(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedFS, err := ImportFromTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, content := range testFiles {
+		got, err := fs.ReadFile(loadedFS, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(content, got); diff != "" {
+			t.Fatalf("content mismatch for %s: %s", path, diff)
+		}
+	}
+
+	info, err := fs.Stat(loadedFS, "foo/file1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0o640 {
+		t.Errorf("expected mode 0640, got %v", info.Mode())
+	}
+
+	info, err = fs.Stat(loadedFS, "empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0o700 {
+		t.Errorf("expected directory with mode 0700, got %v", info.Mode())
+	}
+}
+
+func TestImportFromTarOutOfOrder(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []struct {
+		header  *tar.Header
+		content string
+	}{
+		{&tar.Header{Typeflag: tar.TypeReg, Name: "dir/sub/file.txt", Mode: 0o600, Size: 4}, "data"},
+		{&tar.Header{Typeflag: tar.TypeLink, Name: "dir/hardlink", Linkname: "dir/sub/file.txt"}, ""},
+		{&tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0o711, ModTime: modTime}, ""},
+	}
+	for _, e := range entries {
+		if err := tw.WriteHeader(e.header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedFS, err := ImportFromTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.ReadFile(loadedFS, "dir/sub/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "data" {
+		t.Errorf("expected 'data', got %q", got)
+	}
+
+	info, err := fs.Stat(loadedFS, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o711 || !info.ModTime().Equal(modTime) {
+		t.Errorf("expected late directory entry to set mode and time, got %v %v", info.Mode(), info.ModTime())
+	}
+
+	if _, err := fs.Stat(loadedFS, "dir/hardlink"); err == nil {
+		t.Error("expected hard link entry to be skipped")
+	}
+}
+
+func TestWriteTarFromTar(t *testing.T) {
+	key := []byte("tar-key")
+	srcFS := New(WithEncryption(key))
+
+	if err := srcFS.MkdirAll("dir/empty", 0o750); err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("secret data")
+	if err := srcFS.WriteFile("dir/secret.txt", secret, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := srcFS.Symlink("dir/secret.txt", "link"); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, path := range []string{"dir/secret.txt", "dir/empty", "dir"} {
+		if err := srcFS.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := srcFS.WriteTar(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), secret) {
+		t.Fatal("expected the tar archive to hold the plaintext")
+	}
+
+	rootFS, err := FromTar(&buf, WithEncryption(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.ReadFile(rootFS, "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(secret, got); diff != "" {
+		t.Fatalf("content mismatch: %s", diff)
+	}
+
+	file, err := rootFS.getFile("dir/secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(file.Content, secret) {
+		t.Fatal("imported file is stored in plaintext")
+	}
+
+	for path, mode := range map[string]fs.FileMode{
+		"dir":            fs.ModeDir | 0o750,
+		"dir/empty":      fs.ModeDir | 0o750,
+		"dir/secret.txt": 0o640,
+	} {
+		info, err := rootFS.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != mode {
+			t.Errorf("expected mode %v for %s, got %v", mode, path, info.Mode())
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("expected mtime %v for %s, got %v", mtime, path, info.ModTime())
+		}
+	}
+
+	if info, err := rootFS.Lstat("link"); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Fatalf("expected link to be a symbolic link, got %v, %v", info, err)
+	}
+}
+
+func TestTarKeepsSettings(t *testing.T) {
+	export := func(rootFS *FS, w io.Writer) error {
+		return rootFS.ExportToTar(w)
+	}
+	imp := func(data []byte) (*FS, error) {
+		return ImportFromTar(bytes.NewReader(data))
+	}
+	for name, opts := range rawArchiveCases {
+		testRawArchive(t, name, opts, export, imp)
+	}
+}
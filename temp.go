@@ -0,0 +1,177 @@
+package memfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	syspath "path"
+	"strings"
+	"time"
+)
+
+// tempAttempts bounds how often TempFile and TempDir pick a new name after
+// a collision
+const tempAttempts = 100
+
+// TempFile creates a new file in the directory dir, "." if empty, and opens
+// it for writing. The name of the file is pattern with a random string in
+// place of its last "*", or appended to it if there is none; use Name on the
+// returned FileWriter to get its path. The file is created with mode 0600.
+//
+// The random part is read from crypto/rand, so names cannot be predicted.
+// The caller is responsible for removing the file; it is only removed
+// automatically if the filesystem was created with WithTTL.
+func (rootFS *FS) TempFile(dir, pattern string) (*FileWriter, error) {
+	if dir == "" {
+		dir = "."
+	}
+	prefix, suffix, err := splitTempPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	for range tempAttempts {
+		file := &File{
+			Perm:    0600,
+			Content: []byte{},
+			ModTime: time.Now(),
+		}
+		err := rootFS.placeNew(dir, prefix+tempRandom()+suffix, file)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return rootFS.newFileWriter(file)
+	}
+	return nil, fmt.Errorf("no unused name for pattern: %s: %w", pattern, fs.ErrExist)
+}
+
+// TempDir creates a new directory in the directory dir, "." if empty, and
+// returns its path. The name of the directory is chosen from pattern like
+// TempFile does, and it is created with mode 0700. The caller is
+// responsible for removing the directory.
+func (rootFS *FS) TempDir(dir, pattern string) (string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	prefix, suffix, err := splitTempPattern(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	for range tempAttempts {
+		name := prefix + tempRandom() + suffix
+		err := rootFS.placeNew(dir, name, &Dir{
+			Perm:     0700,
+			ModTime:  time.Now(),
+			Children: make(map[string]childI),
+		})
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return syspath.Join(dir, name), nil
+	}
+	return "", fmt.Errorf("no unused name for pattern: %s: %w", pattern, fs.ErrExist)
+}
+
+// Name returns the path of the file being written, relative to the
+// filesystem it was opened on, with symbolic links resolved
+func (fw *FileWriter) Name() string {
+	fw.fs.mu.Lock()
+	keyPath := fw.file.keyPath
+	fw.fs.mu.Unlock()
+
+	return fw.fs.relativePath(keyPath)
+}
+
+// relativePath returns the path of the file at keyPath relative to the filesystem
+func (rootFS *FS) relativePath(keyPath string) string {
+	if prefix := rootFS.keyPrefix; prefix != "" {
+		keyPath = strings.TrimPrefix(strings.TrimPrefix(keyPath, prefix), "/")
+	}
+	return keyPath
+}
+
+// splitTempPattern splits pattern at its last "*" into the parts before and
+// after the random string
+func splitTempPattern(pattern string) (prefix, suffix string, err error) {
+	if strings.Contains(pattern, "/") {
+		return "", "", fmt.Errorf("pattern contains a path separator: %s: %w", pattern, fs.ErrInvalid)
+	}
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		return pattern[:i], pattern[i+1:], nil
+	}
+	return pattern, "", nil
+}
+
+// tempRandom returns the random part of a temporary name
+func tempRandom() string {
+	b := make([]byte, 8)
+	// crypto/rand does not return errors
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// placeNew adds child as name to the directory dir, failing with an error
+// wrapping fs.ErrExist if the name is already taken
+func (rootFS *FS) placeNew(dir, name string, child childI) error {
+	if !fs.ValidPath(dir) {
+		return fmt.Errorf("invalid path: %s: %w", dir, fs.ErrInvalid)
+	}
+	path := syspath.Join(dir, name)
+	if err := rootFS.checkWritable(path); err != nil {
+		return err
+	}
+
+	if err := rootFS.copyUpDir(dir); err != nil {
+		return err
+	}
+	d, err := rootFS.getDir(dir)
+	if err != nil {
+		return err
+	}
+	keyPath := syspath.Join(rootFS.keyPath(dir), name)
+
+	// In an overlay, the name may be taken in the lower layer
+	if rootFS.lower != nil {
+		if _, err := rootFS.lowerStat(path); err == nil {
+			return fmt.Errorf("file exists: %s: %w", path, fs.ErrExist)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, exists := d.Children[name]; exists {
+		return fmt.Errorf("file exists: %s: %w", path, fs.ErrExist)
+	}
+
+	switch c := child.(type) {
+	case *File:
+		rootFS.mu.Lock()
+		err := rootFS.addFiles(path, 1)
+		rootFS.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		c.Name = name
+		c.keyPath = keyPath
+		if rootFS.ttl > 0 {
+			c.expireAt = time.Now().Add(rootFS.ttl)
+		}
+	case *Dir:
+		c.Name = name
+		c.sorted = d.sorted
+	}
+	d.setChild(name, child)
+	d.ModTime = time.Now()
+	rootFS.notify(OpCreate, keyPath)
+	return nil
+}
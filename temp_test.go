@@ -0,0 +1,112 @@
+package memfs
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestTempFile(t *testing.T) {
+	rootFS := New()
+	if err := rootFS.MkdirAll("tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	names := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		w, err := rootFS.TempFile("tmp", "upload-*.part")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("chunk")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		name := w.Name()
+		if !strings.HasPrefix(name, "tmp/upload-") || !strings.HasSuffix(name, ".part") {
+			t.Fatalf("Expected a name matching the pattern, got %q", name)
+		}
+		if names[name] {
+			t.Fatalf("Got name %q twice", name)
+		}
+		names[name] = true
+
+		content, err := rootFS.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "chunk" {
+			t.Fatalf("Expected 'chunk', got %q", content)
+		}
+		info, err := rootFS.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Fatalf("Expected mode 0600, got %v", info.Mode().Perm())
+		}
+	}
+
+	// Without a "*" the random part is appended, and dir defaults to "."
+	w, err := rootFS.TempFile("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if name := w.Name(); !strings.HasPrefix(name, "log") || len(name) <= len("log") || strings.Contains(name, "/") {
+		t.Fatalf("Expected a name in the root starting with 'log', got %q", name)
+	}
+
+	if _, err := rootFS.TempFile("tmp", "a/b*"); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid for a pattern with a separator, got: %v", err)
+	}
+	if _, err := rootFS.TempFile("missing", "x*"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for a missing directory, got: %v", err)
+	}
+	if _, err := New(WithReadOnly(true)).TempFile("", "x*"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Expected fs.ErrPermission for a read-only filesystem, got: %v", err)
+	}
+}
+
+func TestTempDir(t *testing.T) {
+	rootFS := New()
+
+	dir, err := rootFS.TempDir("", "build-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dir, "build-") {
+		t.Fatalf("Expected a name starting with 'build-', got %q", dir)
+	}
+	info, err := rootFS.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0700 {
+		t.Fatalf("Expected a directory with mode 0700, got %v", info.Mode())
+	}
+
+	// Temporary files can be created inside it
+	w, err := rootFS.TempFile(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(w.Name(), dir+"/") {
+		t.Fatalf("Expected the file to be in %s, got %q", dir, w.Name())
+	}
+
+	other, err := rootFS.TempDir("", "build-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == dir {
+		t.Fatalf("Got directory %q twice", dir)
+	}
+}
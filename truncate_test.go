@@ -0,0 +1,65 @@
+package memfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestTruncate(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("truncate-key")), WithMaxStorage(1000))
+
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shrink
+	if err := rootFS.Truncate("dir/file.txt", 4); err != nil {
+		t.Fatal(err)
+	}
+	content, err := fs.ReadFile(rootFS, "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "0123" {
+		t.Fatalf("Expected '0123', got %q", content)
+	}
+
+	// Grow with zero bytes
+	if err := rootFS.Truncate("dir/file.txt", 6); err != nil {
+		t.Fatal(err)
+	}
+	content, err = fs.ReadFile(rootFS, "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, []byte("0123\x00\x00")) {
+		t.Fatalf("Expected zero-extended content, got %q", content)
+	}
+
+	// Storage usage follows the stored (encrypted) size
+	child, err := rootFS.get("dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used := rootFS.UsedStorage(); used != int64(len(child.(*File).Content)) {
+		t.Fatalf("Expected used storage %d, got %d", len(child.(*File).Content), used)
+	}
+
+	if err := rootFS.Truncate("dir/file.txt", 2000); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected storage limit error, got: %v", err)
+	}
+	if err := rootFS.Truncate("dir", 0); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected error for directory, got: %v", err)
+	}
+	if err := rootFS.Truncate("missing.txt", 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for missing file, got: %v", err)
+	}
+	if err := rootFS.Truncate("dir/file.txt", -1); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid for negative size, got: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package memfs
+
+import (
+	syspath "path"
+	"strings"
+	"time"
+)
+
+// minSweepInterval bounds how often the expiry sweeper scans the filesystem
+const minSweepInterval = 10 * time.Millisecond
+
+// Close stops the background goroutine that evicts expired files (see WithTTL).
+// The filesystem stays usable after Close; expired files are still removed
+// lazily when they are accessed. Close always returns nil.
+func (rootFS *FS) Close() error {
+	rootFS.closeOnce.Do(func() {
+		if rootFS.stopSweep != nil {
+			close(rootFS.stopSweep)
+		}
+	})
+	return nil
+}
+
+// expired reports whether the TTL of f has passed
+func (rootFS *FS) expired(f *File) bool {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+	return !f.expireAt.IsZero() && time.Now().After(f.expireAt)
+}
+
+// evict removes the expired file f from path, unless it has been replaced in
+// the meantime, and reports the eviction to the OnEvict callback
+func (rootFS *FS) evict(path string, f *File) {
+	dirPart, filePart := syspath.Split(path)
+	dirPart = strings.TrimSuffix(dirPart, "/")
+
+	dir, err := rootFS.getDir(dirPart)
+	if err != nil {
+		return
+	}
+
+	dir.mu.Lock()
+	if dir.Children[filePart] != f {
+		dir.mu.Unlock()
+		return
+	}
+	dir.deleteChild(filePart)
+	dir.ModTime = time.Now()
+	rootFS.notify(OpRemove, f.keyPath)
+	dir.mu.Unlock()
+
+	rootFS.mu.Lock()
+	rootFS.releaseFile(f)
+	rootFS.mu.Unlock()
+
+	if rootFS.onEvict != nil {
+		rootFS.onEvict(path)
+	}
+}
+
+// sweepExpired periodically evicts expired files until Close is called
+func (rootFS *FS) sweepExpired() {
+	interval := max(rootFS.ttl/2, minSweepInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rootFS.stopSweep:
+			return
+		case <-ticker.C:
+			rootFS.evictExpired()
+		}
+	}
+}
+
+// evictExpired removes every expired file from the filesystem
+func (rootFS *FS) evictExpired() {
+	type expiredFile struct {
+		path string
+		file *File
+	}
+	var expiredFiles []expiredFile
+
+	rootFS.walk(func(path string, child childI) error {
+		if f, ok := child.(*File); ok && rootFS.expired(f) {
+			expiredFiles = append(expiredFiles, expiredFile{path: path, file: f})
+		}
+		return nil
+	})
+
+	for _, e := range expiredFiles {
+		rootFS.evict(e.path, e.file)
+	}
+}
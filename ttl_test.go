@@ -0,0 +1,97 @@
+package memfs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestTTLLazyExpiry(t *testing.T) {
+	var evicted []string
+	rootFS := New(WithTTL(time.Hour), WithMaxStorage(100), WithOnEvict(func(path string) {
+		evicted = append(evicted, path)
+	}))
+	defer rootFS.Close()
+
+	if err := rootFS.MkdirAll("cache", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("cache/entry", []byte("cached"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := fs.ReadFile(rootFS, "cache/entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "cached" {
+		t.Fatalf("Expected 'cached', got %q", content)
+	}
+
+	// Move the expiry into the past
+	child, err := rootFS.get("cache/entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootFS.mu.Lock()
+	child.(*File).expireAt = time.Now().Add(-time.Second)
+	rootFS.mu.Unlock()
+
+	_, err = rootFS.Open("cache/entry")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for expired file, got: %v", err)
+	}
+
+	if len(evicted) != 1 || evicted[0] != "cache/entry" {
+		t.Fatalf("Expected cache/entry to be evicted, got %v", evicted)
+	}
+	if got := rootFS.UsedStorage(); got != 0 {
+		t.Fatalf("Expected used storage 0 after eviction, got %d", got)
+	}
+}
+
+func TestTTLBackgroundSweep(t *testing.T) {
+	evicted := make(chan string, 1)
+	rootFS := New(WithTTL(20*time.Millisecond), WithOnEvict(func(path string) {
+		evicted <- path
+	}))
+	defer rootFS.Close()
+
+	if err := rootFS.WriteFile("entry", []byte("cached"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case path := <-evicted:
+		if path != "entry" {
+			t.Fatalf("Expected entry to be evicted, got %s", path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected expired file to be evicted in the background")
+	}
+
+	rootFS.dir.mu.Lock()
+	n := len(rootFS.dir.Children)
+	rootFS.dir.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("Expected no files after sweep, got %d", n)
+	}
+}
+
+func TestTTLDisabled(t *testing.T) {
+	rootFS := New()
+	defer rootFS.Close()
+
+	if err := rootFS.WriteFile("entry", []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	child, err := rootFS.get("entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !child.(*File).expireAt.IsZero() {
+		t.Fatal("Expected no expiry without WithTTL")
+	}
+}
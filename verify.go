@@ -0,0 +1,85 @@
+package memfs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrCorruptArchive is returned by VerifyArchive when a saved filesystem
+// is truncated, malformed or has been tampered with.
+var ErrCorruptArchive = errors.New("corrupt archive")
+
+// VerifyArchive checks that r holds an intact filesystem written by SaveTo or
+// CompressAndSaveTo, without building a new FS from it. Compressed archives
+// are detected automatically and their gzip checksum is verified.
+//
+// If key is set, every file and previous version is decrypted with it, so
+// that content which was modified after it was encrypted, or that was
+// encrypted with a different key, is detected. Without a key only the
+// structure of the archive can be checked. The HMAC of archives saved with
+// WithHMAC is not checked, as the HMAC key is not known.
+//
+// The snapshot is a single GOB value, so it is decoded in memory as a whole;
+// decrypted content is discarded file by file.
+func VerifyArchive(r io.Reader, key []byte) error {
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrCorruptArchive, err)
+		}
+		defer gr.Close()
+		src = gr
+	}
+
+	snap, err := decodeSnapshot(src)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCorruptArchive, err)
+	}
+
+	// The content is decrypted with the cipher the filesystem was saved with
+	enc, err := newEncryptor(key, snap.Cipher, snap.PerFileKeys)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCorruptArchive, err)
+	}
+	enc.bindPath = snap.BoundPaths
+	enc.chunked = snap.Chunked
+	enc.setCompression(snap.Compressed, snap.CompressionLevel)
+
+	return walkDir(snap.Root, "", func(path string, child childI) error {
+		file, ok := child.(*File)
+		if !ok {
+			return nil
+		}
+
+		// Per-file keys are derived from the decrypted path
+		keyPath := path
+		if snap.EncryptedPaths && enc.encrypts() {
+			var err error
+			if keyPath, err = enc.openPath(path); err != nil {
+				return fmt.Errorf("%w: %s: %w", ErrCorruptArchive, path, err)
+			}
+		}
+
+		for i, f := range append([]*File{file}, file.History...) {
+			content := f.Content
+			if snap.HMAC && enc.enable && len(content) > 0 {
+				// Without the HMAC key only the encryption can be checked
+				content = content[:max(len(content)-sha256.Size, 0)]
+			}
+			if _, err := enc.decrypt(keyPath, content); err != nil {
+				if i > 0 {
+					return fmt.Errorf("%w: %s (version of %s): %w", ErrCorruptArchive, path, f.ModTime.Format(time.RFC3339Nano), err)
+				}
+				return fmt.Errorf("%w: %s: %w", ErrCorruptArchive, path, err)
+			}
+		}
+		return nil
+	})
+}
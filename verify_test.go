@@ -0,0 +1,77 @@
+package memfs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestVerifyArchive tests verifying clean, truncated and tampered archives
+func TestVerifyArchive(t *testing.T) {
+	key := []byte("verify-key")
+	rootFS := New(WithEncryption(key), WithVersioning(2))
+
+	if err := rootFS.MkdirAll("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", []byte("first version"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", []byte("second version"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var plain, compressed bytes.Buffer
+	if err := rootFS.SaveTo(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.CompressAndSaveTo(&compressed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Clean archives
+	for name, data := range map[string][]byte{"plain": plain.Bytes(), "compressed": compressed.Bytes()} {
+		if err := VerifyArchive(bytes.NewReader(data), key); err != nil {
+			t.Fatalf("%s: expected clean archive to verify, got: %v", name, err)
+		}
+		if err := VerifyArchive(bytes.NewReader(data), nil); err != nil {
+			t.Fatalf("%s: expected structure check without key to pass, got: %v", name, err)
+		}
+
+		// Truncated archives
+		truncated := data[:len(data)/2]
+		if err := VerifyArchive(bytes.NewReader(truncated), key); !errors.Is(err, ErrCorruptArchive) {
+			t.Fatalf("%s: expected ErrCorruptArchive for truncated archive, got: %v", name, err)
+		}
+	}
+
+	// A wrong key is detected
+	if err := VerifyArchive(bytes.NewReader(plain.Bytes()), []byte("wrong-key")); !errors.Is(err, ErrCorruptArchive) {
+		t.Fatalf("Expected ErrCorruptArchive with wrong key, got: %v", err)
+	}
+
+	// Tampered ciphertext, both in the current content and in a previous version
+	file, err := rootFS.getFile("dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range [][]byte{file.Content, file.History[0].Content} {
+		data := bytes.Clone(plain.Bytes())
+		i := bytes.Index(data, content)
+		if i < 0 {
+			t.Fatal("Encrypted content not found in archive")
+		}
+		data[i+len(content)-1] ^= 0xff
+
+		if err := VerifyArchive(bytes.NewReader(data), key); !errors.Is(err, ErrCorruptArchive) {
+			t.Fatalf("Expected ErrCorruptArchive for tampered archive, got: %v", err)
+		}
+	}
+
+	// Compressed archives are protected by the gzip checksum
+	data := bytes.Clone(compressed.Bytes())
+	data[len(data)-8] ^= 0xff
+	if err := VerifyArchive(bytes.NewReader(data), nil); !errors.Is(err, ErrCorruptArchive) {
+		t.Fatalf("Expected ErrCorruptArchive for bad checksum, got: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package memfs
+
+import (
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// pushVersion returns the History for a file replacing f: the History of f
+// followed by f itself, limited to the n most recent versions. A reference
+// of f to shared content moves to its version. The caller must hold FS.mu.
+func (f *File) pushVersion(n int) []*File {
+	history := append(f.History[:len(f.History):len(f.History)], &File{
+		Name:          f.Name,
+		Perm:          f.Perm,
+		Content:       f.Content,
+		PlaintextSize: f.PlaintextSize,
+		keyPath:       f.keyPath,
+		blob:          f.blob,
+		ModTime:       f.ModTime,
+		AccessTime:    f.AccessTime,
+		Uid:           f.Uid,
+		Gid:           f.Gid,
+	})
+	f.blob = nil
+	if len(history) > n {
+		history = history[len(history)-n:]
+	}
+	return history
+}
+
+// releaseReplaced updates the storage usage after old has been replaced by
+// newFile, releasing everything that newFile did not keep as a previous version
+func (rootFS *FS) releaseReplaced(old, newFile *File) {
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+
+	var kept int64
+	for _, v := range newFile.History {
+		kept += int64(len(v.Content))
+	}
+	rootFS.usedStorage -= old.storedSize() - kept
+	rootFS.releaseVersions(old, newFile)
+}
+
+// Versions returns the modification times of the previous versions of the
+// file at path, oldest first. Previous versions are only kept if the
+// filesystem was created with WithVersioning.
+func (rootFS *FS) Versions(path string) ([]time.Time, error) {
+	file, err := rootFS.getFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rootFS.mu.Lock()
+	defer rootFS.mu.Unlock()
+
+	times := make([]time.Time, 0, len(file.History))
+	for _, v := range file.History {
+		times = append(times, v.ModTime)
+	}
+	return times, nil
+}
+
+// OpenVersion opens the previous version of the file at path whose
+// modification time is t, as returned by Versions.
+func (rootFS *FS) OpenVersion(path string, t time.Time) (fs.File, error) {
+	file, err := rootFS.getFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rootFS.mu.Lock()
+	var version *File
+	for _, v := range file.History {
+		if v.ModTime.Equal(t) {
+			version = v
+		}
+	}
+	rootFS.mu.Unlock()
+
+	if version == nil {
+		return nil, fmt.Errorf("no version at %s: %s: %w", t, path, fs.ErrNotExist)
+	}
+
+	content, err := rootFS.readContent(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return version.handle(content), nil
+}
+
+// getFile returns the regular file at path
+func (rootFS *FS) getFile(path string) (*File, error) {
+	if !fs.ValidPath(path) {
+		return nil, fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
+	}
+
+	child, err := rootFS.get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, ok := child.(*File)
+	if !ok {
+		return nil, fmt.Errorf("path is a directory: %s: %w", path, fs.ErrInvalid)
+	}
+	return file, nil
+}
@@ -0,0 +1,150 @@
+package memfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestVersioning(t *testing.T) {
+	rootFS := New(WithVersioning(2), WithEncryption([]byte("versions-key")), WithMaxStorage(10000))
+
+	contents := []string{"v1", "v2", "v3", "v4"}
+	for i, content := range contents {
+		if i%2 == 0 {
+			if err := rootFS.WriteFile("file.txt", []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			fw, err := rootFS.Create("file.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fw.Write([]byte(content)); err != nil {
+				t.Fatal(err)
+			}
+			if err := fw.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	versions, err := rootFS.Versions("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d", len(versions))
+	}
+
+	for i, expected := range []string{"v2", "v3"} {
+		f, err := rootFS.OpenVersion("file.txt", versions[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != expected {
+			t.Errorf("Expected version %d to be %q, got %q", i, expected, got)
+		}
+	}
+
+	current, err := fs.ReadFile(rootFS, "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(current) != "v4" {
+		t.Errorf("Expected current content 'v4', got %q", current)
+	}
+
+	if _, err := rootFS.OpenVersion("file.txt", time.Time{}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist for unknown version, got: %v", err)
+	}
+
+	// Previous versions count towards the storage usage
+	child, err := rootFS.get("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used := rootFS.UsedStorage(); used != child.(*File).storedSize() {
+		t.Errorf("Expected used storage %d, got %d", child.(*File).storedSize(), used)
+	}
+
+	// Versions survive save/load
+	var buf bytes.Buffer
+	if err := rootFS.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loadedFS, err := LoadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadedFS.SetEncryptionKey([]byte("versions-key")); err != nil {
+		t.Fatal(err)
+	}
+	loadedVersions, err := loadedFS.Versions("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loadedVersions) != 2 || !loadedVersions[0].Equal(versions[0]) {
+		t.Fatalf("Expected versions %v after load, got %v", versions, loadedVersions)
+	}
+	f, err := loadedFS.OpenVersion("file.txt", loadedVersions[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "v2" {
+		t.Errorf("Expected 'v2' after load, got %q", got)
+	}
+
+	// Remove drops the history along with the file
+	if err := rootFS.Remove("file.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if used := rootFS.UsedStorage(); used != 0 {
+		t.Errorf("Expected used storage 0 after remove, got %d", used)
+	}
+	if err := rootFS.WriteFile("file.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	versions, err = rootFS.Versions("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("Expected no versions after remove, got %d", len(versions))
+	}
+}
+
+func TestVersioningDisabled(t *testing.T) {
+	rootFS := New(WithMaxStorage(100))
+
+	for _, content := range []string{"first", "second"} {
+		if err := rootFS.WriteFile("file.txt", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := rootFS.Versions("file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("Expected no versions without WithVersioning, got %d", len(versions))
+	}
+
+	// Overwriting releases the storage of the replaced content
+	if used := rootFS.UsedStorage(); used != int64(len("second")) {
+		t.Errorf("Expected used storage %d, got %d", len("second"), used)
+	}
+}
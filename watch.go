@@ -0,0 +1,256 @@
+package memfs
+
+import (
+	"fmt"
+	"io/fs"
+	syspath "path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Op is the kind of change reported by an Event
+type Op int
+
+// Changes reported to watchers, see Watch
+const (
+	OpCreate   Op = iota + 1 // a file, directory or symbolic link was created
+	OpWrite                  // the content of a file was written or truncated
+	OpRemove                 // a file, directory or symbolic link was removed
+	OpRename                 // a file, directory or symbolic link was renamed, see Rename
+	OpOverflow               // events were dropped, as the receiver fell behind
+)
+
+// String returns the name of the operation
+func (op Op) String() string {
+	switch op {
+	case OpCreate:
+		return "CREATE"
+	case OpWrite:
+		return "WRITE"
+	case OpRemove:
+		return "REMOVE"
+	case OpRename:
+		return "RENAME"
+	case OpOverflow:
+		return "OVERFLOW"
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Event is a change to the filesystem reported by Watch
+type Event struct {
+	Op   Op
+	Path string
+	Time time.Time // when the change was made, the last one for coalesced writes
+}
+
+// watchQueueSize is the number of events queued for a watcher whose
+// receiver falls behind, see Watch
+const watchQueueSize = 1024
+
+// Watch returns a channel that receives an Event for every change of the
+// file or directory at path, and of the direct children of a directory.
+// If path ends with "/*", everything below the directory is watched; "*"
+// watches the whole filesystem. The path does not have to exist yet.
+//
+// Events are queued, so a slow receiver never blocks changes to the
+// filesystem. The queue holds up to 1024 events; once it is full, a write to
+// a file that is still queued as written is merged into that event, and other
+// events are dropped. A single event with OpOverflow and path "." is queued
+// in their place, after which the receiver should rescan what it watches.
+// Call the returned function to stop watching; the channel is closed after
+// that. Event paths are slash-separated paths of
+// the filesystem, with symbolic links resolved.
+func (rootFS *FS) Watch(path string) (<-chan Event, func(), error) {
+	base, recursive := path, false
+	if path == "*" {
+		base, recursive = ".", true
+	} else if strings.HasSuffix(path, "/*") {
+		base, recursive = strings.TrimSuffix(path, "/*"), true
+	}
+	if !fs.ValidPath(base) {
+		return nil, nil, fmt.Errorf("invalid path: %s: %w", path, fs.ErrInvalid)
+	}
+
+	w := &watcher{
+		path:      rootFS.keyPath(base),
+		recursive: recursive,
+		prefix:    rootFS.keyPrefix,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		events:    make(chan Event),
+	}
+
+	reg := rootFS.watchRegistry()
+	reg.mu.Lock()
+	reg.watchers[w] = struct{}{}
+	reg.mu.Unlock()
+
+	go w.deliver()
+
+	stop := func() {
+		w.stopOnce.Do(func() {
+			reg.mu.Lock()
+			delete(reg.watchers, w)
+			reg.mu.Unlock()
+			close(w.done)
+		})
+	}
+	return w.events, stop, nil
+}
+
+// watchRegistry holds the watchers of a filesystem and the filesystems
+// returned by its Sub method
+type watchRegistry struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+// watchRegistry returns the registry of the filesystem, creating it on first use
+func (rootFS *FS) watchRegistry() *watchRegistry {
+	if reg := rootFS.watchers.Load(); reg != nil {
+		return reg
+	}
+	rootFS.watchers.CompareAndSwap(nil, &watchRegistry{watchers: make(map[*watcher]struct{})})
+	return rootFS.watchers.Load()
+}
+
+// notify reports op on path to all watchers of it. Like the key path of a
+// file, path is resolved and relative to the root of the tree, also for
+// filesystems returned by Sub. notify never blocks, so it may be called with
+// locks held.
+func (rootFS *FS) notify(op Op, path string) {
+	reg := rootFS.watchers.Load()
+	if reg == nil {
+		return
+	}
+
+	now := time.Now()
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for w := range reg.watchers {
+		if w.matches(op, path) {
+			w.push(Event{Op: op, Path: w.relative(path), Time: now})
+		}
+	}
+}
+
+// watcher queues the events for one call of Watch
+type watcher struct {
+	path      string // watched path relative to the root of the tree
+	recursive bool
+	prefix    string // root of the filesystem Watch was called on
+
+	mu     sync.Mutex
+	queue  []Event
+	wake   chan struct{}
+	done   chan struct{}
+	events chan Event
+
+	stopOnce sync.Once
+}
+
+// matches reports whether a change of op at path concerns the watcher
+func (w *watcher) matches(op Op, path string) bool {
+	parent := syspath.Dir(path)
+	if parent == "." {
+		parent = ""
+	}
+
+	switch {
+	case path == w.path, parent == w.path:
+		return true
+	case w.recursive && pathWithin(path, w.path):
+		return true
+	case (op == OpRemove || op == OpRename) && pathWithin(w.path, path):
+		// The watched path was removed or renamed together with a parent
+		return true
+	}
+	return false
+}
+
+// relative returns path relative to the filesystem Watch was called on
+func (w *watcher) relative(path string) string {
+	if w.prefix != "" {
+		if !pathWithin(path, w.prefix) {
+			// The root of the filesystem itself, or one of its parents
+			return "."
+		}
+		path = path[len(w.prefix)+1:]
+	}
+	if path == "" {
+		return "."
+	}
+	return path
+}
+
+// push queues an event for delivery. The last place of a full queue is
+// kept for the OpOverflow event.
+func (w *watcher) push(e Event) {
+	w.mu.Lock()
+	switch {
+	case len(w.queue) < watchQueueSize-1:
+		w.queue = append(w.queue, e)
+	case e.Op == OpWrite && w.coalesce(e):
+	case w.queue[len(w.queue)-1].Op != OpOverflow:
+		w.queue = append(w.queue, Event{Op: OpOverflow, Path: ".", Time: e.Time})
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// coalesce merges the write e into the queued event for the same path, if
+// that is a write too. The caller must hold w.mu.
+func (w *watcher) coalesce(e Event) bool {
+	for i := len(w.queue) - 1; i >= 0; i-- {
+		if w.queue[i].Op == OpOverflow || w.queue[i].Path != e.Path {
+			continue
+		}
+		if w.queue[i].Op != OpWrite {
+			return false
+		}
+		w.queue[i].Time = e.Time
+		return true
+	}
+	return false
+}
+
+// deliver sends queued events to the channel until the watcher is stopped
+func (w *watcher) deliver() {
+	defer close(w.events)
+
+	for {
+		w.mu.Lock()
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			select {
+			case <-w.wake:
+				continue
+			case <-w.done:
+				return
+			}
+		}
+		e := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		select {
+		case w.events <- e:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// pathWithin reports whether path is below dir, where "" is the root
+func pathWithin(path, dir string) bool {
+	if dir == "" {
+		return path != ""
+	}
+	return strings.HasPrefix(path, dir+"/")
+}
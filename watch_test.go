@@ -0,0 +1,230 @@
+package memfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event of a watcher, failing the test after a second
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for an event")
+		return Event{}
+	}
+}
+
+// expectEvents checks that the next events of a watcher are want
+func expectEvents(t *testing.T, events <-chan Event, want ...Event) {
+	t.Helper()
+	for _, w := range want {
+		e := nextEvent(t, events)
+		if e.Op != w.Op || e.Path != w.Path {
+			t.Fatalf("Expected %v %s, got %v %s", w.Op, w.Path, e.Op, e.Path)
+		}
+		if e.Time.IsZero() || time.Since(e.Time) > time.Minute {
+			t.Fatalf("Expected the time of the change, got %v", e.Time)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	rootFS := New()
+	if err := rootFS.MkdirAll("config", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dirEvents, stopDir, err := rootFS.Watch("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopDir()
+	fileEvents, stopFile, err := rootFS.Watch("config/app.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rootFS.WriteFile("config/app.yaml", []byte("a: 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("config/app.yaml", []byte("a: 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := rootFS.Create("config/app.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("a: 3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.Remove("config/app.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, events := range []<-chan Event{dirEvents, fileEvents} {
+		expectEvents(t, events,
+			Event{Op: OpCreate, Path: "config/app.yaml"},
+			Event{Op: OpWrite, Path: "config/app.yaml"},
+			Event{Op: OpWrite, Path: "config/app.yaml"},
+			Event{Op: OpWrite, Path: "config/app.yaml"},
+			Event{Op: OpRemove, Path: "config/app.yaml"},
+		)
+	}
+
+	// The channel is closed when watching stops
+	stopFile()
+	stopFile()
+	if _, ok := <-fileEvents; ok {
+		t.Fatal("Expected the channel to be closed after stopping")
+	}
+
+	// Changes below a child directory are only reported recursively
+	allEvents, stopAll, err := rootFS.Watch("config/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopAll()
+	if err := rootFS.MkdirAll("config/env/prod", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("config/env/prod/db.yaml", []byte("host: db"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.RemoveAll("config/env"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, allEvents,
+		Event{Op: OpCreate, Path: "config/env"},
+		Event{Op: OpCreate, Path: "config/env/prod"},
+		Event{Op: OpCreate, Path: "config/env/prod/db.yaml"},
+		Event{Op: OpWrite, Path: "config/env/prod/db.yaml"},
+		Event{Op: OpRemove, Path: "config/env"},
+	)
+	expectEvents(t, dirEvents,
+		Event{Op: OpCreate, Path: "config/env"},
+		Event{Op: OpRemove, Path: "config/env"},
+	)
+
+	if _, _, err := rootFS.Watch("../etc"); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("Expected fs.ErrInvalid, got: %v", err)
+	}
+}
+
+func TestWatchSub(t *testing.T) {
+	rootFS := New()
+	if err := rootFS.MkdirAll("data/cache", 0755); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := rootFS.Sub("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subFS := sub.(*FS)
+
+	subEvents, stopSub, err := subFS.Watch("cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopSub()
+	rootEvents, stopRoot, err := rootFS.Watch("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopRoot()
+
+	// Changes through either filesystem reach both, with their own paths
+	if err := rootFS.WriteFile("data/cache/a", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := subFS.Remove("cache/a"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, subEvents,
+		Event{Op: OpCreate, Path: "cache/a"},
+		Event{Op: OpWrite, Path: "cache/a"},
+		Event{Op: OpRemove, Path: "cache/a"},
+	)
+	expectEvents(t, rootEvents,
+		Event{Op: OpCreate, Path: "data/cache/a"},
+		Event{Op: OpWrite, Path: "data/cache/a"},
+		Event{Op: OpRemove, Path: "data/cache/a"},
+	)
+}
+
+// TestWatchOverflow tests that the queue of a watcher whose receiver falls
+// behind is bounded
+func TestWatchOverflow(t *testing.T) {
+	rootFS := New()
+	events, stop, err := rootFS.Watch("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	if err := rootFS.WriteFile("hot", []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < watchQueueSize; i++ {
+		if err := rootFS.WriteFile(fmt.Sprintf("f%d", i), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The queued write of hot is updated instead of being dropped
+	lastWrite := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := rootFS.WriteFile("hot", []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var received []Event
+	for done := false; !done; {
+		select {
+		case e := <-events:
+			received = append(received, e)
+		case <-time.After(100 * time.Millisecond):
+			done = true
+		}
+	}
+
+	// One event may be on its way to the channel besides the queue
+	if len(received) > watchQueueSize+1 {
+		t.Fatalf("Expected at most %d events, got %d", watchQueueSize+1, len(received))
+	}
+	var overflows, hotWrites int
+	for _, e := range received {
+		switch {
+		case e.Op == OpOverflow:
+			overflows++
+		case e.Op == OpWrite && e.Path == "hot":
+			hotWrites++
+			if e.Time.Before(lastWrite) {
+				t.Errorf("Expected the time of the last write of hot, got %v", e.Time)
+			}
+		}
+	}
+	if overflows != 1 || received[len(received)-1].Op != OpOverflow {
+		t.Fatalf("Expected a single overflow event at the end, got %d", overflows)
+	}
+	if hotWrites != 1 {
+		t.Fatalf("Expected the writes of hot to be coalesced, got %d", hotWrites)
+	}
+	if received[len(received)-1].Path != "." {
+		t.Fatalf("Expected the overflow event for %q, got %q", ".", received[len(received)-1].Path)
+	}
+
+	// Events are queued again once the receiver caught up
+	if err := rootFS.Remove("hot"); err != nil {
+		t.Fatal(err)
+	}
+	expectEvents(t, events, Event{Op: OpRemove, Path: "hot"})
+}
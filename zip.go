@@ -0,0 +1,222 @@
+package memfs
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportToZipFile writes the entire filesystem to a ZIP archive on disk
+func (rootFS *FS) ExportToZipFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return rootFS.ExportToZip(f)
+}
+
+// ExportToZip writes the entire filesystem to w as a ZIP archive.
+// Directory structure, symbolic links, permissions and modification times are
+// preserved. File contents are stored exactly as they are held in memory, so
+// files of an encrypted filesystem stay encrypted inside the archive. The
+// settings they are stored with, such as the cipher, compression and HMAC,
+// are recorded in the comments of the archive for ImportFromZip.
+func (rootFS *FS) ExportToZip(w io.Writer) error {
+	return rootFS.writeZip(w, false)
+}
+
+// WriteZip writes the entire filesystem to w as a standard ZIP archive, like
+// ExportToZip, but with the decrypted content of the files. The archive can
+// be read by any ZIP tool without knowing the encryption key.
+func (rootFS *FS) WriteZip(w io.Writer) error {
+	return rootFS.writeZip(w, true)
+}
+
+// writeZip writes the ZIP archive of the filesystem to w, with the files
+// decrypted if decrypt is set
+func (rootFS *FS) writeZip(w io.Writer, decrypt bool) error {
+	zw := zip.NewWriter(w)
+
+	err := rootFS.walk(func(path string, child childI) error {
+		var (
+			header  *zip.FileHeader
+			content []byte
+		)
+		switch c := child.(type) {
+		case *Dir:
+			header = &zip.FileHeader{
+				Name:     path + "/",
+				Modified: c.ModTime,
+			}
+			header.SetMode(c.Perm | fs.ModeDir)
+		case *File:
+			header = &zip.FileHeader{
+				Name:     path,
+				Method:   zip.Deflate,
+				Modified: c.ModTime,
+			}
+			header.SetMode(c.Perm)
+
+			if decrypt {
+				var err error
+				if content, err = rootFS.readContent(c); err != nil {
+					return fmt.Errorf("reading %s: %w", path, err)
+				}
+				break
+			}
+			rootFS.mu.Lock()
+			content = c.Content
+			header.Comment = archiveComment(archiveSizeKey, strconv.FormatInt(rootFS.plainSize(c), 10))
+			rootFS.mu.Unlock()
+		case *Symlink:
+			// Like zip(1), store the link target as the entry content
+			header = &zip.FileHeader{
+				Name:     path,
+				Modified: c.ModTime,
+			}
+			header.SetMode(fs.ModeSymlink | 0777)
+			content = []byte(c.Target)
+		default:
+			return fmt.Errorf("unexpected file type in fs: %s: %w", path, fs.ErrInvalid)
+		}
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if !decrypt {
+		settings, err := rootFS.archiveSettings()
+		if err != nil {
+			return err
+		}
+		if err := zw.SetComment(archiveComment(archiveSettingsKey, settings)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ImportFromZipFile creates a new FS from a ZIP archive on disk
+func ImportFromZipFile(filename string) (*FS, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return importZip(&zr.Reader, true)
+}
+
+// ImportFromZip creates a new FS from the ZIP archive in r, which is size bytes long.
+// File contents are stored as found in the archive, with the storage settings
+// recorded by ExportToZip. Like after LoadFrom, the keys are not part of the
+// archive: if it was exported from an encrypted filesystem, call
+// SetEncryptionKey with the same key before reading, and SetHMACKey if the
+// filesystem used WithHMAC.
+func ImportFromZip(r io.ReaderAt, size int64) (*FS, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return importZip(zr, true)
+}
+
+// FromZip creates a new FS from the ZIP archive in r, which is size bytes
+// long, such as one written by WriteZip. Unlike ImportFromZip, the archive
+// is expected to hold plain content: files are decompressed and stored like
+// with WriteFile, so they are encrypted and limited as configured by opts.
+// Modification times are taken from the archive; parent directories without
+// an entry of their own are created with mode 0755.
+func FromZip(r io.ReaderAt, size int64, opts ...Option) (*FS, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return importZip(zr, false, opts...)
+}
+
+// importZip creates a new FS with opts from the ZIP archive zr. If raw is
+// set, file contents are stored as found in the archive, see ImportFromZip;
+// otherwise they are stored like with WriteFile, see FromZip.
+func importZip(zr *zip.Reader, raw bool, opts ...Option) (*FS, error) {
+	rootFS := New(opts...)
+
+	restore := rootFS.writable()
+	defer restore()
+
+	if settings, ok := parseArchiveComment(zr.Comment, archiveSettingsKey); ok && raw {
+		if err := rootFS.applyArchiveSettings(settings); err != nil {
+			return nil, err
+		}
+	}
+
+	// Directory times are set last, as adding entries may change them
+	dirTimes := make(map[string]time.Time)
+
+	for _, zf := range zr.File {
+		path := strings.TrimSuffix(zf.Name, "/")
+		if !fs.ValidPath(path) || path == "." {
+			return nil, fmt.Errorf("invalid path in zip archive: %s: %w", zf.Name, fs.ErrInvalid)
+		}
+
+		if zf.FileInfo().IsDir() {
+			if err := rootFS.MkdirAll(path, zf.Mode().Perm()); err != nil {
+				return nil, err
+			}
+			if err := rootFS.Chmod(path, zf.Mode().Perm()); err != nil {
+				return nil, err
+			}
+			dirTimes[path] = zf.Modified
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if zf.Mode()&fs.ModeSymlink != 0 {
+			if err := rootFS.putSymlink(path, string(content), zf.Modified); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		size, err := parseArchiveSize(parseArchiveComment(zf.Comment, archiveSizeKey))
+		if err != nil {
+			return nil, err
+		}
+		if err := rootFS.importFile(path, content, size, zf.Mode().Perm(), zf.Modified, raw); err != nil {
+			return nil, err
+		}
+	}
+
+	for path, modTime := range dirTimes {
+		if err := rootFS.Chtimes(path, time.Time{}, modTime); err != nil {
+			return nil, err
+		}
+	}
+
+	return rootFS, nil
+}
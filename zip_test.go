@@ -0,0 +1,334 @@
+package memfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestZipRoundTrip(t *testing.T) {
+	rootFS := New()
+
+	if err := rootFS.MkdirAll("foo/bar", 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.MkdirAll("empty", 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	testFiles := map[string][]byte{
+		"foo/file1.txt":     []byte("content1"),
+		"foo/bar/file2.txt": []byte("content2"),
+		"root.txt":          []byte("root content"),
+	}
+	for path, content := range testFiles {
+		if err := rootFS.WriteFile(path, content, 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modTime := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	child, err := rootFS.get("root.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	child.(*File).ModTime = modTime
+
+	var buf bytes.Buffer
+	if err := rootFS.ExportToZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedFS, err := ImportFromZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, content := range testFiles {
+		got, err := fs.ReadFile(loadedFS, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(content, got); diff != "" {
+			t.Fatalf("content mismatch for %s: %s", path, diff)
+		}
+	}
+
+	info, err := fs.Stat(loadedFS, "root.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0o640 {
+		t.Errorf("expected mode 0640, got %v", info.Mode())
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("expected mod time %v, got %v", modTime, info.ModTime())
+	}
+
+	info, err = fs.Stat(loadedFS, "empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0o700 {
+		t.Errorf("expected directory with mode 0700, got %v", info.Mode())
+	}
+
+	if got := loadedFS.UsedStorage(); got != 28 {
+		t.Errorf("expected used storage 28, got %d", got)
+	}
+}
+
+func TestZipKeepsEncryption(t *testing.T) {
+	key := []byte("zip-key")
+	rootFS := New(WithEncryption(key))
+
+	secret := []byte("secret data")
+	if err := rootFS.WriteFile("secret.txt", secret, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "fs.zip")
+	if err := rootFS.ExportToZipFile(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, secret) {
+		t.Fatal("zip archive contains plaintext")
+	}
+
+	loadedFS, err := ImportFromZipFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadedFS.SetEncryptionKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.ReadFile(loadedFS, "secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(secret, got); diff != "" {
+		t.Fatalf("content mismatch: %s", diff)
+	}
+}
+
+// rawArchiveCases are filesystems whose content is stored with settings
+// that ExportToZip and ExportToTar have to record
+var rawArchiveCases = map[string][]Option{
+	"compressed": {WithCompression()},
+	"hmac":       {WithHMAC([]byte("hmac-key"))},
+	"chunked":    {WithEncryption([]byte("archive-key")), WithChunkedEncryption()},
+	"chacha20":   {WithEncryption([]byte("archive-key")), WithEncryptionCipher(CipherChaCha20Poly1305)},
+	"per-file":   {WithPerFileKeys([]byte("archive-key"))},
+	"all": {
+		WithEncryption([]byte("archive-key")), WithEncryptionCipher(CipherChaCha20Poly1305),
+		WithChunkedEncryption(), WithCompression(), WithHMAC([]byte("hmac-key")),
+	},
+}
+
+// testRawArchive checks that a filesystem created with opts comes back from
+// a round trip through export and import with the same content, once the
+// keys are set again
+func testRawArchive(t *testing.T, name string, opts []Option, export func(*FS, io.Writer) error, imp func([]byte) (*FS, error)) {
+	t.Helper()
+	content := bytes.Repeat([]byte("compressible content "), 100)
+
+	rootFS := New(opts...)
+	if err := rootFS.MkdirAll("dir", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootFS.WriteFile("dir/file.txt", content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := export(rootFS, &buf); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	loadedFS, err := imp(buf.Bytes())
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	info, err := loadedFS.Stat("dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(content)) {
+		t.Errorf("%s: expected size %d, got %d", name, len(content), info.Size())
+	}
+
+	if rootFS.macKey != nil {
+		if _, err := loadedFS.ReadFile("dir/file.txt"); !errors.Is(err, ErrHMACKeyRequired) {
+			t.Fatalf("%s: expected ErrHMACKeyRequired, got: %v", name, err)
+		}
+		if err := loadedFS.SetHMACKey([]byte("hmac-key")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rootFS.encryptor.encrypts() {
+		if err := loadedFS.SetEncryptionKey([]byte("archive-key")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := loadedFS.ReadFile("dir/file.txt")
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("%s: content mismatch, got %d bytes", name, len(got))
+	}
+}
+
+func TestZipKeepsSettings(t *testing.T) {
+	export := func(rootFS *FS, w io.Writer) error {
+		return rootFS.ExportToZip(w)
+	}
+	imp := func(data []byte) (*FS, error) {
+		return ImportFromZip(bytes.NewReader(data), int64(len(data)))
+	}
+	for name, opts := range rawArchiveCases {
+		testRawArchive(t, name, opts, export, imp)
+	}
+}
+
+func TestWriteZip(t *testing.T) {
+	rootFS := New(WithEncryption([]byte("zip-key")))
+
+	if err := rootFS.MkdirAll("dir", 0o750); err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("secret data")
+	if err := rootFS.WriteFile("dir/secret.txt", secret, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := rootFS.Chtimes("dir/secret.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := rootFS.WriteZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if diff := cmp.Diff([]string{"dir/", "dir/secret.txt"}, names); diff != "" {
+		t.Fatalf("entries mismatch: %s", diff)
+	}
+
+	f := zr.File[1]
+	if f.Mode() != 0o640 {
+		t.Errorf("expected mode 0640, got %v", f.Mode())
+	}
+	if !f.Modified.Equal(mtime) {
+		t.Errorf("expected mtime %v, got %v", mtime, f.Modified)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(secret, got); diff != "" {
+		t.Fatalf("content mismatch: %s", diff)
+	}
+}
+
+func TestFromZip(t *testing.T) {
+	srcFS := New()
+	if err := srcFS.MkdirAll("dir/sub", 0o750); err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("secret data")
+	if err := srcFS.WriteFile("dir/sub/secret.txt", secret, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, path := range []string{"dir/sub/secret.txt", "dir"} {
+		if err := srcFS.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := srcFS.WriteZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+
+	rootFS, err := FromZip(r, r.Size(), WithEncryption([]byte("zip-key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.ReadFile(rootFS, "dir/sub/secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(secret, got); diff != "" {
+		t.Fatalf("content mismatch: %s", diff)
+	}
+
+	// The content is encrypted at rest
+	file, err := rootFS.getFile("dir/sub/secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(file.Content, secret) {
+		t.Fatal("imported file is stored in plaintext")
+	}
+
+	for path, mode := range map[string]fs.FileMode{"dir": fs.ModeDir | 0o750, "dir/sub/secret.txt": 0o640} {
+		info, err := rootFS.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != mode {
+			t.Errorf("expected mode %v for %s, got %v", mode, path, info.Mode())
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("expected mtime %v for %s, got %v", mtime, path, info.ModTime())
+		}
+	}
+
+	if _, err := FromZip(r, r.Size(), WithMaxStorage(4)); err == nil {
+		t.Fatal("expected error when the archive exceeds the storage limit")
+	}
+
+	// A read-only filesystem becomes read-only once the archive is imported
+	readOnlyFS, err := FromZip(r, r.Size(), WithReadOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readOnlyFS.ReadFile("dir/sub/secret.txt"); err != nil || !bytes.Equal(got, secret) {
+		t.Fatalf("expected the imported content, got %q, %v", got, err)
+	}
+	if err := readOnlyFS.WriteFile("new.txt", nil, 0o644); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("expected fs.ErrPermission, got: %v", err)
+	}
+}
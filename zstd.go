@@ -0,0 +1,61 @@
+package memfs
+
+import (
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// SaveZstdToFile saves the entire filesystem structure to a GOB encoded file after compressing the data using zstd
+func (rootFS *FS) SaveZstdToFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return rootFS.SaveZstdTo(f)
+}
+
+// SaveZstdTo saves the filesystem structure to any io.Writer in GOB format
+// after compressing the data using zstd, which compresses better and faster
+// than the gzip of CompressAndSaveTo
+func (rootFS *FS) SaveZstdTo(w io.Writer) error {
+	// Create a zstd writer
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	// Encode and save the filesystem
+	if err := rootFS.encode(zw); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// LoadZstdFromFile loads the entire filesystem structure from a GOB encoded file after decompressing the data using zstd
+func LoadZstdFromFile(filename string) (*FS, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadZstdFrom(f)
+}
+
+// LoadZstdFrom loads the filesystem structure from any io.Reader in GOB format after decompressing the data using zstd
+func LoadZstdFrom(r io.Reader) (*FS, error) {
+	// Create a zstd reader
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	// Decode and load the filesystem
+	return decode(zr)
+}